@@ -0,0 +1,263 @@
+// Package alert extracts events from the alert emails of the cameras
+package alert
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is the type of the detected event
+type Event string
+
+const (
+	EventMotion       Event = "motion"
+	EventHuman        Event = "human"
+	EventLineCrossing Event = "line_crossing"
+	EventOther        Event = "other"
+)
+
+// Message is the alert email
+type Message struct {
+	From    string
+	Subject string
+	// Text is the plain text body or the snippet of the email
+	Text string
+}
+
+// Alert is the event described by the email
+type Alert struct {
+	// Rule is the name of the rule matched the email
+	Rule   string
+	Camera string
+	Serial string
+	Event  Event
+	// Time is the camera clock time, it's in Location when the email has
+	// the time zone, otherwise only the wall clock is valid
+	Time     time.Time
+	Location *time.Location
+}
+
+// In returns the alert time, the wall clock is taken in the location
+// when the email has no time zone
+func (a Alert) In(loc *time.Location) time.Time {
+	if a.Location != nil {
+		return a.Time
+	}
+
+	t := a.Time
+
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
+
+// Near returns the alert time closest to the reference time, the wall
+// clock repeats in the location when the clock is turned back for DST
+func (a Alert) Near(loc *time.Location, ref time.Time) time.Time {
+	t := a.In(loc)
+
+	if a.Location != nil || ref.IsZero() {
+		return t
+	}
+
+	best := t
+
+	for _, d := range []time.Duration{-time.Hour, time.Hour} {
+		c := t.Add(d)
+
+		if c.Format(time.DateTime) == t.Format(time.DateTime) && abs(c.Sub(ref)) < abs(best.Sub(ref)) {
+			best = c
+		}
+	}
+
+	return best
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+
+	return d
+}
+
+// ErrNoTime is returned when the email matches the rule but has no time
+var ErrNoTime = errors.New("no time in the alert email")
+
+// Rule recognizes the emails of a camera vendor, the patterns extract
+// the named groups:
+//   - time: the event time parsed with the layouts
+//   - format: the time layout of the email like "D/M/Y H:M:S"
+//   - tz: the time zone offset like "+03:00" or the location name
+//   - camera, serial: the camera name and serial number
+//   - event: the event type, the whole text is classified without it
+type Rule struct {
+	Name string
+	// From matches the sender, any sender matches when it's nil
+	From *regexp.Regexp
+	// Match matches the subject or the text, any email of the sender
+	// matches when it's nil
+	Match *regexp.Regexp
+	// Patterns are applied to the subject and the text, the first match
+	// of every group is taken
+	Patterns []*regexp.Regexp
+	Layouts  []string
+}
+
+// Parse extracts the alert, ok is false when the email doesn't match the rule
+func (r Rule) Parse(m Message) (a Alert, ok bool, err error) {
+	if r.From != nil && !r.From.MatchString(m.From) {
+		return
+	}
+
+	content := m.Subject + "\n" + m.Text
+
+	if r.Match != nil && !r.Match.MatchString(content) {
+		return
+	}
+
+	groups := make(map[string]string)
+
+	for _, rx := range r.Patterns {
+		match := rx.FindStringSubmatch(content)
+
+		for i, name := range rx.SubexpNames() {
+			if i == 0 || name == "" || i >= len(match) || groups[name] != "" {
+				continue
+			}
+
+			groups[name] = strings.TrimSpace(match[i])
+		}
+	}
+
+	a = Alert{
+		Rule:   r.Name,
+		Camera: groups["camera"],
+		Serial: groups["serial"],
+		Event:  Classify(groups["event"]),
+	}
+
+	if groups["event"] == "" {
+		a.Event = Classify(content)
+	}
+
+	if groups["time"] == "" {
+		return a, true, fmt.Errorf("%s: %w", r.Name, ErrNoTime)
+	}
+
+	if groups["tz"] != "" {
+		if a.Location, err = ParseZone(groups["tz"]); err != nil {
+			return a, true, fmt.Errorf("%s: %w", r.Name, err)
+		}
+	}
+
+	layouts := r.Layouts
+
+	if groups["format"] != "" {
+		layouts = []string{Layout(groups["format"])}
+	}
+
+	loc := a.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	for _, layout := range layouts {
+		if a.Time, err = time.ParseInLocation(layout, groups["time"], loc); err == nil {
+			return a, true, nil
+		}
+	}
+
+	return a, true, fmt.Errorf("%s: invalid time %q", r.Name, groups["time"])
+}
+
+// Classify returns the event type named in the text
+func Classify(text string) Event {
+	if text == "" {
+		return EventOther
+	}
+
+	for _, c := range classes {
+		if c.rx.MatchString(text) {
+			return c.event
+		}
+	}
+
+	return EventOther
+}
+
+// classes are checked in order, the human detection is a kind of motion
+// detection in the vendor names
+var classes = []struct {
+	event Event
+	rx    *regexp.Regexp
+}{
+	{EventLineCrossing, regexp.MustCompile(`(?i)line ?crossing|tripwire|пересечени`)},
+	{EventHuman, regexp.MustCompile(`(?i)human|person|people|pedestrian|\bpir\b|человек`)},
+	{EventMotion, regexp.MustCompile(`(?i)motion|движени`)},
+}
+
+var zoneRx = regexp.MustCompile(`^(?:GMT|UTC)?\s*([+-])(\d{1,2})(?::?(\d{2}))?$`)
+
+// ParseZone returns the location of the offset like "GMT+3", "+03:00"
+// or the location name like "Europe/Berlin"
+func ParseZone(s string) (*time.Location, error) {
+	s = strings.TrimSpace(s)
+
+	if s == "GMT" || s == "UTC" {
+		return time.UTC, nil
+	}
+
+	m := zoneRx.FindStringSubmatch(s)
+	if m == nil {
+		return time.LoadLocation(s)
+	}
+
+	hours, _ := strconv.Atoi(m[2])
+	minutes, _ := strconv.Atoi(m[3])
+
+	offset := hours*3600 + minutes*60
+	if m[1] == "-" {
+		offset = -offset
+	}
+
+	return time.FixedZone(fmt.Sprintf("UTC%s%02d:%02d", m[1], hours, minutes), offset), nil
+}
+
+// Layout converts the time format of the emails like "D/M/Y H:M:S"
+// to the Go layout
+func Layout(format string) string {
+	return strings.NewReplacer("Y", "2006", "M", "01", "D", "02", "H", "15").Replace(
+		// the minutes and seconds follow the hours
+		strings.Replace(format, "H:M:S", "H:04:05", 1))
+}
+
+// Registry selects the rule of the email
+type Registry struct {
+	rules []Rule
+}
+
+// NewRegistry creates the registry of the rules, the rules are checked
+// in order
+func NewRegistry(rules ...Rule) *Registry {
+	return &Registry{rules: rules}
+}
+
+// Default returns the registry of the custom rules and the built-in rules
+func Default(custom ...Rule) *Registry {
+	return NewRegistry(append(append([]Rule{}, custom...), Builtin...)...)
+}
+
+// Parse extracts the alert with the first matching rule, ok is false when
+// no rule matches the email
+func (r *Registry) Parse(m Message) (a Alert, ok bool, err error) {
+	for _, rule := range r.rules {
+		if a, ok, err = rule.Parse(m); ok {
+			return
+		}
+	}
+
+	return
+}
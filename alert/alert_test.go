@@ -0,0 +1,257 @@
+package alert_test
+
+import (
+	"camrec/alert"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// readMessage reads the sample email of the testdata directory
+func readMessage(t *testing.T, name string) alert.Message {
+	t.Helper()
+
+	f, err := os.Open(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	defer f.Close()
+
+	msg, err := mail.ReadMessage(f)
+	require.NoError(t, err)
+
+	text, err := io.ReadAll(msg.Body)
+	require.NoError(t, err)
+
+	return alert.Message{
+		From:    msg.Header.Get("From"),
+		Subject: msg.Header.Get("Subject"),
+		Text:    string(text),
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		file string
+		want alert.Alert
+	}{
+		{"ezviz-ru.eml", alert.Alert{
+			Rule:   "ezviz",
+			Camera: "C3WN",
+			Serial: "K49112334",
+			Event:  alert.EventMotion,
+			Time:   time.Date(2023, 8, 30, 22, 41, 6, 0, time.UTC),
+		}},
+		{"ezviz-human.eml", alert.Alert{
+			Rule:   "ezviz",
+			Camera: "C6N",
+			Serial: "E12345678",
+			Event:  alert.EventHuman,
+			Time:   time.Date(2024, 3, 10, 2, 30, 15, 0, time.UTC),
+		}},
+		{"hikconnect.eml", alert.Alert{
+			Rule:     "ezviz",
+			Camera:   "Garage",
+			Serial:   "Q12345678",
+			Event:    alert.EventLineCrossing,
+			Time:     time.Date(2024, 1, 4, 23, 12, 45, 0, time.UTC),
+			Location: time.FixedZone("UTC+08:00", 8*3600),
+		}},
+		{"hikvision.eml", alert.Alert{
+			Rule:   "hikvision",
+			Camera: "Backyard",
+			Serial: "DS-7608NI-K20820201001CCRR123456789WCVU",
+			Event:  alert.EventLineCrossing,
+			Time:   time.Date(2024, 2, 14, 18, 3, 27, 0, time.UTC),
+		}},
+		{"hikvision-camera.eml", alert.Alert{
+			Rule:   "hikvision",
+			Camera: "Front Door",
+			Serial: "DS-2CD2143G0-I20190101AAWRD12345678",
+			Event:  alert.EventMotion,
+			Time:   time.Date(2023, 8, 30, 22, 41, 6, 0, time.UTC),
+		}},
+		{"dahua.eml", alert.Alert{
+			Rule:   "dahua",
+			Camera: "IPC-HFW2431S",
+			Event:  alert.EventHuman,
+			Time:   time.Date(2023, 8, 30, 22, 41, 6, 0, time.UTC),
+		}},
+		{"dahua-iso.eml", alert.Alert{
+			Rule:   "dahua",
+			Camera: "Warehouse",
+			Serial: "6J0C5B2PAZ12345",
+			Event:  alert.EventLineCrossing,
+			Time:   time.Date(2023, 11, 2, 5, 0, 1, 0, time.UTC),
+		}},
+		{"reolink.eml", alert.Alert{
+			Rule:   "reolink",
+			Camera: "Driveway",
+			Event:  alert.EventHuman,
+			Time:   time.Date(2024, 3, 10, 2, 30, 15, 0, time.UTC),
+		}},
+		{"reolink-motion.eml", alert.Alert{
+			Rule:     "reolink",
+			Camera:   "Porch",
+			Event:    alert.EventMotion,
+			Time:     time.Date(2024, 10, 27, 1, 15, 0, 0, time.UTC),
+			Location: time.FixedZone("UTC+01:00", 3600),
+		}},
+	}
+
+	registry := alert.Default()
+
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			a, ok, err := registry.Parse(readMessage(t, tt.file))
+			require.NoError(t, err)
+			require.True(t, ok)
+
+			require.True(t, tt.want.Time.Equal(a.Time), "time %s", a.Time)
+			require.Equal(t, tt.want.Location.String(), a.Location.String())
+
+			a.Time, a.Location = tt.want.Time, tt.want.Location
+			require.Equal(t, tt.want, a)
+		})
+	}
+
+	t.Run("unknown sender", func(t *testing.T) {
+		_, ok, err := registry.Parse(readMessage(t, "unknown.eml"))
+		require.NoError(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("no time", func(t *testing.T) {
+		a, ok, err := registry.Parse(readMessage(t, "ezviz-no-time.eml"))
+		require.ErrorIs(t, err, alert.ErrNoTime)
+		require.True(t, ok)
+		require.Equal(t, "K49112334", a.Serial)
+	})
+}
+
+func TestIn(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	a := alert.Alert{Time: time.Date(2023, 8, 30, 22, 41, 6, 0, time.UTC)}
+	require.Equal(t, time.Date(2023, 8, 30, 22, 41, 6, 0, berlin), a.In(berlin))
+
+	// the time zone of the email is kept
+	a.Location = time.FixedZone("UTC+08:00", 8*3600)
+	require.True(t, a.Time.Equal(a.In(berlin)))
+}
+
+func TestNear(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	// 02:30 happens twice when the clock is turned back at 03:00 CEST
+	a := alert.Alert{Time: time.Date(2024, 10, 27, 2, 30, 0, 0, time.UTC)}
+
+	summer := time.Date(2024, 10, 27, 0, 30, 0, 0, time.UTC)
+	winter := time.Date(2024, 10, 27, 1, 30, 0, 0, time.UTC)
+
+	require.True(t, summer.Equal(a.Near(berlin, summer.Add(10*time.Second))))
+	require.True(t, winter.Equal(a.Near(berlin, winter.Add(10*time.Second))))
+
+	// the wall clock is unique in the rest of the day
+	a.Time = time.Date(2024, 10, 27, 12, 0, 0, 0, time.UTC)
+	require.True(t, time.Date(2024, 10, 27, 11, 0, 0, 0, time.UTC).Equal(a.Near(berlin, winter)))
+}
+
+func TestParseZone(t *testing.T) {
+	tests := []struct {
+		zone   string
+		offset int
+	}{
+		{"GMT+8", 8 * 3600},
+		{"+03:00", 3 * 3600},
+		{"UTC-0530", -(5*3600 + 30*60)},
+		{"GMT", 0},
+	}
+
+	for _, tt := range tests {
+		loc, err := alert.ParseZone(tt.zone)
+		require.NoError(t, err)
+
+		_, offset := time.Date(2024, 1, 1, 0, 0, 0, 0, loc).Zone()
+		require.Equal(t, tt.offset, offset, tt.zone)
+	}
+
+	loc, err := alert.ParseZone("Europe/Berlin")
+	require.NoError(t, err)
+	require.Equal(t, "Europe/Berlin", loc.String())
+
+	_, err = alert.ParseZone("Mars/Olympus")
+	require.Error(t, err)
+}
+
+func TestClassify(t *testing.T) {
+	tests := map[string]alert.Event{
+		"Motion Detection": alert.EventMotion,
+		"Сигнал обнаружения движения":    alert.EventMotion,
+		"Human Body Detection":           alert.EventHuman,
+		"PIR Alarm":                      alert.EventHuman,
+		"Line Crossing Detection":        alert.EventLineCrossing,
+		"Tripwire":                       alert.EventLineCrossing,
+		"Video Tampering":                alert.EventOther,
+		"The alarm expired, it's a tamp": alert.EventOther,
+		"":                               alert.EventOther,
+	}
+
+	for text, event := range tests {
+		require.Equal(t, event, alert.Classify(text), text)
+	}
+}
+
+func TestRules(t *testing.T) {
+	t.Run("generic rule", func(t *testing.T) {
+		t.Setenv("ALERT_RULES", "vms")
+		t.Setenv("VMS_FROM", `@vms\.example\.com`)
+		t.Setenv("VMS_PATTERN", `(?P<camera>\w+) detected (?P<event>\w+) at (?P<time>\d{2}\.\d{2}\.\d{4} \d{2}:\d{2}) (?P<tz>\S+)`)
+		t.Setenv("VMS_LAYOUT", "02.01.2006 15:04")
+
+		rules, err := alert.Rules()
+		require.NoError(t, err)
+		require.Len(t, rules, 1)
+
+		registry := alert.Default(rules...)
+
+		a, ok, err := registry.Parse(alert.Message{
+			From: "alerts@vms.example.com",
+			Text: "gate detected person at 30.08.2023 22:41 Europe/Berlin",
+		})
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "vms", a.Rule)
+		require.Equal(t, "gate", a.Camera)
+		require.Equal(t, alert.EventHuman, a.Event)
+		require.Equal(t, "Europe/Berlin", a.Location.String())
+		require.True(t, time.Date(2023, 8, 30, 20, 41, 0, 0, time.UTC).Equal(a.Time))
+
+		// the built-in rules still apply
+		a, ok, err = registry.Parse(readMessage(t, "dahua.eml"))
+		require.NoError(t, err)
+		require.True(t, ok)
+		require.Equal(t, "dahua", a.Rule)
+	})
+
+	t.Run("no time group", func(t *testing.T) {
+		t.Setenv("ALERT_RULES", "vms")
+		t.Setenv("VMS_PATTERN", `(?P<camera>\w+)`)
+
+		_, err := alert.Rules()
+		require.Error(t, err)
+	})
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		t.Setenv("ALERT_RULES", "vms")
+		t.Setenv("VMS_PATTERN", `(?P<time>`)
+
+		_, err := alert.Rules()
+		require.Error(t, err)
+	})
+}
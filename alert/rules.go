@@ -0,0 +1,132 @@
+package alert
+
+import (
+	"camrec/config"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// zone is the optional time zone after the time like "GMT+08:00"
+const zone = `(?:[ \t]*\(?(?:GMT|UTC)(?P<tz>[+-]\d{1,2}(?::?\d{2})?)\)?)?`
+
+// EZVIZ and Hik-Connect cloud notifications, the snippet is like
+// "C3WN(K49112334) Motion Detection Alarm C3WN(K49112334) 2023-08-30 22:41:06"
+var EZVIZ = Rule{
+	Name: "ezviz",
+	From: regexp.MustCompile(`(?i)@(hicloudcam|ezviz\w*|hik-connect)\.com`),
+	Patterns: []*regexp.Regexp{
+		regexp.MustCompile(`(?P<camera>[^\s()]+)\((?P<serial>[A-Z0-9]{8,})\)`),
+		regexp.MustCompile(`(?P<time>\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})` + zone),
+	},
+	Layouts: []string{time.DateTime},
+}
+
+// Hikvision emails sent by the cameras and recorders with SMTP
+var Hikvision = Rule{
+	Name:  "hikvision",
+	Match: regexp.MustCompile(`(?i)auto-sent e-?mail from|EVENT TYPE:`),
+	Patterns: []*regexp.Regexp{
+		regexp.MustCompile(`EVENT TYPE:[ \t]*(?P<event>[^\r\n]+)`),
+		regexp.MustCompile(`EVENT TIME:[ \t]*(?P<time>\d{4}-\d{2}-\d{2}[, T]\d{2}:\d{2}:\d{2})` + zone),
+		// the channel name of the recorder is preferred
+		regexp.MustCompile(`CAMERA NAME\(NUM\):[ \t]*(?P<camera>[^\r\n(]+)`),
+		regexp.MustCompile(`DEVICE NAME:[ \t]*(?P<camera>[^\r\n]+)`),
+		regexp.MustCompile(`DEVICE S/N:[ \t]*(?P<serial>\S+)`),
+	},
+	Layouts: []string{"2006-01-02,15:04:05", time.DateTime, "2006-01-02T15:04:05"},
+}
+
+// Dahua emails sent by the cameras and recorders with SMTP, the time
+// format is in the field name like "Alarm Start Time(D/M/Y H:M:S)"
+var Dahua = Rule{
+	Name:  "dahua",
+	Match: regexp.MustCompile(`Alarm Start Time`),
+	Patterns: []*regexp.Regexp{
+		regexp.MustCompile(`Alarm Event:[ \t]*(?P<event>[^\r\n]+)`),
+		regexp.MustCompile(`Alarm Start Time(?:\((?P<format>[DMY/.\- ]+H:M:S)\))?:[ \t]*(?P<time>[\d/.\-]+ \d{2}:\d{2}:\d{2})` + zone),
+		regexp.MustCompile(`Alarm Device Name:[ \t]*(?P<camera>[^\r\n]+)`),
+		regexp.MustCompile(`(?i)Serial No\.?:[ \t]*(?P<serial>\S+)`),
+	},
+	Layouts: []string{time.DateTime, "02/01/2006 15:04:05"},
+}
+
+// Reolink emails sent by the cameras with SMTP, the date is in the
+// US order unless the camera is set to the ISO format
+var Reolink = Rule{
+	Name:  "reolink",
+	Match: regexp.MustCompile(`Alarm Camera Name:`),
+	Patterns: []*regexp.Regexp{
+		regexp.MustCompile(`Alarm Camera Name:[ \t]*(?P<camera>[^\r\n]+)`),
+		regexp.MustCompile(`Alarm Event:[ \t]*(?P<event>[^\r\n]+)`),
+		regexp.MustCompile(`Alarm Time:[ \t]*(?P<time>[\d/\-]+ \d{2}:\d{2}:\d{2})` + zone),
+	},
+	Layouts: []string{time.DateTime, "01/02/2006 15:04:05"},
+}
+
+// Builtin are the rules of the supported vendors
+var Builtin = []Rule{EZVIZ, Hikvision, Dahua, Reolink}
+
+// Rules returns the custom rules configured with the environment variables,
+// ALERT_RULES lists rule IDs configured with <ID>_FROM, <ID>_MATCH,
+// <ID>_PATTERN and <ID>_LAYOUT, the pattern has the named groups of Rule
+func Rules() (rules []Rule, err error) {
+	ids := strings.FieldsFunc(os.Getenv("ALERT_RULES"), func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+
+	for _, id := range ids {
+		r := Rule{
+			Name:    id,
+			Layouts: []string{time.DateTime},
+		}
+
+		if layout := config.Get(id, "LAYOUT"); layout != "" {
+			r.Layouts = []string{layout}
+		}
+
+		if r.From, err = compile(id, "FROM"); err != nil {
+			return
+		}
+
+		if r.Match, err = compile(id, "MATCH"); err != nil {
+			return
+		}
+
+		pattern, err := compile(id, "PATTERN")
+		if err != nil {
+			return nil, err
+		}
+
+		if pattern == nil {
+			return nil, fmt.Errorf("alert rule %s: no pattern", id)
+		}
+
+		if pattern.SubexpIndex("time") < 0 {
+			return nil, fmt.Errorf("alert rule %s: no time group in the pattern", id)
+		}
+
+		r.Patterns = []*regexp.Regexp{pattern}
+
+		rules = append(rules, r)
+	}
+
+	return
+}
+
+// compile returns the regular expression of the setting, nil if it's not set
+func compile(id, key string) (*regexp.Regexp, error) {
+	expr := config.Get(id, key)
+	if expr == "" {
+		return nil, nil
+	}
+
+	rx, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("alert rule %s: %s: %w", id, strings.ToLower(key), err)
+	}
+
+	return rx, nil
+}
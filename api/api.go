@@ -0,0 +1,342 @@
+// Package api serves the local HTTP API of the running recorder,
+// the CLI commands talk to the daemon through it
+package api
+
+import (
+	"bytes"
+	"camrec/clock"
+	"camrec/config"
+	"camrec/event"
+	"camrec/snapshot"
+	"camrec/trigger"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultListen is the Unix socket in the working directory, the TCP
+// address like 127.0.0.1:8765 is accepted as well
+const DefaultListen = "unix:camrec.sock"
+
+// TriggerRequest is the manual trigger, the zero time means now
+type TriggerRequest struct {
+	Camera string    `json:"camera,omitempty"`
+	Time   time.Time `json:"time,omitempty"`
+	Pre    Duration  `json:"pre,omitempty"`
+	Post   Duration  `json:"post,omitempty"`
+	Note   string    `json:"note,omitempty"`
+}
+
+// Duration is encoded as the duration string like 1m30s
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(v)
+
+	return nil
+}
+
+// ErrClosed is returned for the triggers after the server is closed
+var ErrClosed = errors.New("recorder is shutting down")
+
+// Server handles the API requests, the triggers are passed to the
+// recorder through the Triggers channel
+type Server struct {
+	// MaxSpan limits the video before and after the manual triggers
+	MaxSpan time.Duration
+	// Snapshot returns the latest snapshot of the camera ID,
+	// the snapshots aren't served when it's nil
+	Snapshot func(ctx context.Context, camera string) (snapshot.Image, error)
+
+	cameras  []config.Camera
+	clock    clock.Clock
+	mux      *http.ServeMux
+	lock     sync.RWMutex
+	closed   bool
+	triggers chan trigger.Trigger
+}
+
+func NewServer(cameras []config.Camera) *Server {
+	s := &Server{
+		cameras:  cameras,
+		clock:    clock.Real,
+		mux:      http.NewServeMux(),
+		triggers: make(chan trigger.Trigger),
+	}
+
+	s.mux.HandleFunc("/triggers", s.handleTrigger)
+	s.mux.HandleFunc("/events/", s.handleEvent)
+	s.mux.HandleFunc("/cameras/", s.handleSnapshot)
+
+	return s
+}
+
+// SetClock sets the clock of the triggers without time
+func (s *Server) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// Triggers returns the channel of the manual triggers, it's closed
+// when the server is closed
+func (s *Server) Triggers() <-chan trigger.Trigger {
+	return s.triggers
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Serve serves the API on the address until the context is done
+func (s *Server) Serve(ctx context.Context, addr string) error {
+	defer s.Close()
+
+	ln, err := Listen(addr)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	slog.Info("start API", "addr", addr)
+
+	if err = server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// Close closes the trigger channel, the later triggers are rejected
+func (s *Server) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		close(s.triggers)
+	}
+}
+
+func (s *Server) handleTrigger(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+
+		return
+	}
+
+	var req TriggerRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+
+	accepted, err := s.Trigger(r.Context(), req)
+
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusAccepted, accepted)
+	case errors.Is(err, ErrClosed):
+		writeError(w, http.StatusServiceUnavailable, err)
+	case r.Context().Err() == nil:
+		writeError(w, http.StatusBadRequest, err)
+	}
+}
+
+// Trigger passes the manual trigger to the recorder, the trigger without
+// time takes the current time, the accepted request is returned
+func (s *Server) Trigger(ctx context.Context, req TriggerRequest) (TriggerRequest, error) {
+	if err := s.validate(req); err != nil {
+		return req, err
+	}
+
+	if req.Time.IsZero() {
+		req.Time = s.clock.Now()
+	}
+
+	t := trigger.Trigger{
+		Time:   req.Time,
+		Camera: req.Camera,
+		Event:  "manual",
+		Pre:    time.Duration(req.Pre),
+		Post:   time.Duration(req.Post),
+		Note:   req.Note,
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.closed {
+		return req, ErrClosed
+	}
+
+	select {
+	case s.triggers <- t:
+	case <-ctx.Done():
+		return req, ctx.Err()
+	}
+
+	slog.Info("manual trigger", "time", t.Time, "camera", t.Camera)
+
+	return req, nil
+}
+
+// handleEvent serves the clip of the event ID in the path,
+// like /events/front/2024-03-01_10-00-00
+func (s *Server) handleEvent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+
+		return
+	}
+
+	info, err := event.Find(strings.TrimPrefix(r.URL.Path, "/events/"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "video/mp4")
+	http.ServeFile(w, r, info.File)
+}
+
+// handleSnapshot serves the JPEG snapshot of the camera in the path,
+// like /cameras/front/snapshot, the default camera is named default
+func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/cameras/"), "/snapshot")
+	if !ok || s.Snapshot == nil {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+
+		return
+	}
+
+	camera, ok := s.camera(name)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown camera %q", name))
+		return
+	}
+
+	img, err := s.Snapshot(r.Context(), camera.ID)
+	if err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeContent(w, r, "", img.Time, bytes.NewReader(img.Data))
+}
+
+// camera returns the camera of the name, see config.Camera.Name
+func (s *Server) camera(name string) (config.Camera, bool) {
+	for _, c := range s.cameras {
+		if c.Name() == name {
+			return c, true
+		}
+	}
+
+	return config.Camera{}, false
+}
+
+func (s *Server) validate(req TriggerRequest) error {
+	if req.Pre < 0 || req.Post < 0 {
+		return errors.New("negative pre or post duration")
+	}
+
+	if s.MaxSpan > 0 && (time.Duration(req.Pre) > s.MaxSpan || time.Duration(req.Post) > s.MaxSpan) {
+		return fmt.Errorf("pre and post durations are limited by %s", s.MaxSpan)
+	}
+
+	if req.Camera == "" {
+		return nil
+	}
+
+	for _, c := range s.cameras {
+		if c.ID == req.Camera {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown camera %q", req.Camera)
+}
+
+// errorResponse is the body of the failed requests
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(v)
+}
+
+// Listen listens the Unix socket of the address with the unix: prefix
+// or the TCP address, the socket is accessible by the owner only
+func Listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+
+	// the socket is left by the previous run
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	return ln, nil
+}
@@ -0,0 +1,183 @@
+package api_test
+
+import (
+	"camrec/api"
+	"camrec/clock"
+	"camrec/config"
+	"camrec/event"
+	"camrec/snapshot"
+	"camrec/trigger"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// serve starts the server on the Unix socket and returns its client
+func serve(t *testing.T, s *api.Server) *api.Client {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	addr := "unix:" + filepath.Join(t.TempDir(), "camrec.sock")
+	done := make(chan error)
+
+	go func() {
+		done <- s.Serve(ctx, addr)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		require.NoError(t, <-done)
+	})
+
+	c := api.NewClient(addr)
+
+	// the socket is ready once it accepts the connections
+	require.Eventually(t, func() bool {
+		_, err := c.Trigger(context.Background(), api.TriggerRequest{Pre: -1})
+		return err != nil && !errors.Is(err, api.ErrUnreachable)
+	}, time.Second, 10*time.Millisecond)
+
+	return c
+}
+
+func TestTrigger(t *testing.T) {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	s := api.NewServer([]config.Camera{{ID: "front"}, {ID: "back"}})
+	s.MaxSpan = time.Minute
+	s.SetClock(clock.NewVirtual(start))
+
+	c := serve(t, s)
+
+	t.Run("accepted", func(t *testing.T) {
+		at := start.Add(-time.Minute)
+		result := make(chan trigger.Trigger, 1)
+
+		go func() {
+			result <- <-s.Triggers()
+		}()
+
+		accepted, err := c.Trigger(context.Background(), api.TriggerRequest{
+			Camera: "front",
+			Time:   at,
+			Pre:    api.Duration(time.Minute),
+			Post:   api.Duration(30 * time.Second),
+			Note:   "parcel at the door",
+		})
+		require.NoError(t, err)
+		require.True(t, at.Equal(accepted.Time))
+
+		got := <-result
+		require.True(t, at.Equal(got.Time))
+
+		got.Time = at
+		require.Equal(t, trigger.Trigger{
+			Time:   at,
+			Camera: "front",
+			Event:  "manual",
+			Pre:    time.Minute,
+			Post:   30 * time.Second,
+			Note:   "parcel at the door",
+		}, got)
+	})
+
+	t.Run("now", func(t *testing.T) {
+		go func() {
+			<-s.Triggers()
+		}()
+
+		accepted, err := c.Trigger(context.Background(), api.TriggerRequest{})
+		require.NoError(t, err)
+		require.True(t, start.Equal(accepted.Time))
+	})
+
+	t.Run("unknown camera", func(t *testing.T) {
+		_, err := c.Trigger(context.Background(), api.TriggerRequest{Camera: "garage"})
+		require.ErrorContains(t, err, `unknown camera "garage"`)
+	})
+
+	t.Run("span limit", func(t *testing.T) {
+		_, err := c.Trigger(context.Background(), api.TriggerRequest{Pre: api.Duration(2 * time.Minute)})
+		require.ErrorContains(t, err, "limited by 1m0s")
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		s.Close()
+
+		_, ok := <-s.Triggers()
+		require.False(t, ok)
+
+		_, err := c.Trigger(context.Background(), api.TriggerRequest{})
+		require.ErrorContains(t, err, "shutting down")
+	})
+}
+
+func TestClient(t *testing.T) {
+	_, err := api.NewClient("unix:"+filepath.Join(t.TempDir(), "missing.sock")).Trigger(context.Background(), api.TriggerRequest{})
+	require.ErrorIs(t, err, api.ErrUnreachable)
+}
+
+func TestEvent(t *testing.T) {
+	event.OutputDirectory = t.TempDir()
+
+	dir := filepath.Join(event.Directory(), "front")
+	require.NoError(t, os.MkdirAll(dir, 0777))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "2024-03-01_10-00-00.mp4"), []byte("video"), 0644))
+
+	s := api.NewServer([]config.Camera{{ID: "front"}})
+
+	get := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+
+		return w
+	}
+
+	w := get(http.MethodGet, "/events/front/2024-03-01_10-00-00")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "video/mp4", w.Header().Get("Content-Type"))
+	require.Equal(t, "video", w.Body.String())
+
+	require.Equal(t, http.StatusNotFound, get(http.MethodGet, "/events/front/2024-03-01_11-00-00").Code)
+	require.Equal(t, http.StatusMethodNotAllowed, get(http.MethodDelete, "/events/front/2024-03-01_10-00-00").Code)
+}
+
+func TestSnapshot(t *testing.T) {
+	at := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	s := api.NewServer([]config.Camera{{ID: "front"}, {ID: "back"}})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+		return w
+	}
+
+	require.Equal(t, http.StatusNotFound, get("/cameras/front/snapshot").Code)
+
+	s.Snapshot = func(ctx context.Context, camera string) (snapshot.Image, error) {
+		if camera == "back" {
+			return snapshot.Image{}, errors.New("no video")
+		}
+
+		return snapshot.Image{Data: []byte("\xff\xd8jpeg"), Time: at}, nil
+	}
+
+	w := get("/cameras/front/snapshot")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+	require.Equal(t, at.Format(http.TimeFormat), w.Header().Get("Last-Modified"))
+	require.Equal(t, "\xff\xd8jpeg", w.Body.String())
+
+	require.Equal(t, http.StatusServiceUnavailable, get("/cameras/back/snapshot").Code)
+	require.Equal(t, http.StatusNotFound, get("/cameras/garage/snapshot").Code)
+	require.Equal(t, http.StatusNotFound, get("/cameras/front").Code)
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ErrUnreachable is returned when the recorder isn't running
+var ErrUnreachable = errors.New("recorder isn't reachable")
+
+// Client calls the API of the running recorder
+type Client struct {
+	base string
+	http *http.Client
+}
+
+// NewClient returns the client of the API address, see DefaultListen
+func NewClient(addr string) *Client {
+	path, ok := strings.CutPrefix(addr, "unix:")
+	if !ok {
+		return &Client{base: "http://" + addr, http: http.DefaultClient}
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+
+	// the host is ignored by the socket dialer
+	return &Client{base: "http://camrec", http: &http.Client{Transport: transport}}
+}
+
+// Trigger sends the manual trigger, the accepted trigger is returned
+func (c *Client) Trigger(ctx context.Context, req TriggerRequest) (accepted TriggerRequest, err error) {
+	err = c.call(ctx, http.MethodPost, "/triggers", req, &accepted)
+	return
+}
+
+func (c *Client) call(ctx context.Context, method, path string, in, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUnreachable, err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var e errorResponse
+
+		if err = json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+			return fmt.Errorf("recorder API: %s", resp.Status)
+		}
+
+		return fmt.Errorf("recorder API: %s", e.Error)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"camrec/mail"
+	"context"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+)
+
+// auth runs the one-time authorization of the Gmail account and saves
+// the token used by the recorder
+func auth(args []string) (err error) {
+	flags := flag.NewFlagSet("auth", flag.ContinueOnError)
+
+	device := flags.Bool("device", false, "enter the code on another device instead of the browser redirect")
+	listen := flags.String("listen", "127.0.0.1:0", "loopback address of the redirect listener")
+
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	// the scopes depend on the settings of the recorder
+	config, err := mail.Config(mailOptions().Scopes()...)
+	if err != nil {
+		return
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	tok, err := mail.NewAuth(config, mail.AuthOptions{
+		Device: *device,
+		Listen: *listen,
+	}).Token(ctx)
+	if err != nil {
+		return
+	}
+
+	if err = mail.SaveToken(mail.TokenFile, tok); err != nil {
+		return
+	}
+
+	slog.Info("token saved", "file", mail.TokenFile)
+
+	return
+}
@@ -1,140 +1,265 @@
 package buffer
 
 import (
+	"camrec/clock"
 	"camrec/event"
 	"math"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// DefaultSpan is the video saved before and after the event time
+const DefaultSpan = 30 * time.Second
+
+// segmentSize is the size of the memory blocks the chunks are copied to,
+// larger chunks take own blocks
+const segmentSize = 1 << 20
+
+// Buffer keeps the chunks of the last duration. The chunk data is written
+// once to the segments and never changed, so the reads take the snapshot
+// of the chunk list without blocking the writes, the writes are
+// serialized by the buffer
 type Buffer struct {
-	data     []byte
-	chunks   []chunk
+	// chunks is the current snapshot, the elements of the snapshot
+	// are never modified, the new chunks are appended after them
+	chunks   atomic.Pointer[[]chunk]
+	lock     sync.Mutex
+	segment  []byte
 	duration time.Duration
+	clock    clock.Clock
 }
 
 func NewBuffer(duration time.Duration) *Buffer {
-	return &Buffer{
-		data:     make([]byte, 0),
-		chunks:   make([]chunk, 0),
+	b := &Buffer{
 		duration: duration,
+		clock:    clock.Real,
 	}
+
+	b.store(make([]chunk, 0))
+
+	return b
+}
+
+// SetClock sets the clock used to trim the buffer
+func (b *Buffer) SetClock(c clock.Clock) {
+	b.clock = c
 }
 
 func (b *Buffer) Put(data []byte, ts time.Time) {
-	b.chunks = append(b.chunks, chunk{
-		offset:    len(b.data),
-		length:    len(data),
-		timestamp: ts,
-	})
+	b.PutFrame(data, ts, false)
+}
+
+// PutFrame puts the access unit, keyframes are used as clip start points
+func (b *Buffer) PutFrame(data []byte, ts time.Time, keyframe bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
 
-	b.data = append(b.data, data...)
+	b.store(append(b.snapshot(), chunk{
+		data:      b.copy(data),
+		timestamp: ts,
+		keyframe:  keyframe,
+	}))
 }
 
 func (b *Buffer) Push(data []byte) {
-	b.Put(data, time.Now())
+	b.Put(data, b.clock.Now())
 }
 
 func (b *Buffer) Trim() {
-	trimStart := -1
+	b.lock.Lock()
+	defer b.lock.Unlock()
 
-	lbound := time.Now().Add(-b.duration)
+	chunks := b.snapshot()
+	lbound := b.clock.Now().Add(-b.duration)
 
-	offsetShift := 0
+	trimStart := -1
 
-	for i, chunk := range b.chunks {
+	for i, chunk := range chunks {
 		if chunk.timestamp.Before(lbound) {
 			trimStart = i
-			offsetShift += chunk.length
 		}
 	}
 
 	if trimStart >= 0 {
-		for i := range b.chunks {
-			if i > trimStart {
-				b.chunks[i].offset -= offsetShift
-			}
-		}
-
-		b.chunks = b.chunks[trimStart+1:]
-		b.data = b.data[offsetShift:]
+		b.store(chunks[trimStart+1:])
 	}
 }
 
 func (b *Buffer) Clear() {
-	b.chunks = make([]chunk, 0)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.store(make([]chunk, 0))
 }
 
-func (b Buffer) Count() int {
-	return len(b.chunks)
+func (b *Buffer) Count() int {
+	return len(b.snapshot())
 }
 
-func (b Buffer) Size() (size int) {
-	for _, chunk := range b.chunks {
-		size += chunk.length
+func (b *Buffer) Size() (size int) {
+	for _, chunk := range b.snapshot() {
+		size += len(chunk.data)
 	}
 
 	return
 }
 
-func (b Buffer) Duration() (dur time.Duration) {
-	for i, chunk := range b.chunks {
-		if i < len(b.chunks)-1 {
-			dur += b.chunks[i+1].timestamp.Sub(chunk.timestamp)
+func (b *Buffer) Duration() (dur time.Duration) {
+	chunks := b.snapshot()
+
+	for i, chunk := range chunks {
+		if i < len(chunks)-1 {
+			dur += chunks[i+1].timestamp.Sub(chunk.timestamp)
 		} else {
-			dur += time.Since(chunk.timestamp)
+			dur += b.clock.Now().Sub(chunk.timestamp)
 		}
 	}
 
 	return
 }
 
-func (b Buffer) Usage() float64 {
+func (b *Buffer) Usage() float64 {
 	return math.Min(100, 100*float64(b.Duration())/float64(b.duration))
 }
 
+// Last returns the timestamp of the last chunk, ok is false
+// when the buffer is empty
+func (b *Buffer) Last() (ts time.Time, ok bool) {
+	chunks := b.snapshot()
+
+	if len(chunks) == 0 {
+		return
+	}
+
+	return chunks[len(chunks)-1].timestamp, true
+}
+
 // Search chunks before and after ts
-func (b Buffer) Search(ts time.Time) *event.Event {
-	if len(b.chunks) == 0 {
+func (b *Buffer) Search(ts time.Time) *event.Event {
+	return b.SearchSpan(ts, DefaultSpan, DefaultSpan)
+}
+
+// SearchSpan searches chunks within pre before ts and post after it
+func (b *Buffer) SearchSpan(ts time.Time, pre, post time.Duration) *event.Event {
+	chunks := b.snapshot()
+
+	if len(chunks) == 0 {
 		return nil
 	}
 
 	// if ts is not in range
-	if b.chunks[0].timestamp.After(ts) || b.chunks[len(b.chunks)-1].timestamp.Before(ts) {
+	if chunks[0].timestamp.After(ts) || chunks[len(chunks)-1].timestamp.Before(ts) {
 		return nil
 	}
 
-	lboundTime := ts.Add(-30 * time.Second)
-	uboundTime := ts.Add(30 * time.Second)
+	lboundTime := ts.Add(-pre)
+	uboundTime := ts.Add(post)
 
 	lboundIndex := 0
-	uboundIndex := len(b.chunks) - 1
+	uboundIndex := len(chunks) - 1
 
-	for i, chunk := range b.chunks {
+	for i, chunk := range chunks {
 		if chunk.timestamp.After(lboundTime) {
 			lboundIndex = i
 			break
 		}
 	}
 
-	for j := len(b.chunks) - 1; j > lboundIndex; j-- {
-		if b.chunks[j].timestamp.Before(uboundTime) {
+	for j := len(chunks) - 1; j > lboundIndex; j-- {
+		if chunks[j].timestamp.Before(uboundTime) {
 			uboundIndex = j
 			break
 		}
 	}
 
-	offsetStart := b.chunks[lboundIndex].offset
+	lboundIndex = keyframeIndex(chunks, lboundIndex, uboundIndex)
+	clip := chunks[lboundIndex : uboundIndex+1]
+
+	// the event refers to the chunk data, it's never changed
+	parts := make([][]byte, 0, len(clip))
+	frames := make([]event.Frame, 0, len(clip))
+	offset := 0
+
+	for _, chunk := range clip {
+		parts = append(parts, chunk.data)
+		frames = append(frames, event.Frame{
+			Offset:    offset,
+			Length:    len(chunk.data),
+			Timestamp: chunk.timestamp,
+			Keyframe:  chunk.keyframe,
+		})
+
+		offset += len(chunk.data)
+	}
+
+	e := event.NewEvent(ts, parts...)
+	e.SetSpan(clip[0].timestamp, clip[len(clip)-1].timestamp)
+	e.SetFrames(frames)
+
+	return e
+}
+
+// Slice copies chunks with timestamps within from and to,
+// start is the timestamp of the first copied chunk
+func (b *Buffer) Slice(from, to time.Time) (data []byte, start time.Time) {
+	for _, chunk := range b.snapshot() {
+		if chunk.timestamp.Before(from) || chunk.timestamp.After(to) {
+			continue
+		}
+
+		if data == nil {
+			start = chunk.timestamp
+			data = make([]byte, 0)
+		}
+
+		data = append(data, chunk.data...)
+	}
+
+	return
+}
+
+// snapshot returns the current chunks, the slice must not be modified
+func (b *Buffer) snapshot() []chunk {
+	return *b.chunks.Load()
+}
 
-	offsetEnd := offsetStart
-	for i := lboundIndex; i <= uboundIndex; i++ {
-		offsetEnd += b.chunks[i].length
+func (b *Buffer) store(chunks []chunk) {
+	b.chunks.Store(&chunks)
+}
+
+// copy writes the data after the used part of the segment, the written
+// bytes are never overwritten, the segment is dropped by the collector
+// once the chunks and the snapshots referring to it are gone
+func (b *Buffer) copy(data []byte) []byte {
+	if len(data) > segmentSize {
+		return append([]byte(nil), data...)
 	}
 
-	chunkPart := b.data[offsetStart:offsetEnd]
+	if len(b.segment)+len(data) > cap(b.segment) {
+		b.segment = make([]byte, 0, segmentSize)
+	}
 
-	found := make([]byte, len(chunkPart))
-	copy(found, chunkPart)
+	offset := len(b.segment)
+	b.segment = append(b.segment, data...)
 
-	return event.NewEvent(ts, found)
+	return b.segment[offset:len(b.segment):len(b.segment)]
+}
+
+// keyframeIndex returns index of the last keyframe at or before index,
+// or the first keyframe after it, so the clip can be decoded from the start
+func keyframeIndex(chunks []chunk, index int, limit int) int {
+	for i := index; i >= 0; i-- {
+		if chunks[i].keyframe {
+			return i
+		}
+	}
+
+	for i := index + 1; i <= limit; i++ {
+		if chunks[i].keyframe {
+			return i
+		}
+	}
 
+	return index
 }
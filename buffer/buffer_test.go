@@ -2,6 +2,8 @@ package buffer_test
 
 import (
 	"camrec/buffer"
+	"camrec/clock"
+	"fmt"
 	"testing"
 	"time"
 
@@ -15,17 +17,32 @@ func TestEmptyBuffer(t *testing.T) {
 	require.Zero(t, b.Duration())
 }
 
+var start = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// newBuffer returns the buffer with the virtual clock set to start
+func newBuffer(duration time.Duration) (*buffer.Buffer, *clock.Virtual) {
+	c := clock.NewVirtual(start)
+
+	b := buffer.NewBuffer(duration)
+	b.SetClock(c)
+
+	return b, c
+}
+
 func TestPushItem(t *testing.T) {
-	b := buffer.NewBuffer(0)
+	b, c := newBuffer(0)
 	b.Push([]byte{0, 1, 2, 3})
 	require.Equal(t, 1, b.Count())
 	require.Equal(t, 4, b.Size())
-	require.InDelta(t, 0, b.Duration(), float64(time.Millisecond))
+	require.Zero(t, b.Duration())
+
+	c.Advance(time.Second)
+	require.Equal(t, time.Second, b.Duration())
 }
 
 func TestClear(t *testing.T) {
 	t.Run("one item", func(t *testing.T) {
-		b := buffer.NewBuffer(0)
+		b, _ := newBuffer(0)
 
 		b.Push(nil)
 		b.Clear()
@@ -33,7 +50,7 @@ func TestClear(t *testing.T) {
 	})
 
 	t.Run("two items", func(t *testing.T) {
-		b := buffer.NewBuffer(0)
+		b, _ := newBuffer(0)
 
 		b.Push(nil)
 		b.Push(nil)
@@ -44,34 +61,45 @@ func TestClear(t *testing.T) {
 
 func TestTrim(t *testing.T) {
 	t.Run("everything", func(t *testing.T) {
-		b := buffer.NewBuffer(time.Second)
+		b, _ := newBuffer(time.Second)
 
-		now := time.Now()
-
-		b.Put(nil, now.Add(-2*time.Second))
+		b.Put(nil, start.Add(-2*time.Second))
 		b.Trim()
 		require.Zero(t, b.Count())
 	})
 
 	t.Run("partially", func(t *testing.T) {
-		b := buffer.NewBuffer(time.Second)
+		b, _ := newBuffer(time.Second)
 
-		now := time.Now()
+		b.Put(nil, start.Add(-2*time.Second))
+		b.Put(nil, start.Add(-100*time.Millisecond))
+		b.Put(nil, start)
+		b.Trim()
+		require.Equal(t, 2, b.Count())
+	})
 
-		b.Put(nil, now.Add(-2*time.Second))
-		b.Put(nil, now.Add(-100*time.Millisecond))
-		b.Put(nil, now)
+	t.Run("as time goes", func(t *testing.T) {
+		b, c := newBuffer(time.Second)
+
+		b.Put(nil, start)
+		b.Put(nil, start.Add(time.Second))
 		b.Trim()
 		require.Equal(t, 2, b.Count())
+
+		c.Advance(1500 * time.Millisecond)
+		b.Trim()
+		require.Equal(t, 1, b.Count())
+
+		c.Advance(time.Second)
+		b.Trim()
+		require.Zero(t, b.Count())
 	})
 }
 
 func TestNoTrim(t *testing.T) {
-	b := buffer.NewBuffer(time.Minute)
+	b, _ := newBuffer(time.Minute)
 
-	now := time.Now()
-
-	b.Put(nil, now)
+	b.Put(nil, start)
 	b.Trim()
 
 	require.Equal(t, 1, b.Count())
@@ -79,37 +107,58 @@ func TestNoTrim(t *testing.T) {
 
 func TestDuration(t *testing.T) {
 	t.Run("single item", func(t *testing.T) {
-		b := buffer.NewBuffer(time.Second)
-		b.Put(nil, time.Now().Add(-100*time.Millisecond))
-		require.InDelta(t, 100*time.Millisecond, b.Duration(), float64(time.Millisecond))
+		b, _ := newBuffer(time.Second)
+		b.Put(nil, start.Add(-100*time.Millisecond))
+		require.Equal(t, 100*time.Millisecond, b.Duration())
 	})
 
 	t.Run("with intermediate items", func(t *testing.T) {
-		b := buffer.NewBuffer(time.Second)
+		b, _ := newBuffer(time.Second)
 
-		b.Put(nil, time.Now().Add(-100*time.Millisecond))
-		b.Put(nil, time.Now().Add(-50*time.Millisecond))
+		b.Put(nil, start.Add(-100*time.Millisecond))
+		b.Put(nil, start.Add(-50*time.Millisecond))
 
-		require.InDelta(t, 100*time.Millisecond, b.Duration(), float64(time.Millisecond))
+		require.Equal(t, 100*time.Millisecond, b.Duration())
 	})
 }
 
 func TestUsage(t *testing.T) {
-	b := buffer.NewBuffer(time.Minute)
+	b, c := newBuffer(time.Minute)
 
 	require.Zero(t, b.Usage())
 
-	b.Put(nil, time.Now().Add(-30*time.Second))
-	require.InDelta(t, 50, b.Usage(), 0.01)
+	b.Put(nil, start.Add(-30*time.Second))
+	require.Equal(t, float64(50), b.Usage())
+
+	c.Advance(15 * time.Second)
+	require.Equal(t, float64(75), b.Usage())
 
 	b.Clear()
 
-	b.Put(nil, time.Now().Add(-time.Minute))
-	b.Put(nil, time.Now())
+	b.Put(nil, c.Now().Add(-time.Minute))
+	b.Put(nil, c.Now())
 
 	require.Equal(t, float64(100), b.Usage())
 }
 
+func TestSlice(t *testing.T) {
+	b := buffer.NewBuffer(time.Minute)
+
+	now := time.Now()
+
+	data, start := b.Slice(now.Add(-time.Minute), now)
+	require.Nil(t, data)
+	require.True(t, start.IsZero())
+
+	b.Put([]byte{1}, now.Add(-30*time.Second))
+	b.Put([]byte{2}, now.Add(-20*time.Second))
+	b.Put([]byte{3}, now.Add(-10*time.Second))
+
+	data, start = b.Slice(now.Add(-25*time.Second), now)
+	require.Equal(t, []byte{2, 3}, data)
+	require.Equal(t, now.Add(-20*time.Second), start)
+}
+
 func TestSearch(t *testing.T) {
 	t.Run("empty", func(t *testing.T) {
 		b := buffer.NewBuffer(0)
@@ -164,4 +213,126 @@ func TestSearch(t *testing.T) {
 		require.NotNil(t, event)
 		require.ElementsMatch(t, []byte{1, 2, 3, 4}, event.Data())
 	})
+	t.Run("starts from keyframe", func(t *testing.T) {
+		b := buffer.NewBuffer(time.Minute)
+
+		now := time.Now()
+		b.PutFrame([]byte{1}, now.Add(-50*time.Second), true)
+		b.PutFrame([]byte{2}, now.Add(-40*time.Second), false)
+		b.PutFrame([]byte{3}, now.Add(-20*time.Second), false)
+		b.PutFrame([]byte{4}, now.Add(-10*time.Second), true)
+		b.PutFrame([]byte{5}, now, false)
+
+		event := b.Search(now.Add(-5 * time.Second))
+
+		require.NotNil(t, event)
+		require.Equal(t, []byte{1, 2, 3, 4, 5}, event.Data())
+		require.Len(t, event.Frames(), 5)
+		require.Equal(t, 4, event.Frames()[4].Offset)
+		require.Equal(t, now, event.Frames()[4].Timestamp)
+		require.Equal(t, now.Add(-50*time.Second), event.Start())
+		require.Equal(t, now, event.End())
+	})
+
+	t.Run("span", func(t *testing.T) {
+		b := buffer.NewBuffer(time.Minute)
+
+		now := time.Now()
+		b.PutFrame([]byte{1}, now.Add(-50*time.Second), true)
+		b.PutFrame([]byte{2}, now.Add(-40*time.Second), true)
+		b.PutFrame([]byte{3}, now.Add(-20*time.Second), true)
+		b.PutFrame([]byte{4}, now.Add(-10*time.Second), true)
+		b.PutFrame([]byte{5}, now, true)
+
+		event := b.SearchSpan(now.Add(-20*time.Second), 15*time.Second, 15*time.Second)
+
+		require.NotNil(t, event)
+		require.Equal(t, []byte{3, 4}, event.Data())
+	})
+
+	t.Run("skips to the first keyframe", func(t *testing.T) {
+		b := buffer.NewBuffer(time.Minute)
+
+		now := time.Now()
+		b.PutFrame([]byte{1}, now.Add(-20*time.Second), false)
+		b.PutFrame([]byte{2}, now.Add(-10*time.Second), true)
+		b.PutFrame([]byte{3}, now, false)
+
+		event := b.Search(now.Add(-5 * time.Second))
+
+		require.NotNil(t, event)
+		require.Equal(t, []byte{2, 3}, event.Data())
+	})
+}
+
+func TestConcurrentReads(t *testing.T) {
+	b, c := newBuffer(200 * time.Millisecond)
+
+	// the frame bytes start with its index, so torn reads break the sequence
+	frame := func(i int) []byte {
+		return append([]byte{byte(i), byte(i >> 8)}, make([]byte, 4094)...)
+	}
+
+	done := make(chan struct{})
+	written := make(chan int)
+
+	// the frames take a new segment every 256 frames
+	go func() {
+		i := 0
+
+		for ; ; i++ {
+			select {
+			case <-done:
+				written <- i
+				return
+			default:
+			}
+
+			c.Advance(time.Millisecond)
+			b.Trim()
+			b.PutFrame(frame(i), c.Now(), i%25 == 0)
+		}
+	}()
+
+	errs := make(chan error)
+
+	for r := 0; r < 4; r++ {
+		go func() {
+			for n := 0; n < 200; n++ {
+				b.Count()
+				b.Size()
+				b.Usage()
+
+				e := b.Search(c.Now().Add(-100 * time.Millisecond))
+				if e == nil {
+					continue
+				}
+
+				data := e.Data()
+				prev := -1
+
+				for _, f := range e.Frames() {
+					index := int(data[f.Offset]) | int(data[f.Offset+1])<<8
+
+					if prev >= 0 && index != (prev+1)&0xffff {
+						errs <- fmt.Errorf("frame %d follows frame %d", index, prev)
+						return
+					}
+
+					prev = index
+				}
+			}
+
+			errs <- nil
+		}()
+	}
+
+	for r := 0; r < 4; r++ {
+		require.NoError(t, <-errs)
+	}
+
+	close(done)
+
+	require.Greater(t, <-written, 256)
+	require.Equal(t, 201, b.Count())
 }
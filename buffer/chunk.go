@@ -6,15 +6,15 @@ import (
 )
 
 type chunk struct {
-	offset    int
-	length    int
+	data      []byte
 	timestamp time.Time
+	keyframe  bool
 }
 
 func (c chunk) String() string {
 	return fmt.Sprintf(
 		"%s: %d bytes",
 		c.timestamp.Format(time.RFC1123),
-		c.length,
+		len(c.data),
 	)
 }
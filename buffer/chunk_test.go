@@ -24,7 +24,7 @@ func TestFormatChunk(t *testing.T) {
 
 		c := chunk{
 			timestamp: ts,
-			length:    4,
+			data:      make([]byte, 4),
 		}
 
 		require.Equal(t, "Sat, 10 Mar 2012 14:05:22 +04: 4 bytes", c.String())
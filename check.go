@@ -0,0 +1,217 @@
+package main
+
+import (
+	"camrec/config"
+	"camrec/mail"
+	"camrec/queue"
+	"camrec/stream"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+)
+
+// checkConfig validates the settings and prints them, the streams
+// are probed on request
+func checkConfig(args []string) (err error) {
+	flags := flag.NewFlagSet("check-config", flag.ContinueOnError)
+
+	probeStreams := flags.Bool("probe", false, "probe the camera streams with ffprobe")
+
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	s, err := loadSettings()
+	if err != nil {
+		return fmt.Errorf("configuration failed: %w", err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "CAMERA\tSOURCE\tSTREAM\tALERT\tTIME ZONE")
+
+	for _, c := range s.cameras {
+		tz := "local"
+		if c.Location != nil {
+			tz = c.Location.String()
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", c.Name(), c.Source, redact(c.Stream), c.Alert, tz)
+	}
+
+	w.Flush()
+
+	var rules []string
+	for _, r := range s.rules {
+		rules = append(rules, r.Name)
+	}
+
+	fmt.Printf("\ncustom alert rules: %s\n", listOrNone(rules))
+	fmt.Printf("mail: %s\n", mailMode(s.mail))
+	fmt.Printf("queue: %s\n", queueState(s.queueFile))
+	fmt.Printf("API: %s\n", s.apiListen)
+	fmt.Printf("events: %s\n", s.outputDir)
+	fmt.Printf("email: %s\n", emailMode(s))
+	fmt.Printf("Telegram: %s\n", telegramMode(s))
+	fmt.Printf("webhooks: %s\n", webhookTargets(s))
+
+	var errs []error
+
+	if _, _, err := notifiers(s); err != nil {
+		errs = append(errs, fmt.Errorf("notify: %w", err))
+	}
+
+	if _, err := webhooks(s); err != nil {
+		errs = append(errs, fmt.Errorf("notify: %w", err))
+	}
+
+	for _, file := range []string{mail.CredentialsFile, mail.TokenFile} {
+		if _, err := os.Stat(file); err != nil {
+			errs = append(errs, fmt.Errorf("mail: %w", err))
+		}
+	}
+
+	if *probeStreams {
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer cancel()
+
+		for _, c := range s.cameras {
+			if err := probeCamera(ctx, c); err != nil {
+				errs = append(errs, fmt.Errorf("camera %s: %w", c.Name(), err))
+			}
+		}
+	}
+
+	if err = errors.Join(errs...); err != nil {
+		return
+	}
+
+	fmt.Println("\nconfiguration is valid")
+
+	return
+}
+
+// probe prints the parameters of the stream URL, the file or
+// the configured camera
+func probe(args []string) (err error) {
+	flags := flag.NewFlagSet("probe", flag.ContinueOnError)
+
+	camera := flags.String("camera", "", "probe the stream of the configured camera")
+
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	switch {
+	case *camera != "" && flags.NArg() == 0:
+		cameras, err := config.Cameras()
+		if err != nil {
+			return err
+		}
+
+		for _, c := range cameras {
+			if c.ID == *camera {
+				return probeCamera(ctx, c)
+			}
+		}
+
+		return fmt.Errorf("unknown camera %q", *camera)
+
+	case *camera == "" && flags.NArg() == 1:
+		return probeCamera(ctx, config.Camera{Stream: flags.Arg(0)})
+	}
+
+	flags.Usage()
+
+	return errors.New("either the stream URL or the camera is required")
+}
+
+func probeCamera(ctx context.Context, c config.Camera) error {
+	info, err := stream.Probe(ctx, c.Stream)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s: %s\n", redact(c.Stream), info)
+
+	return info.Validate()
+}
+
+// redact hides the password of the stream URL
+func redact(stream string) string {
+	u, err := url.Parse(stream)
+	if err != nil || u.User == nil {
+		return stream
+	}
+
+	return u.Redacted()
+}
+
+// mailMode describes how the mailbox is checked
+func mailMode(opts mail.Options) string {
+	switch {
+	case opts.Topic != "" && opts.PushListen != "":
+		return "watch " + opts.Topic + ", push on " + opts.PushListen
+	case opts.Topic != "" && opts.Subscription != "":
+		return "watch " + opts.Topic + ", pull " + opts.Subscription
+	case opts.Topic != "":
+		return "watch " + opts.Topic
+	}
+
+	return "poll " + opts.Query
+}
+
+// emailMode describes where the saved events are emailed
+func emailMode(s settings) string {
+	if s.smtp == nil {
+		return "off"
+	}
+
+	return fmt.Sprintf("%s via %s", listOrNone(s.smtp.To), s.smtp.Addr)
+}
+
+// telegramMode describes the chat of the bot
+func telegramMode(s settings) string {
+	if s.telegram == nil {
+		return "off"
+	}
+
+	return fmt.Sprintf("chat %d", s.telegram.ChatID)
+}
+
+// webhookTargets lists the webhook URLs
+func webhookTargets(s settings) string {
+	var targets []string
+	for _, w := range s.webhooks {
+		targets = append(targets, redact(w.URL))
+	}
+
+	return listOrNone(targets)
+}
+
+func listOrNone(list []string) string {
+	if len(list) == 0 {
+		return "none"
+	}
+
+	return strings.Join(list, ", ")
+}
+
+// queueState describes the journal and its triggers
+func queueState(path string) string {
+	q, err := queue.Load(path)
+	if err != nil {
+		return fmt.Sprintf("%s (%s)", path, err)
+	}
+
+	return fmt.Sprintf("%s (%d pending, %d failed)", path, len(q.Pending()), len(q.Dead()))
+}
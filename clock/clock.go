@@ -0,0 +1,179 @@
+// Package clock abstracts the current time and timers, so recorded streams
+// can be processed faster than real time and tests don't wait for the
+// wall clock
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+	// After sends the current time on the channel after the duration
+	After(d time.Duration) <-chan time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the system clock
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTicker) Stop() {
+	t.t.Stop()
+}
+
+// Virtual is the clock moved forward by its owner, timers and tickers
+// fire when the clock passes their time
+type Virtual struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []*waiter
+}
+
+type waiter struct {
+	clock  *Virtual
+	when   time.Time
+	period time.Duration
+	c      chan time.Time
+}
+
+func NewVirtual(now time.Time) *Virtual {
+	return &Virtual{now: now}
+}
+
+func (c *Virtual) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+func (c *Virtual) After(d time.Duration) <-chan time.Time {
+	return c.add(d, 0).c
+}
+
+func (c *Virtual) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+
+	return c.add(d, d)
+}
+
+// Set moves the clock to the time and fires the due timers,
+// the clock never goes backward
+func (c *Virtual) Set(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !now.After(c.now) {
+		return
+	}
+
+	c.now = now
+
+	waiters := c.waiters[:0]
+
+	for _, w := range c.waiters {
+		if w.when.After(now) {
+			waiters = append(waiters, w)
+			continue
+		}
+
+		// the ticks are dropped for slow receivers like time.Ticker does
+		select {
+		case w.c <- w.when:
+		default:
+		}
+
+		if w.period > 0 {
+			for !w.when.After(now) {
+				w.when = w.when.Add(w.period)
+			}
+
+			waiters = append(waiters, w)
+		}
+	}
+
+	c.waiters = waiters
+}
+
+// Advance moves the clock forward by the duration
+func (c *Virtual) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Waiters returns the number of pending timers and tickers,
+// so tests can wait until the code under test starts waiting
+func (c *Virtual) Waiters() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.waiters)
+}
+
+func (c *Virtual) add(d, period time.Duration) *waiter {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	w := &waiter{
+		clock:  c,
+		when:   c.now.Add(d),
+		period: period,
+		c:      make(chan time.Time, 1),
+	}
+
+	if d <= 0 {
+		w.c <- c.now
+		return w
+	}
+
+	c.waiters = append(c.waiters, w)
+
+	return w
+}
+
+func (w *waiter) C() <-chan time.Time {
+	return w.c
+}
+
+func (w *waiter) Stop() {
+	c := w.clock
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for i, v := range c.waiters {
+		if v == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			return
+		}
+	}
+}
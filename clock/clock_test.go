@@ -0,0 +1,71 @@
+package clock_test
+
+import (
+	"camrec/clock"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVirtual(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := clock.NewVirtual(start)
+
+	require.Equal(t, start, c.Now())
+
+	c.Set(start.Add(time.Hour))
+	require.Equal(t, start.Add(time.Hour), c.Now())
+
+	// the clock never goes backward
+	c.Set(start)
+	require.Equal(t, start.Add(time.Hour), c.Now())
+}
+
+func TestVirtualTimers(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("after", func(t *testing.T) {
+		c := clock.NewVirtual(start)
+
+		ch := c.After(time.Minute)
+		require.Equal(t, 1, c.Waiters())
+
+		c.Advance(59 * time.Second)
+		require.Empty(t, ch)
+
+		c.Advance(time.Second)
+		require.Equal(t, start.Add(time.Minute), <-ch)
+		require.Zero(t, c.Waiters())
+	})
+
+	t.Run("after zero duration", func(t *testing.T) {
+		c := clock.NewVirtual(start)
+
+		require.Equal(t, start, <-c.After(0))
+		require.Zero(t, c.Waiters())
+	})
+
+	t.Run("ticker", func(t *testing.T) {
+		c := clock.NewVirtual(start)
+
+		ticker := c.NewTicker(5 * time.Second)
+
+		c.Advance(5 * time.Second)
+		require.Equal(t, start.Add(5*time.Second), <-ticker.C())
+
+		// missed ticks are dropped
+		c.Advance(time.Minute)
+		require.Equal(t, start.Add(10*time.Second), <-ticker.C())
+		require.Empty(t, ticker.C())
+
+		c.Advance(5 * time.Second)
+		require.Equal(t, start.Add(70*time.Second), <-ticker.C())
+
+		ticker.Stop()
+		require.Zero(t, c.Waiters())
+
+		c.Advance(5 * time.Second)
+		require.Empty(t, ticker.C())
+	})
+}
@@ -0,0 +1,79 @@
+package codec
+
+const adtsHeaderSize = 7
+
+// SamplesPerFrame is the number of PCM samples in AAC frame
+const SamplesPerFrame = 1024
+
+var sampleRates = []int{
+	96000, 88200, 64000, 48000, 44100, 32000,
+	24000, 22050, 16000, 12000, 11025, 8000, 7350,
+}
+
+// ADTSFrame is AAC frame with ADTS header
+type ADTSFrame struct {
+	ObjectType      uint8
+	SampleRateIndex uint8
+	Channels        uint8
+	Raw             []byte
+	Payload         []byte
+}
+
+// SampleRate returns sampling frequency in Hz
+func (f ADTSFrame) SampleRate() int {
+	if int(f.SampleRateIndex) >= len(sampleRates) {
+		return 0
+	}
+
+	return sampleRates[f.SampleRateIndex]
+}
+
+// AudioSpecificConfig returns decoder config as stored in MP4 esds box
+func (f ADTSFrame) AudioSpecificConfig() []byte {
+	return []byte{
+		f.ObjectType<<3 | f.SampleRateIndex>>1,
+		f.SampleRateIndex<<7 | f.Channels<<3,
+	}
+}
+
+// ParseADTS parses complete ADTS frames and returns the number of bytes consumed,
+// data before the first sync word is skipped
+func ParseADTS(data []byte) (frames []ADTSFrame, n int) {
+	for n+adtsHeaderSize <= len(data) {
+		h := data[n:]
+
+		if h[0] != 0xff || h[1]&0xf0 != 0xf0 {
+			n++
+			continue
+		}
+
+		length := int(h[3]&0x03)<<11 | int(h[4])<<3 | int(h[5])>>5
+
+		headerSize := adtsHeaderSize
+		if h[1]&0x01 == 0 {
+			// CRC follows the header
+			headerSize += 2
+		}
+
+		if length < headerSize {
+			n++
+			continue
+		}
+
+		if n+length > len(data) {
+			break
+		}
+
+		frames = append(frames, ADTSFrame{
+			ObjectType:      h[2]>>6 + 1,
+			SampleRateIndex: h[2] >> 2 & 0x0f,
+			Channels:        h[2]&0x01<<2 | h[3]>>6,
+			Raw:             h[:length],
+			Payload:         h[headerSize:length],
+		})
+
+		n += length
+	}
+
+	return
+}
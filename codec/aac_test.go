@@ -0,0 +1,56 @@
+package codec_test
+
+import (
+	"camrec/codec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// adtsFrame builds AAC LC 44.1 kHz stereo frame
+func adtsFrame(payload ...byte) []byte {
+	length := 7 + len(payload)
+
+	frame := []byte{
+		0xff, 0xf1, 0x50,
+		0x80 | uint8(length>>11),
+		uint8(length >> 3),
+		uint8(length&7)<<5 | 0x1f,
+		0xfc,
+	}
+
+	return append(frame, payload...)
+}
+
+func TestParseADTS(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		frames, n := codec.ParseADTS(nil)
+		require.Empty(t, frames)
+		require.Zero(t, n)
+	})
+
+	t.Run("frames", func(t *testing.T) {
+		data := append([]byte{0x00}, adtsFrame(1, 2, 3)...)
+		data = append(data, adtsFrame(4, 5)...)
+
+		frames, n := codec.ParseADTS(data)
+
+		require.Equal(t, len(data), n)
+		require.Len(t, frames, 2)
+		require.Equal(t, []byte{1, 2, 3}, frames[0].Payload)
+		require.Equal(t, adtsFrame(4, 5), frames[1].Raw)
+		require.Equal(t, uint8(2), frames[0].ObjectType)
+		require.Equal(t, uint8(2), frames[0].Channels)
+		require.Equal(t, 44100, frames[0].SampleRate())
+		require.Equal(t, []byte{0x12, 0x10}, frames[0].AudioSpecificConfig())
+	})
+
+	t.Run("incomplete frame", func(t *testing.T) {
+		data := append(adtsFrame(1), adtsFrame(2, 3)[:8]...)
+
+		frames, n := codec.ParseADTS(data)
+
+		require.Len(t, frames, 1)
+		require.Equal(t, 8, n)
+	})
+}
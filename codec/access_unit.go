@@ -0,0 +1,138 @@
+package codec
+
+import (
+	"bytes"
+	"time"
+)
+
+// AccessUnit holds all NAL units of a single picture
+type AccessUnit struct {
+	NALUnits [][]byte
+	Keyframe bool
+	// Duration is the display duration, zero if unknown
+	Duration time.Duration
+}
+
+// AnnexB returns access unit as Annex-B byte stream
+func (au AccessUnit) AnnexB() []byte {
+	size := 0
+	for _, nal := range au.NALUnits {
+		size += len(startCode) + len(nal)
+	}
+
+	data := make([]byte, 0, size)
+
+	for _, nal := range au.NALUnits {
+		data = append(data, startCode...)
+		data = append(data, nal...)
+	}
+
+	return data
+}
+
+// Splitter assembles access units from Annex-B stream written in arbitrary parts
+type Splitter struct {
+	codec   Codec
+	pending []byte
+	current AccessUnit
+	hasVCL  bool
+	// refer keeps the NAL units referring to the written data
+	refer bool
+}
+
+func NewSplitter(c Codec) *Splitter {
+	return &Splitter{
+		codec: c,
+	}
+}
+
+// Write consumes stream data and returns access units completed so far
+func (s *Splitter) Write(data []byte) (units []AccessUnit) {
+	s.pending = append(s.pending, data...)
+
+	// the last NAL unit may be incomplete, keep it until the next start code
+	last := bytes.LastIndex(s.pending, startCode[1:])
+	if last < 0 {
+		return
+	}
+
+	for _, nal := range SplitNALUnits(s.pending[:last]) {
+		if au := s.add(nal); au != nil {
+			units = append(units, *au)
+		}
+	}
+
+	s.pending = append(s.pending[:0], s.pending[last:]...)
+
+	return
+}
+
+// Flush returns the remaining access units assuming the stream is complete
+func (s *Splitter) Flush() (units []AccessUnit) {
+	for _, nal := range SplitNALUnits(s.pending) {
+		if au := s.add(nal); au != nil {
+			units = append(units, *au)
+		}
+	}
+
+	s.pending = s.pending[:0]
+
+	if len(s.current.NALUnits) > 0 {
+		units = append(units, s.current)
+	}
+
+	s.current = AccessUnit{}
+	s.hasVCL = false
+
+	return
+}
+
+func (s *Splitter) add(nal []byte) (done *AccessUnit) {
+	vcl := IsVCL(s.codec, nal)
+
+	boundary := s.hasVCL &&
+		(startsAccessUnit(s.codec, nal) || vcl && isFirstSlice(s.codec, nal))
+
+	if boundary {
+		au := s.current
+		done = &au
+
+		s.current = AccessUnit{}
+		s.hasVCL = false
+	}
+
+	// NAL units are copied since the pending buffer is reused
+	if !s.refer {
+		nal = bytes.Clone(nal)
+	}
+
+	s.current.NALUnits = append(s.current.NALUnits, nal)
+
+	if vcl {
+		s.hasVCL = true
+
+		if IsKeyframe(s.codec, nal) {
+			s.current.Keyframe = true
+		}
+	}
+
+	return
+}
+
+// SplitAccessUnits splits complete Annex-B stream into access units,
+// the NAL units refer to the data
+func SplitAccessUnits(c Codec, data []byte) (units []AccessUnit) {
+	s := &Splitter{codec: c, refer: true}
+
+	for _, nal := range SplitNALUnits(data) {
+		if au := s.add(nal); au != nil {
+			units = append(units, *au)
+		}
+	}
+
+	if len(s.current.NALUnits) > 0 {
+		units = append(units, s.current)
+	}
+
+	return
+}
@@ -0,0 +1,164 @@
+package codec
+
+import "bytes"
+
+type Codec string
+
+const (
+	H264 Codec = "h264"
+	H265 Codec = "hevc"
+)
+
+// H.264 NAL unit types
+const (
+	H264NalSlice = 1
+	H264NalIDR   = 5
+	H264NalSEI   = 6
+	H264NalSPS   = 7
+	H264NalPPS   = 8
+	H264NalAUD   = 9
+)
+
+// H.265 NAL unit types
+const (
+	H265NalBLAWLP    = 16
+	H265NalCRANUT    = 21
+	H265NalVPS       = 32
+	H265NalSPS       = 33
+	H265NalPPS       = 34
+	H265NalAUD       = 35
+	H265NalPrefixSEI = 39
+)
+
+var startCode = []byte{0, 0, 0, 1}
+
+// SplitNALUnits splits Annex-B byte stream into NAL units without start codes
+func SplitNALUnits(data []byte) (units [][]byte) {
+	start := -1
+
+	for i := 0; i+2 < len(data); {
+		if data[i] != 0 || data[i+1] != 0 || data[i+2] != 1 {
+			i++
+			continue
+		}
+
+		if start >= 0 {
+			units = appendNAL(units, data[start:i])
+		}
+
+		i += 3
+		start = i
+	}
+
+	if start >= 0 {
+		units = appendNAL(units, data[start:])
+	}
+
+	return
+}
+
+func appendNAL(units [][]byte, nal []byte) [][]byte {
+	// zero bytes before the next start code belong to it (4-byte start code)
+	nal = bytes.TrimRight(nal, "\x00")
+
+	if len(nal) == 0 {
+		return units
+	}
+
+	return append(units, nal)
+}
+
+// NALType returns NAL unit type from the NAL header
+func NALType(c Codec, nal []byte) int {
+	if len(nal) == 0 {
+		return -1
+	}
+
+	if c == H265 {
+		return int(nal[0]>>1) & 0x3f
+	}
+
+	return int(nal[0]) & 0x1f
+}
+
+// IsKeyframe reports whether NAL unit is an IDR (H.264) or IRAP (H.265) slice
+func IsKeyframe(c Codec, nal []byte) bool {
+	t := NALType(c, nal)
+
+	if c == H265 {
+		return t >= H265NalBLAWLP && t <= H265NalCRANUT
+	}
+
+	return t == H264NalIDR
+}
+
+// HasKeyframe reports whether Annex-B data contains a keyframe slice
+func HasKeyframe(c Codec, data []byte) bool {
+	for _, nal := range SplitNALUnits(data) {
+		if IsKeyframe(c, nal) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsParameterSet reports whether NAL unit is VPS, SPS or PPS
+func IsParameterSet(c Codec, nal []byte) bool {
+	t := NALType(c, nal)
+
+	if c == H265 {
+		return t == H265NalVPS || t == H265NalSPS || t == H265NalPPS
+	}
+
+	return t == H264NalSPS || t == H264NalPPS
+}
+
+// IsVCL reports whether NAL unit carries slice data
+func IsVCL(c Codec, nal []byte) bool {
+	t := NALType(c, nal)
+
+	if c == H265 {
+		return t >= 0 && t < H265NalVPS
+	}
+
+	return t >= H264NalSlice && t <= H264NalIDR
+}
+
+// IsAUD reports whether NAL unit is an access unit delimiter
+func IsAUD(c Codec, nal []byte) bool {
+	t := NALType(c, nal)
+
+	if c == H265 {
+		return t == H265NalAUD
+	}
+
+	return t == H264NalAUD
+}
+
+// startsAccessUnit reports whether non-VCL NAL unit may only precede
+// the first slice of an access unit
+func startsAccessUnit(c Codec, nal []byte) bool {
+	if IsAUD(c, nal) || IsParameterSet(c, nal) {
+		return true
+	}
+
+	t := NALType(c, nal)
+
+	if c == H265 {
+		return t == H265NalPrefixSEI
+	}
+
+	return t == H264NalSEI
+}
+
+// isFirstSlice reports whether VCL NAL unit is the first slice of a picture
+func isFirstSlice(c Codec, nal []byte) bool {
+	if c == H265 {
+		// first_slice_segment_in_pic_flag
+		return len(nal) > 2 && nal[2]&0x80 != 0
+	}
+
+	// first_mb_in_slice is ue(v), so it is 0 only when the first bit is set
+	return len(nal) > 1 && nal[1]&0x80 != 0
+}
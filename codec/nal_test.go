@@ -0,0 +1,144 @@
+package codec_test
+
+import (
+	"camrec/codec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	h264SPS   = []byte{0x67, 0x42, 0xc0, 0x1e, 0xd9}
+	h264PPS   = []byte{0x68, 0xce, 0x3c, 0x80}
+	h264IDR   = []byte{0x65, 0x88, 0x84, 0x21}
+	h264Slice = []byte{0x41, 0x9a, 0x02, 0x04}
+)
+
+func annexB(units ...[]byte) (data []byte) {
+	for _, nal := range units {
+		data = append(data, 0, 0, 0, 1)
+		data = append(data, nal...)
+	}
+
+	return
+}
+
+func TestSplitNALUnits(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		require.Empty(t, codec.SplitNALUnits(nil))
+	})
+
+	t.Run("3 and 4 byte start codes", func(t *testing.T) {
+		data := []byte{0xff, 0, 0, 1, 0x67, 0x42, 0, 0, 0, 1, 0x68, 0xce, 0, 0, 1, 0x65, 0x88}
+
+		require.Equal(t, [][]byte{
+			{0x67, 0x42},
+			{0x68, 0xce},
+			{0x65, 0x88},
+		}, codec.SplitNALUnits(data))
+	})
+}
+
+func TestNALType(t *testing.T) {
+	require.Equal(t, codec.H264NalSPS, codec.NALType(codec.H264, h264SPS))
+	require.Equal(t, codec.H265NalVPS, codec.NALType(codec.H265, []byte{0x40, 0x01}))
+	require.Equal(t, -1, codec.NALType(codec.H264, nil))
+
+	require.True(t, codec.IsKeyframe(codec.H264, h264IDR))
+	require.False(t, codec.IsKeyframe(codec.H264, h264Slice))
+	require.True(t, codec.IsParameterSet(codec.H264, h264PPS))
+
+	// IDR_W_RADL, CRA and TRAIL_R
+	require.True(t, codec.IsKeyframe(codec.H265, []byte{0x26, 0x01}))
+	require.True(t, codec.IsKeyframe(codec.H265, []byte{0x2a, 0x01}))
+	require.False(t, codec.IsKeyframe(codec.H265, []byte{0x02, 0x01}))
+	require.True(t, codec.IsVCL(codec.H265, []byte{0x02, 0x01}))
+	require.True(t, codec.IsParameterSet(codec.H265, []byte{0x44, 0x01}))
+}
+
+func TestSplitter(t *testing.T) {
+	data := annexB(h264SPS, h264PPS, h264IDR, h264Slice, h264Slice, h264SPS, h264PPS, h264IDR)
+
+	t.Run("complete stream", func(t *testing.T) {
+		units := codec.SplitAccessUnits(codec.H264, data)
+
+		require.Len(t, units, 4)
+		require.True(t, units[0].Keyframe)
+		require.Len(t, units[0].NALUnits, 3)
+		require.False(t, units[1].Keyframe)
+		require.False(t, units[2].Keyframe)
+		require.True(t, units[3].Keyframe)
+		require.Equal(t, annexB(h264SPS, h264PPS, h264IDR), units[0].AnnexB())
+	})
+
+	t.Run("byte by byte", func(t *testing.T) {
+		s := codec.NewSplitter(codec.H264)
+
+		units := make([]codec.AccessUnit, 0)
+		for _, b := range data {
+			units = append(units, s.Write([]byte{b})...)
+		}
+
+		require.Len(t, units, 3)
+
+		last := s.Flush()
+		require.Len(t, last, 1)
+		require.True(t, last[0].Keyframe)
+		require.Empty(t, s.Flush())
+	})
+}
+
+func TestParseHEVCSPS(t *testing.T) {
+	t.Run("short", func(t *testing.T) {
+		_, err := codec.ParseHEVCSPS([]byte{0x42, 0x01, 0x01})
+		require.Error(t, err)
+	})
+
+	t.Run("main profile 720p", func(t *testing.T) {
+		sps, err := codec.ParseHEVCSPS([]byte{
+			0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00,
+			0x03, 0x00, 0x00, 0x03, 0x00, 0x5d, 0xa0, 0x02, 0x80, 0x80, 0x2d, 0x16,
+			0x59, 0x59, 0xa4, 0x93, 0x2b, 0xc0, 0x5a, 0x70, 0x80,
+		})
+
+		require.NoError(t, err)
+		require.Equal(t, uint8(1), sps.ProfileIDC)
+		require.Equal(t, uint32(0x60000000), sps.ProfileCompatibility)
+		require.Equal(t, uint64(0x900000000000), sps.ConstraintIndicator)
+		require.Equal(t, uint8(93), sps.LevelIDC)
+		require.Equal(t, uint8(1), sps.ChromaFormatIDC)
+		require.True(t, sps.TemporalIDNesting)
+		require.Equal(t, 1280, sps.Width)
+		require.Equal(t, 720, sps.Height)
+	})
+}
+
+func TestParseH264SPS(t *testing.T) {
+	t.Run("short", func(t *testing.T) {
+		_, err := codec.ParseH264SPS([]byte{0x67, 0x42})
+		require.Error(t, err)
+	})
+
+	t.Run("baseline 720p", func(t *testing.T) {
+		sps, err := codec.ParseH264SPS([]byte{0x67, 0x42, 0xc0, 0x28, 0xe5, 0x40, 0x28, 0x02, 0xdc, 0x80})
+
+		require.NoError(t, err)
+		require.Equal(t, uint8(66), sps.ProfileIDC)
+		require.Equal(t, uint8(40), sps.LevelIDC)
+		require.Equal(t, 1280, sps.Width)
+		require.Equal(t, 720, sps.Height)
+	})
+
+	t.Run("high 1080p with cropping", func(t *testing.T) {
+		sps, err := codec.ParseH264SPS([]byte{0x67, 0x64, 0x00, 0x28, 0xac, 0xca, 0x80, 0x78, 0x02, 0x27, 0xe5, 0x40})
+
+		require.NoError(t, err)
+		require.Equal(t, uint8(100), sps.ProfileIDC)
+		require.Equal(t, 1920, sps.Width)
+		require.Equal(t, 1080, sps.Height)
+	})
+}
+
+func TestRBSP(t *testing.T) {
+	require.Equal(t, []byte{0, 0, 1, 0, 0, 0}, codec.RBSP([]byte{0, 0, 3, 1, 0, 0, 3, 0}))
+}
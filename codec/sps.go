@@ -0,0 +1,310 @@
+package codec
+
+import (
+	"errors"
+)
+
+var errShortData = errors.New("unexpected end of data")
+
+// HEVCSPS holds the H.265 SPS fields required for hvcC record
+type HEVCSPS struct {
+	ProfileSpace         uint8
+	TierFlag             uint8
+	ProfileIDC           uint8
+	ProfileCompatibility uint32
+	ConstraintIndicator  uint64 // 48 bits
+	LevelIDC             uint8
+	MaxSubLayersMinus1   uint8
+	TemporalIDNesting    bool
+	ChromaFormatIDC      uint8
+	BitDepthLumaMinus8   uint8
+	BitDepthChromaMinus8 uint8
+	Width                int
+	Height               int
+}
+
+// ParseHEVCSPS parses H.265 SPS NAL unit (with NAL header)
+func ParseHEVCSPS(nal []byte) (sps *HEVCSPS, err error) {
+	if len(nal) < 3 {
+		return nil, errShortData
+	}
+
+	r := &bitReader{data: RBSP(nal[2:])}
+	sps = &HEVCSPS{}
+
+	r.skip(4) // sps_video_parameter_set_id
+	sps.MaxSubLayersMinus1 = uint8(r.bits(3))
+	sps.TemporalIDNesting = r.bits(1) == 1
+
+	// profile_tier_level
+	sps.ProfileSpace = uint8(r.bits(2))
+	sps.TierFlag = uint8(r.bits(1))
+	sps.ProfileIDC = uint8(r.bits(5))
+	sps.ProfileCompatibility = uint32(r.bits(32))
+	sps.ConstraintIndicator = r.bits(48)
+	sps.LevelIDC = uint8(r.bits(8))
+
+	subLayers := int(sps.MaxSubLayersMinus1)
+	profilePresent := make([]bool, subLayers)
+	levelPresent := make([]bool, subLayers)
+
+	for i := 0; i < subLayers; i++ {
+		profilePresent[i] = r.bits(1) == 1
+		levelPresent[i] = r.bits(1) == 1
+	}
+
+	if subLayers > 0 {
+		for i := subLayers; i < 8; i++ {
+			r.skip(2)
+		}
+	}
+
+	for i := 0; i < subLayers; i++ {
+		if profilePresent[i] {
+			r.skip(88)
+		}
+
+		if levelPresent[i] {
+			r.skip(8)
+		}
+	}
+
+	r.ue() // sps_seq_parameter_set_id
+
+	sps.ChromaFormatIDC = uint8(r.ue())
+	if sps.ChromaFormatIDC == 3 {
+		r.skip(1) // separate_colour_plane_flag
+	}
+
+	sps.Width = int(r.ue())
+	sps.Height = int(r.ue())
+
+	if r.bits(1) == 1 {
+		// conformance window offsets are in chroma samples
+		subWidth, subHeight := 1, 1
+
+		switch sps.ChromaFormatIDC {
+		case 1:
+			subWidth, subHeight = 2, 2
+		case 2:
+			subWidth = 2
+		}
+
+		left, right := int(r.ue()), int(r.ue())
+		top, bottom := int(r.ue()), int(r.ue())
+
+		sps.Width -= subWidth * (left + right)
+		sps.Height -= subHeight * (top + bottom)
+	}
+
+	sps.BitDepthLumaMinus8 = uint8(r.ue())
+	sps.BitDepthChromaMinus8 = uint8(r.ue())
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return
+}
+
+// H264SPS holds the H.264 SPS fields used by the recorder
+type H264SPS struct {
+	ProfileIDC uint8
+	LevelIDC   uint8
+	Width      int
+	Height     int
+}
+
+// ParseH264SPS parses H.264 SPS NAL unit (with NAL header)
+func ParseH264SPS(nal []byte) (sps *H264SPS, err error) {
+	if len(nal) < 4 {
+		return nil, errShortData
+	}
+
+	r := &bitReader{data: RBSP(nal[1:])}
+	sps = &H264SPS{}
+
+	sps.ProfileIDC = uint8(r.bits(8))
+	r.skip(8) // constraint flags
+	sps.LevelIDC = uint8(r.bits(8))
+	r.ue() // seq_parameter_set_id
+
+	chromaFormatIDC := uint64(1)
+
+	switch sps.ProfileIDC {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		chromaFormatIDC = r.ue()
+		if chromaFormatIDC == 3 {
+			r.skip(1) // separate_colour_plane_flag
+		}
+
+		r.ue()    // bit_depth_luma_minus8
+		r.ue()    // bit_depth_chroma_minus8
+		r.skip(1) // qpprime_y_zero_transform_bypass_flag
+
+		if r.bits(1) == 1 {
+			lists := 8
+			if chromaFormatIDC == 3 {
+				lists = 12
+			}
+
+			for i := 0; i < lists; i++ {
+				if r.bits(1) == 0 {
+					continue
+				}
+
+				size := 16
+				if i >= 6 {
+					size = 64
+				}
+
+				r.skipScalingList(size)
+			}
+		}
+	}
+
+	r.ue() // log2_max_frame_num_minus4
+
+	switch r.ue() {
+	case 0:
+		r.ue() // log2_max_pic_order_cnt_lsb_minus4
+
+	case 1:
+		r.skip(1) // delta_pic_order_always_zero_flag
+		r.se()    // offset_for_non_ref_pic
+		r.se()    // offset_for_top_to_bottom_field
+
+		cycle := r.ue()
+		for i := uint64(0); i < cycle && r.err == nil; i++ {
+			r.se()
+		}
+	}
+
+	r.ue()    // max_num_ref_frames
+	r.skip(1) // gaps_in_frame_num_value_allowed_flag
+
+	widthInMbs := int(r.ue()) + 1
+	heightInMapUnits := int(r.ue()) + 1
+
+	frameMbsOnly := int(r.bits(1))
+	if frameMbsOnly == 0 {
+		r.skip(1) // mb_adaptive_frame_field_flag
+	}
+
+	r.skip(1) // direct_8x8_inference_flag
+
+	sps.Width = widthInMbs * 16
+	sps.Height = (2 - frameMbsOnly) * heightInMapUnits * 16
+
+	if r.bits(1) == 1 {
+		cropUnitX, cropUnitY := 1, 2-frameMbsOnly
+
+		switch chromaFormatIDC {
+		case 1:
+			cropUnitX, cropUnitY = 2, 2*(2-frameMbsOnly)
+		case 2:
+			cropUnitX = 2
+		}
+
+		left, right := int(r.ue()), int(r.ue())
+		top, bottom := int(r.ue()), int(r.ue())
+
+		sps.Width -= cropUnitX * (left + right)
+		sps.Height -= cropUnitY * (top + bottom)
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+
+	return
+}
+
+// RBSP removes emulation prevention bytes
+func RBSP(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	zeros := 0
+
+	for _, b := range data {
+		if zeros >= 2 && b == 3 {
+			zeros = 0
+			continue
+		}
+
+		if b == 0 {
+			zeros++
+		} else {
+			zeros = 0
+		}
+
+		out = append(out, b)
+	}
+
+	return out
+}
+
+type bitReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *bitReader) bits(n int) (v uint64) {
+	for i := 0; i < n; i++ {
+		if r.pos >= len(r.data)*8 {
+			r.err = errShortData
+			return 0
+		}
+
+		bit := r.data[r.pos/8] >> (7 - r.pos%8) & 1
+		v = v<<1 | uint64(bit)
+		r.pos++
+	}
+
+	return
+}
+
+func (r *bitReader) skip(n int) {
+	r.bits(n)
+}
+
+// se reads signed Exp-Golomb code
+func (r *bitReader) se() int64 {
+	v := r.ue()
+
+	if v&1 == 1 {
+		return int64(v+1) / 2
+	}
+
+	return -int64(v / 2)
+}
+
+func (r *bitReader) skipScalingList(size int) {
+	last, next := int64(8), int64(8)
+
+	for i := 0; i < size && r.err == nil; i++ {
+		if next != 0 {
+			next = (last + r.se() + 256) % 256
+		}
+
+		if next != 0 {
+			last = next
+		}
+	}
+}
+
+// ue reads unsigned Exp-Golomb code
+func (r *bitReader) ue() uint64 {
+	zeros := 0
+
+	for r.bits(1) == 0 {
+		if r.err != nil || zeros > 31 {
+			r.err = errors.New("invalid exp-golomb code")
+			return 0
+		}
+
+		zeros++
+	}
+
+	return 1<<zeros - 1 + r.bits(zeros)
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Stream sources
+const (
+	SourceFfmpeg = "ffmpeg"
+	SourceRTSP   = "rtsp"
+	// SourceFile reads the stream from a file, a named pipe or stdin ("-")
+	SourceFile = "file"
+)
+
+type Camera struct {
+	// ID is empty for the single camera configured with STREAM
+	ID     string
+	Stream string
+	Source string
+	// Alert is the camera name or serial number in the alert emails,
+	// the alerts of unknown cameras trigger all cameras
+	Alert string
+	// Location is the time zone of the camera clock, the local time zone
+	// is used when it's nil
+	Location *time.Location
+}
+
+// Name returns camera name for logging
+func (c Camera) Name() string {
+	if c.ID == "" {
+		return "default"
+	}
+
+	return c.ID
+}
+
+// Cameras returns cameras configured with the environment variables,
+// CAMERAS lists camera IDs configured with <ID>_STREAM, <ID>_SOURCE,
+// <ID>_ALERT and <ID>_TZ, otherwise the single camera is configured
+// with STREAM, SOURCE, ALERT and CAMERA_TZ, the TZ of the process isn't
+// taken for the camera
+func Cameras() (cameras []Camera, err error) {
+	ids := strings.FieldsFunc(os.Getenv("CAMERAS"), func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+
+	if len(ids) == 0 {
+		ids = []string{""}
+	}
+
+	for _, id := range ids {
+		c := Camera{
+			ID:     id,
+			Stream: Get(id, "STREAM"),
+			Source: Get(id, "SOURCE"),
+			Alert:  Get(id, "ALERT"),
+		}
+
+		if c.Source == "" {
+			c.Source = SourceFfmpeg
+		}
+
+		tzKey := "TZ"
+		if id == "" {
+			tzKey = "CAMERA_TZ"
+		}
+
+		if tz := Get(id, tzKey); tz != "" {
+			if c.Location, err = time.LoadLocation(tz); err != nil {
+				return nil, fmt.Errorf("camera %s: %w", c.Name(), err)
+			}
+		}
+
+		if err = c.Validate(); err != nil {
+			return nil, err
+		}
+
+		cameras = append(cameras, c)
+	}
+
+	return
+}
+
+func (c Camera) Validate() error {
+	if c.Stream == "" {
+		return fmt.Errorf("camera %s: no stream URL", c.Name())
+	}
+
+	switch c.Source {
+	case SourceFfmpeg, SourceRTSP, SourceFile:
+	default:
+		return fmt.Errorf("camera %s: unknown stream source %q", c.Name(), c.Source)
+	}
+
+	return nil
+}
+
+// Get returns camera setting, the empty camera ID means the default camera
+func Get(id string, key string) string {
+	if id == "" {
+		return os.Getenv(key)
+	}
+
+	return os.Getenv(envPrefix(id) + "_" + key)
+}
+
+func envPrefix(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' {
+			return r - 'a' + 'A'
+		}
+
+		if r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+
+		return '_'
+	}, id)
+}
@@ -0,0 +1,89 @@
+package config_test
+
+import (
+	"camrec/config"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCameras(t *testing.T) {
+	t.Run("no stream", func(t *testing.T) {
+		t.Setenv("CAMERAS", "")
+		t.Setenv("STREAM", "")
+
+		_, err := config.Cameras()
+		require.Error(t, err)
+	})
+
+	t.Run("single camera", func(t *testing.T) {
+		t.Setenv("CAMERAS", "")
+		t.Setenv("STREAM", "rtsp://camera/stream")
+		t.Setenv("SOURCE", "")
+
+		cameras, err := config.Cameras()
+		require.NoError(t, err)
+		require.Equal(t, []config.Camera{
+			{Stream: "rtsp://camera/stream", Source: config.SourceFfmpeg},
+		}, cameras)
+		require.Equal(t, "default", cameras[0].Name())
+	})
+
+	t.Run("multiple cameras", func(t *testing.T) {
+		t.Setenv("CAMERAS", "front, back-yard")
+		t.Setenv("FRONT_STREAM", "rtsp://front/stream")
+		t.Setenv("FRONT_SOURCE", "rtsp")
+		t.Setenv("FRONT_ALERT", "K49112334")
+		t.Setenv("BACK_YARD_STREAM", "rtsp://back/stream")
+
+		cameras, err := config.Cameras()
+		require.NoError(t, err)
+		require.Equal(t, []config.Camera{
+			{ID: "front", Stream: "rtsp://front/stream", Source: config.SourceRTSP, Alert: "K49112334"},
+			{ID: "back-yard", Stream: "rtsp://back/stream", Source: config.SourceFfmpeg},
+		}, cameras)
+	})
+
+	t.Run("time zone", func(t *testing.T) {
+		t.Setenv("CAMERAS", "front")
+		t.Setenv("FRONT_STREAM", "rtsp://front/stream")
+		t.Setenv("FRONT_SOURCE", "")
+		t.Setenv("FRONT_TZ", "Asia/Shanghai")
+
+		cameras, err := config.Cameras()
+		require.NoError(t, err)
+		require.Equal(t, "Asia/Shanghai", cameras[0].Location.String())
+
+		t.Setenv("FRONT_TZ", "Mars/Olympus")
+
+		_, err = config.Cameras()
+		require.ErrorContains(t, err, "Mars/Olympus")
+	})
+
+	t.Run("single camera time zone", func(t *testing.T) {
+		t.Setenv("CAMERAS", "")
+		t.Setenv("STREAM", "rtsp://camera/stream")
+		t.Setenv("TZ", "Europe/Berlin")
+		t.Setenv("CAMERA_TZ", "")
+
+		// the time zone of the process isn't the camera one
+		cameras, err := config.Cameras()
+		require.NoError(t, err)
+		require.Nil(t, cameras[0].Location)
+
+		t.Setenv("CAMERA_TZ", "Asia/Shanghai")
+
+		cameras, err = config.Cameras()
+		require.NoError(t, err)
+		require.Equal(t, "Asia/Shanghai", cameras[0].Location.String())
+	})
+
+	t.Run("unknown source", func(t *testing.T) {
+		t.Setenv("CAMERAS", "front")
+		t.Setenv("FRONT_STREAM", "rtsp://front/stream")
+		t.Setenv("FRONT_SOURCE", "gstreamer")
+
+		_, err := config.Cameras()
+		require.ErrorContains(t, err, "gstreamer")
+	})
+}
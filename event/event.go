@@ -1,24 +1,84 @@
 package event
 
 import (
+	"bufio"
+	"camrec/codec"
+	"camrec/mp4"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 )
 
 type Event struct {
-	ts   time.Time
-	data []byte
+	camera string
+	ts     time.Time
+	start  time.Time
+	end    time.Time
+	// parts are the event data in the order, they refer to the buffer
+	// memory, offsets are the positions of the parts in the data
+	parts   [][]byte
+	offsets []int
+	size    int
+	frames  []Frame
+	audio   *Audio
+	meta    Metadata
+	format  *Format
+	// file is the path of the saved event
+	file string
 }
 
+// Frame locates a single buffered frame in the event data
+type Frame struct {
+	Offset    int
+	Length    int
+	Timestamp time.Time
+	Keyframe  bool
+}
+
+// Audio holds ADTS stream recorded in parallel with the event video
+type Audio struct {
+	Data []byte
+
+	// Offset is the audio start relative to the video start
+	Offset time.Duration
+}
+
+// Format describes video stream of the event data,
+// event data without format is saved as is
+type Format struct {
+	Codec     codec.Codec
+	FrameRate float64
+	Width     int
+	Height    int
+}
+
+// Metadata is saved next to the event file as JSON
+type Metadata map[string]any
+
 var OutputDirectory = "."
 
-func NewEvent(ts time.Time, data []byte) *Event {
-	return &Event{
-		ts:   ts,
-		data: data,
+// NewEvent returns the event of the data parts, the parts are kept
+// as is, so they must not be changed afterwards
+func NewEvent(ts time.Time, parts ...[]byte) *Event {
+	e := &Event{ts: ts}
+
+	for _, part := range parts {
+		if len(part) == 0 {
+			continue
+		}
+
+		e.parts = append(e.parts, part)
+		e.offsets = append(e.offsets, e.size)
+		e.size += len(part)
 	}
+
+	return e
 }
 
 func isExist(path string) bool {
@@ -30,8 +90,20 @@ func Directory() string {
 	return OutputDirectory + "/events"
 }
 
+// Dir returns directory of the event files, every named camera
+// has its own subdirectory
+func (e Event) Dir() string {
+	if e.camera == "" {
+		return Directory()
+	}
+
+	return Directory() + "/" + e.camera
+}
+
+// FileName returns the next free file of the event, the name is the local
+// time of the event whatever the zone of the trigger is
 func (e Event) FileName() string {
-	base := Directory() + "/" + e.ts.Format("2006-01-02_15-04-05")
+	base := e.Dir() + "/" + e.ts.In(time.Local).Format(fileTimeLayout)
 
 	index := 0
 	for {
@@ -55,36 +127,348 @@ func (e Event) FileName() string {
 	return base + ".mp4"
 }
 
-func (e Event) SaveFile() (err error) {
-	if e.data == nil || len(e.data) == 0 {
+// SaveFile saves the event to the next free file of the event time
+func (e *Event) SaveFile() (err error) {
+	if e.size == 0 {
 		return errors.New("empty event data")
 	}
 
-	dir := Directory()
+	dir := e.Dir()
 
 	if !isExist(dir) {
-		if err = os.Mkdir(dir, 0777); err != nil {
+		if err = os.MkdirAll(dir, 0777); err != nil {
 			return
 		}
 
 		err = nil
 	}
 
-	f, err := os.OpenFile(e.FileName(), os.O_CREATE|os.O_WRONLY, 0644)
+	fileName := e.FileName()
+
+	f, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return
 	}
 
 	defer f.Close()
 
-	_, err = f.Write(e.data)
+	w := bufio.NewWriter(f)
+
+	if e.format != nil {
+		err = e.writeMP4(w)
+	} else {
+		_, err = e.WriteTo(w)
+	}
+
+	if err == nil {
+		err = w.Flush()
+	}
+
 	if err != nil {
 		return
 	}
 
+	e.file = fileName
+
+	if len(e.meta) > 0 {
+		if err = e.saveMetadata(MetadataFileName(fileName)); err != nil {
+			return fmt.Errorf("metadata save failed: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// File returns the path of the saved event, it's empty until the save
+func (e Event) File() string {
+	return e.file
+}
+
+// ID returns the ID of the saved event, see Info
+func (e Event) ID() string {
+	rel, err := filepath.Rel(Directory(), e.file)
+	if e.file == "" || err != nil {
+		return ""
+	}
+
+	return strings.TrimSuffix(filepath.ToSlash(rel), ".mp4")
+}
+
+// Keyframe returns the keyframe closest to the time as Annex-B stream
+// with the parameter sets of the event in front, ok is false when the
+// event has no keyframes or no format
+func (e Event) Keyframe(ts time.Time) (data []byte, at time.Time, ok bool) {
+	if e.format == nil {
+		return
+	}
+
+	index := -1
+
+	for i, f := range e.frames {
+		if !f.Keyframe {
+			continue
+		}
+
+		if index < 0 || abs(f.Timestamp.Sub(ts)) < abs(e.frames[index].Timestamp.Sub(ts)) {
+			index = i
+		}
+	}
+
+	if index < 0 {
+		return
+	}
+
+	f := e.frames[index]
+	frame := e.slice(f.Offset, f.Length)
+
+	// the parameter sets are sent before the keyframe or in-band
+	var params [][]byte
+
+	for _, f := range e.frames {
+		for _, nal := range codec.SplitNALUnits(e.slice(f.Offset, f.Length)) {
+			if codec.IsParameterSet(e.format.Codec, nal) {
+				params = append(params, nal)
+			}
+		}
+
+		if len(params) > 0 {
+			break
+		}
+	}
+
+	data = codec.AccessUnit{NALUnits: params}.AnnexB()
+
+	return append(data, frame...), f.Timestamp, true
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+
+	return d
+}
+
+func (e Event) writeMP4(w io.Writer) error {
+	units := e.accessUnits()
+
+	track, err := mp4.NewVideoTrack(e.format.Codec, units, e.format.FrameRate, e.format.Width, e.format.Height)
+	if err != nil {
+		return fmt.Errorf("unable to build video track: %w", err)
+	}
+
+	tracks := []*mp4.Track{track}
+
+	if e.audio != nil && len(e.audio.Data) > 0 {
+		audioTrack, err := mp4.NewAudioTrack(e.audio.Data)
+		if err != nil {
+			return fmt.Errorf("unable to build audio track: %w", err)
+		}
+
+		audioTrack.Delay = e.audio.Offset
+		tracks = append(tracks, audioTrack)
+	}
+
+	return mp4.Write(w, tracks...)
+}
+
+// accessUnits splits event data into access units,
+// frame durations are taken from the frame timestamps if known
+func (e Event) accessUnits() []codec.AccessUnit {
+	if len(e.frames) == 0 {
+		return codec.SplitAccessUnits(e.format.Codec, e.Data())
+	}
+
+	units := make([]codec.AccessUnit, 0, len(e.frames))
+	duration := time.Duration(0)
+
+	for i, f := range e.frames {
+		// the last frame lasts as long as the previous one
+		if i+1 < len(e.frames) {
+			duration = e.frames[i+1].Timestamp.Sub(f.Timestamp)
+		}
+
+		parts := codec.SplitAccessUnits(e.format.Codec, e.slice(f.Offset, f.Length))
+
+		for _, au := range parts {
+			au.Duration = duration / time.Duration(len(parts))
+			units = append(units, au)
+		}
+	}
+
+	return units
+}
+
+func (e Event) saveMetadata(path string) error {
+	data, err := json.MarshalIndent(e.meta, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+// MetadataFileName returns the metadata file path for the event file
+func MetadataFileName(fileName string) string {
+	return strings.TrimSuffix(fileName, ".mp4") + ".json"
+}
+
+// SnapshotFileName returns the JPEG snapshot file path for the event file
+func SnapshotFileName(fileName string) string {
+	return strings.TrimSuffix(fileName, ".mp4") + ".jpg"
+}
+
+// Data returns the event data, the parts are joined into a copy,
+// Reader and WriteTo don't copy them
 func (e Event) Data() []byte {
-	return e.data
+	switch len(e.parts) {
+	case 0:
+		return nil
+	case 1:
+		return e.parts[0]
+	}
+
+	data := make([]byte, 0, e.size)
+
+	for _, part := range e.parts {
+		data = append(data, part...)
+	}
+
+	return data
+}
+
+// Size returns the size of the event data
+func (e Event) Size() int {
+	return e.size
+}
+
+// Reader returns the reader of the event data
+func (e Event) Reader() io.Reader {
+	return &reader{parts: e.parts}
+}
+
+// WriteTo writes the event data part by part
+func (e Event) WriteTo(w io.Writer) (n int64, err error) {
+	return e.Reader().(io.WriterTo).WriteTo(w)
+}
+
+// slice returns the data of the range, the range is copied only
+// when it spans several parts
+func (e Event) slice(offset, length int) []byte {
+	i := sort.Search(len(e.offsets), func(i int) bool { return e.offsets[i] > offset }) - 1
+
+	if i < 0 || offset+length > e.size {
+		return nil
+	}
+
+	start := offset - e.offsets[i]
+
+	if start+length <= len(e.parts[i]) {
+		return e.parts[i][start : start+length]
+	}
+
+	data := make([]byte, 0, length)
+
+	for ; len(data) < length; i++ {
+		part := e.parts[i][start:]
+		data = append(data, part[:min(len(part), length-len(data))]...)
+		start = 0
+	}
+
+	return data
+}
+
+// reader reads the parts without copying them to a single buffer
+type reader struct {
+	parts [][]byte
+	// offset is the read position in the first part
+	offset int
+}
+
+func (r *reader) Read(p []byte) (n int, err error) {
+	for len(r.parts) > 0 && r.offset == len(r.parts[0]) {
+		r.parts, r.offset = r.parts[1:], 0
+	}
+
+	if len(r.parts) == 0 {
+		return 0, io.EOF
+	}
+
+	n = copy(p, r.parts[0][r.offset:])
+	r.offset += n
+
+	return
+}
+
+func (r *reader) WriteTo(w io.Writer) (n int64, err error) {
+	for ; len(r.parts) > 0; r.parts, r.offset = r.parts[1:], 0 {
+		written, err := w.Write(r.parts[0][r.offset:])
+		n += int64(written)
+		r.offset += written
+
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return
+}
+
+func (e *Event) SetCamera(id string) {
+	e.camera = id
+}
+
+func (e Event) Camera() string {
+	return e.camera
+}
+
+func (e Event) Timestamp() time.Time {
+	return e.ts
+}
+
+// SetSpan sets timestamps of the first and the last buffered chunks
+func (e *Event) SetSpan(start, end time.Time) {
+	e.start = start
+	e.end = end
+}
+
+func (e Event) Start() time.Time {
+	return e.start
+}
+
+func (e Event) End() time.Time {
+	return e.end
+}
+
+func (e *Event) SetFrames(frames []Frame) {
+	e.frames = frames
+}
+
+func (e Event) Frames() []Frame {
+	return e.frames
+}
+
+func (e *Event) SetAudio(a Audio) {
+	e.audio = &a
+}
+
+func (e *Event) SetFormat(f Format) {
+	e.format = &f
+}
+
+// Format returns the video format, it's nil when the data is saved as is
+func (e Event) Format() *Format {
+	return e.format
+}
+
+func (e *Event) SetMeta(key string, value any) {
+	if e.meta == nil {
+		e.meta = make(Metadata)
+	}
+
+	e.meta[key] = value
+}
+
+func (e Event) Meta() Metadata {
+	return e.meta
 }
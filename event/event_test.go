@@ -1,9 +1,13 @@
 package event_test
 
 import (
+	"bytes"
+	"camrec/codec"
 	"camrec/event"
+	"io"
 	"os"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/stretchr/testify/require"
@@ -19,6 +23,66 @@ func TestData(t *testing.T) {
 	require.EqualValues(t, []byte{1, 2, 3}, e.Data())
 }
 
+func TestParts(t *testing.T) {
+	parts := [][]byte{{1, 2}, nil, {3}, {4, 5, 6}}
+
+	e := event.NewEvent(time.Now(), parts...)
+	require.Equal(t, 6, e.Size())
+	require.Equal(t, []byte{1, 2, 3, 4, 5, 6}, e.Data())
+
+	// the reader is read in small pieces
+	data, err := io.ReadAll(iotest.OneByteReader(e.Reader()))
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 2, 3, 4, 5, 6}, data)
+
+	var buf bytes.Buffer
+
+	n, err := e.WriteTo(&buf)
+	require.NoError(t, err)
+	require.EqualValues(t, 6, n)
+	require.Equal(t, []byte{1, 2, 3, 4, 5, 6}, buf.Bytes())
+
+	// the parts are not changed by the reads
+	require.Equal(t, []byte{1, 2}, parts[0])
+	require.Equal(t, []byte{1, 2, 3, 4, 5, 6}, e.Data())
+}
+
+func TestKeyframe(t *testing.T) {
+	now := time.Now()
+
+	params := []byte{
+		0, 0, 0, 1, 0x67, 0x42, 0xc0, 0x1e, 0xd9,
+		0, 0, 0, 1, 0x68, 0xce, 0x3c, 0x80,
+	}
+	idr := []byte{0, 0, 0, 1, 0x65, 0x88, 0x84, 0x21}
+	frame := []byte{0, 0, 0, 1, 0x41, 0x9a, 0x02, 0x04}
+
+	e := event.NewEvent(now, append(params, idr...), frame, idr)
+
+	_, _, ok := e.Keyframe(now)
+	require.False(t, ok)
+
+	e.SetFormat(event.Format{Codec: codec.H264})
+	e.SetFrames([]event.Frame{
+		{Offset: 0, Length: len(params) + len(idr), Timestamp: now, Keyframe: true},
+		{Offset: len(params) + len(idr), Length: len(frame), Timestamp: now.Add(time.Second)},
+		{Offset: len(params) + len(idr) + len(frame), Length: len(idr), Timestamp: now.Add(2 * time.Second), Keyframe: true},
+	})
+
+	data, at, ok := e.Keyframe(now.Add(1500 * time.Millisecond))
+	require.True(t, ok)
+	require.Equal(t, now.Add(2*time.Second), at)
+	require.Equal(t, append(append([]byte{}, params...), idr...), data)
+}
+
+func TestMeta(t *testing.T) {
+	e := event.NewEvent(time.Now(), nil)
+	require.Empty(t, e.Meta())
+
+	e.SetMeta("note", "test")
+	require.Equal(t, event.Metadata{"note": "test"}, e.Meta())
+}
+
 func TestSaveFile(t *testing.T) {
 	now := time.Now()
 
@@ -98,6 +162,111 @@ func TestSaveFile(t *testing.T) {
 		require.Error(t, err)
 	})
 
+	t.Run("save metadata", func(t *testing.T) {
+		event.OutputDirectory = t.TempDir()
+
+		e := event.NewEvent(now, []byte{1, 2, 3})
+		e.SetMeta("note", "front door")
+
+		fileName := e.FileName()
+
+		err := e.SaveFile()
+		require.NoError(t, err)
+
+		data, err := os.ReadFile(event.MetadataFileName(fileName))
+		require.NoError(t, err)
+		require.JSONEq(t, `{"note":"front door"}`, string(data))
+	})
+
+	t.Run("save mp4", func(t *testing.T) {
+		event.OutputDirectory = t.TempDir()
+
+		data := []byte{
+			0, 0, 0, 1, 0x67, 0x42, 0xc0, 0x1e, 0xd9,
+			0, 0, 0, 1, 0x68, 0xce, 0x3c, 0x80,
+			0, 0, 0, 1, 0x65, 0x88, 0x84, 0x21,
+		}
+
+		e := event.NewEvent(now, data)
+		e.SetFormat(event.Format{Codec: codec.H264, FrameRate: 25})
+
+		fileName := e.FileName()
+
+		err := e.SaveFile()
+		require.NoError(t, err)
+
+		saved, err := os.ReadFile(fileName)
+		require.NoError(t, err)
+		require.Equal(t, "ftyp", string(saved[4:8]))
+	})
+
+	t.Run("save mp4 with frame timestamps", func(t *testing.T) {
+		event.OutputDirectory = t.TempDir()
+
+		keyframe := []byte{
+			0, 0, 0, 1, 0x67, 0x42, 0xc0, 0x1e, 0xd9,
+			0, 0, 0, 1, 0x68, 0xce, 0x3c, 0x80,
+			0, 0, 0, 1, 0x65, 0x88, 0x84, 0x21,
+		}
+		frame := []byte{0, 0, 0, 1, 0x41, 0x9a, 0x02, 0x04}
+
+		e := event.NewEvent(now, append(keyframe, frame...))
+		e.SetFormat(event.Format{Codec: codec.H264})
+		e.SetFrames([]event.Frame{
+			{Offset: 0, Length: len(keyframe), Timestamp: now},
+			{Offset: len(keyframe), Length: len(frame), Timestamp: now.Add(40 * time.Millisecond)},
+		})
+
+		err := e.SaveFile()
+		require.NoError(t, err)
+	})
+
+	t.Run("save mp4 of parts", func(t *testing.T) {
+		event.OutputDirectory = t.TempDir()
+
+		keyframe := []byte{
+			0, 0, 0, 1, 0x67, 0x42, 0xc0, 0x1e, 0xd9,
+			0, 0, 0, 1, 0x68, 0xce, 0x3c, 0x80,
+			0, 0, 0, 1, 0x65, 0x88, 0x84, 0x21,
+		}
+		frame := []byte{0, 0, 0, 1, 0x41, 0x9a, 0x02, 0x04}
+
+		// the keyframe spans two parts
+		joined := event.NewEvent(now, append(keyframe, frame...))
+		split := event.NewEvent(now, keyframe[:10], keyframe[10:], frame)
+
+		for _, e := range []*event.Event{joined, split} {
+			e.SetFormat(event.Format{Codec: codec.H264})
+			e.SetFrames([]event.Frame{
+				{Offset: 0, Length: len(keyframe), Timestamp: now},
+				{Offset: len(keyframe), Length: len(frame), Timestamp: now.Add(40 * time.Millisecond)},
+			})
+		}
+
+		joinedFile := joined.FileName()
+		require.NoError(t, joined.SaveFile())
+
+		splitFile := split.FileName()
+		require.NoError(t, split.SaveFile())
+
+		want, err := os.ReadFile(joinedFile)
+		require.NoError(t, err)
+
+		got, err := os.ReadFile(splitFile)
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	})
+
+	t.Run("save invalid mp4", func(t *testing.T) {
+		event.OutputDirectory = t.TempDir()
+
+		e := event.NewEvent(now, []byte{1, 2, 3})
+		e.SetFormat(event.Format{Codec: codec.H264, FrameRate: 25})
+
+		err := e.SaveFile()
+		require.Error(t, err)
+	})
+
 	t.Run("save blank file", func(t *testing.T) {
 		event.OutputDirectory = t.TempDir()
 
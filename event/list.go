@@ -0,0 +1,206 @@
+package event
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fileTimeLayout is the time format of the event file names
+const fileTimeLayout = "2006-01-02_15-04-05"
+
+// Info describes the saved event file
+type Info struct {
+	// ID is the file path relative to the events directory without
+	// the extension, like front/2024-03-01_10-00-00
+	ID     string
+	Camera string
+	Time   time.Time
+	File   string
+	Size   int64
+	Meta   Metadata
+	// Snapshot is the JPEG snapshot file, it's empty when there's none
+	Snapshot string
+}
+
+// List returns the saved events of the camera by time, the empty
+// camera means all cameras
+func List(camera string) (events []Info, err error) {
+	dir := Directory()
+
+	err = filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if path == dir && errors.Is(err, os.ErrNotExist) {
+				return filepath.SkipDir
+			}
+
+			return err
+		}
+
+		if d.IsDir() || filepath.Ext(path) != ".mp4" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		info, err := stat(strings.TrimSuffix(filepath.ToSlash(rel), ".mp4"))
+		if err != nil {
+			return err
+		}
+
+		if camera == "" || info.Camera == camera {
+			events = append(events, info)
+		}
+
+		return nil
+	})
+
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Time.Equal(events[j].Time) {
+			return events[i].ID < events[j].ID
+		}
+
+		return events[i].Time.Before(events[j].Time)
+	})
+
+	return
+}
+
+// Find returns the saved event of the ID
+func Find(id string) (Info, error) {
+	id = strings.TrimSuffix(filepath.ToSlash(id), ".mp4")
+
+	if strings.Contains(id, "..") {
+		return Info{}, fmt.Errorf("invalid event ID %q", id)
+	}
+
+	return stat(id)
+}
+
+// stat reads the event file of the ID and its metadata
+func stat(id string) (info Info, err error) {
+	info = Info{
+		ID:   id,
+		File: filepath.Join(Directory(), filepath.FromSlash(id)+".mp4"),
+	}
+
+	if dir, _, ok := strings.Cut(id, "/"); ok {
+		info.Camera = dir
+	}
+
+	st, err := os.Stat(info.File)
+	if err != nil {
+		return
+	}
+
+	info.Size = st.Size()
+
+	if snapshot := SnapshotFileName(info.File); isExist(snapshot) {
+		info.Snapshot = snapshot
+	}
+
+	// the index suffix of the same second is ignored
+	name := filepath.Base(id)
+	if len(name) >= len(fileTimeLayout) {
+		info.Time, _ = time.ParseInLocation(fileTimeLayout, name[:len(fileTimeLayout)], time.Local)
+	}
+
+	data, err := os.ReadFile(MetadataFileName(info.File))
+	if errors.Is(err, os.ErrNotExist) {
+		return info, nil
+	}
+
+	if err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(data, &info.Meta); err != nil {
+		return info, fmt.Errorf("invalid metadata of event %s: %w", id, err)
+	}
+
+	return
+}
+
+// Note returns the note of the event metadata
+func (i Info) Note() string {
+	note, _ := i.Meta["note"].(string)
+	return note
+}
+
+// Export copies the event file, its metadata and snapshot to the directory
+func (i Info) Export(dir string) (files []string, err error) {
+	if err = os.MkdirAll(dir, 0777); err != nil {
+		return
+	}
+
+	base := filepath.Base(i.File)
+	if i.Camera != "" {
+		base = i.Camera + "_" + base
+	}
+
+	sources := []string{i.File}
+	if i.Meta != nil {
+		sources = append(sources, MetadataFileName(i.File))
+	}
+
+	if i.Snapshot != "" {
+		sources = append(sources, i.Snapshot)
+	}
+
+	for _, src := range sources {
+		dst := filepath.Join(dir, strings.TrimSuffix(base, ".mp4")+filepath.Ext(src))
+
+		if err = copyFile(src, dst); err != nil {
+			return
+		}
+
+		files = append(files, dst)
+	}
+
+	return
+}
+
+// Delete removes the event file, its metadata and snapshot
+func (i Info) Delete() error {
+	if err := os.Remove(i.File); err != nil {
+		return err
+	}
+
+	for _, file := range []string{MetadataFileName(i.File), SnapshotFileName(i.File)} {
+		if err := os.Remove(file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyFile(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return
+	}
+
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return
+	}
+
+	return out.Close()
+}
@@ -0,0 +1,108 @@
+package event_test
+
+import (
+	"camrec/event"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestList(t *testing.T) {
+	event.OutputDirectory = t.TempDir()
+
+	list, err := event.List("")
+	require.NoError(t, err)
+	require.Empty(t, list)
+
+	first := time.Date(2024, 3, 1, 10, 0, 0, 0, time.Local)
+
+	front := event.NewEvent(first, []byte{1, 2, 3})
+	front.SetCamera("front")
+	front.SetMeta("note", "parcel at the door")
+	require.NoError(t, front.SaveFile())
+	require.NoError(t, os.WriteFile(event.SnapshotFileName(front.File()), []byte("\xff\xd8jpeg"), 0644))
+
+	back := event.NewEvent(first.Add(time.Hour), []byte{4})
+	back.SetCamera("back")
+	require.NoError(t, back.SaveFile())
+
+	list, err = event.List("")
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+
+	require.Equal(t, "front/2024-03-01_10-00-00", list[0].ID)
+	require.Equal(t, "front", list[0].Camera)
+	require.True(t, first.Equal(list[0].Time))
+	require.EqualValues(t, 3, list[0].Size)
+	require.Equal(t, "parcel at the door", list[0].Note())
+	require.Equal(t, event.SnapshotFileName(front.File()), list[0].Snapshot)
+	require.Equal(t, "back", list[1].Camera)
+	require.Empty(t, list[1].Snapshot)
+
+	list, err = event.List("back")
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+
+	t.Run("trigger zone", func(t *testing.T) {
+		// the camera zone is ahead of the local one
+		_, offset := first.Zone()
+		ts := first.Add(2 * time.Hour).In(time.FixedZone("camera", offset+5*3600))
+
+		e := event.NewEvent(ts, []byte{5})
+		e.SetCamera("zone")
+		require.NoError(t, e.SaveFile())
+
+		list, err := event.List("zone")
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+		require.Equal(t, "zone/2024-03-01_12-00-00", list[0].ID)
+		require.True(t, ts.Equal(list[0].Time), "time %s", list[0].Time)
+		require.NoError(t, list[0].Delete())
+	})
+
+	t.Run("export", func(t *testing.T) {
+		e, err := event.Find("front/2024-03-01_10-00-00")
+		require.NoError(t, err)
+
+		out := t.TempDir()
+
+		files, err := e.Export(out)
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			filepath.Join(out, "front_2024-03-01_10-00-00.mp4"),
+			filepath.Join(out, "front_2024-03-01_10-00-00.json"),
+			filepath.Join(out, "front_2024-03-01_10-00-00.jpg"),
+		}, files)
+
+		data, err := os.ReadFile(files[0])
+		require.NoError(t, err)
+		require.Equal(t, []byte{1, 2, 3}, data)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		e, err := event.Find("front/2024-03-01_10-00-00.mp4")
+		require.NoError(t, err)
+		require.NoError(t, e.Delete())
+
+		_, err = os.Stat(event.MetadataFileName(e.File))
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		_, err = os.Stat(e.Snapshot)
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		list, err := event.List("")
+		require.NoError(t, err)
+		require.Len(t, list, 1)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := event.Find("front/2020-01-01_00-00-00")
+		require.ErrorIs(t, err, os.ErrNotExist)
+
+		_, err = event.Find("../secret")
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"camrec/event"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// events manages the saved events: list, export and delete
+func events(args []string) error {
+	commands := map[string]func([]string) error{
+		"list":   listEvents,
+		"export": exportEvents,
+		"delete": deleteEvents,
+	}
+
+	if len(args) > 0 {
+		if command, ok := commands[args[0]]; ok {
+			event.OutputDirectory = envOr("OUTPUT_DIR", event.OutputDirectory)
+			return command(args[1:])
+		}
+	}
+
+	return errors.New("usage: events list|export|delete [flags]")
+}
+
+func listEvents(args []string) (err error) {
+	flags := flag.NewFlagSet("events list", flag.ContinueOnError)
+
+	camera := flags.String("camera", "", "camera ID (default: all cameras)")
+	since := flags.Duration("since", 0, "list the events of the last duration only")
+
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	list, err := event.List(*camera)
+	if err != nil {
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "ID\tTIME\tSIZE\tNOTE")
+
+	for _, e := range list {
+		if *since > 0 && time.Since(e.Time) > *since {
+			continue
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%.1f MB\t%s\n", e.ID, e.Time.Format(time.DateTime), float64(e.Size)/(1<<20), e.Note())
+	}
+
+	return w.Flush()
+}
+
+func exportEvents(args []string) (err error) {
+	flags := flag.NewFlagSet("events export", flag.ContinueOnError)
+
+	out := flags.String("out", ".", "directory the events are copied to")
+
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("event IDs are required")
+	}
+
+	for _, id := range flags.Args() {
+		e, err := event.Find(id)
+		if err != nil {
+			return err
+		}
+
+		files, err := e.Export(*out)
+		if err != nil {
+			return fmt.Errorf("event %s: %w", id, err)
+		}
+
+		for _, f := range files {
+			fmt.Println(f)
+		}
+	}
+
+	return
+}
+
+func deleteEvents(args []string) (err error) {
+	flags := flag.NewFlagSet("events delete", flag.ContinueOnError)
+
+	olderThan := flags.Duration("older-than", 0, "delete all events older than the duration")
+	camera := flags.String("camera", "", "camera ID of --older-than (default: all cameras)")
+
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	var list []event.Info
+
+	switch {
+	case *olderThan > 0 && flags.NArg() == 0:
+		all, err := event.List(*camera)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range all {
+			if time.Since(e.Time) > *olderThan {
+				list = append(list, e)
+			}
+		}
+
+	case *olderThan == 0 && flags.NArg() > 0:
+		for _, id := range flags.Args() {
+			e, err := event.Find(id)
+			if err != nil {
+				return err
+			}
+
+			list = append(list, e)
+		}
+
+	default:
+		flags.Usage()
+		return errors.New("either event IDs or --older-than is required")
+	}
+
+	for _, e := range list {
+		if err = e.Delete(); err != nil {
+			return fmt.Errorf("event %s: %w", e.ID, err)
+		}
+
+		fmt.Printf("deleted %s\n", e.ID)
+	}
+
+	return
+}
@@ -0,0 +1,315 @@
+package mail
+
+import (
+	"camrec/clock"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// DefaultDeviceAuthURL is the device authorization endpoint of Google
+const DefaultDeviceAuthURL = "https://oauth2.googleapis.com/device/code"
+
+// AuthOptions select the authorization flow of the auth command
+type AuthOptions struct {
+	// Device uses the device authorization grant, the code is entered
+	// on another device, otherwise the browser is redirected to the
+	// loopback listener
+	Device bool
+	// Listen is the loopback address of the redirect listener,
+	// the port is chosen by the system when it's not set
+	Listen string
+	// DeviceAuthURL is the device authorization endpoint
+	DeviceAuthURL string
+	// Out receives the instructions for the user
+	Out io.Writer
+}
+
+// Auth runs the one-time authorization of the user
+type Auth struct {
+	config *oauth2.Config
+	opts   AuthOptions
+	clock  clock.Clock
+}
+
+// NewAuth creates the authorization of the OAuth client
+func NewAuth(config *oauth2.Config, opts AuthOptions) *Auth {
+	if opts.Listen == "" {
+		opts.Listen = "127.0.0.1:0"
+	}
+
+	if opts.DeviceAuthURL == "" {
+		opts.DeviceAuthURL = DefaultDeviceAuthURL
+	}
+
+	if opts.Out == nil {
+		opts.Out = os.Stdout
+	}
+
+	return &Auth{
+		config: config,
+		opts:   opts,
+		clock:  clock.Real,
+	}
+}
+
+// SetClock sets the clock of the device token polling
+func (a *Auth) SetClock(c clock.Clock) {
+	a.clock = c
+}
+
+// Token waits until the user grants the access and returns the token
+func (a *Auth) Token(ctx context.Context) (*oauth2.Token, error) {
+	if a.opts.Device {
+		return a.deviceToken(ctx)
+	}
+
+	return a.loopbackToken(ctx)
+}
+
+type authResult struct {
+	code string
+	err  error
+}
+
+// loopbackToken receives the authorization code on the redirect to the
+// local listener, the code is bound to the verifier (PKCE)
+func (a *Auth) loopbackToken(ctx context.Context) (*oauth2.Token, error) {
+	state, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+
+	verifier, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+
+	ln, err := net.Listen("tcp", a.opts.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start redirect listener: %w", err)
+	}
+
+	config := *a.config
+	config.RedirectURL = "http://" + ln.Addr().String()
+
+	result := make(chan authResult, 1)
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			q := r.URL.Query()
+
+			// the browser may request other paths like the icon
+			if q.Get("state") != state {
+				http.Error(w, "invalid state", http.StatusBadRequest)
+				return
+			}
+
+			res := authResult{code: q.Get("code")}
+
+			if e := q.Get("error"); e != "" {
+				res.err = fmt.Errorf("authorization failed: %s", e)
+				http.Error(w, res.err.Error(), http.StatusForbidden)
+			} else if res.code == "" {
+				http.Error(w, "no authorization code", http.StatusBadRequest)
+				return
+			} else {
+				fmt.Fprintln(w, "camrec is authorized, the window can be closed")
+			}
+
+			select {
+			case result <- res:
+			default:
+			}
+		}),
+	}
+
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	sum := sha256.Sum256([]byte(verifier))
+
+	authURL := config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce,
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(sum[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"))
+
+	fmt.Fprintf(a.opts.Out, "Open the following link in the browser:\n%s\n", authURL)
+
+	var res authResult
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res = <-result:
+	}
+
+	if res.err != nil {
+		return nil, res.err
+	}
+
+	tok, err := config.Exchange(ctx, res.code, oauth2.SetAuthURLParam("code_verifier", verifier))
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve token: %w", err)
+	}
+
+	return tok, nil
+}
+
+type deviceCode struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	// VerificationURL is the name used by Google
+	VerificationURL string `json:"verification_url"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+// oauthError is the error response of the OAuth endpoints
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *oauthError) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+
+	return e.Code + ": " + e.Description
+}
+
+// deviceToken polls the token endpoint until the user enters the code,
+// the client must be of the limited input device type
+func (a *Auth) deviceToken(ctx context.Context) (*oauth2.Token, error) {
+	var dc deviceCode
+
+	err := post(ctx, a.opts.DeviceAuthURL, url.Values{
+		"client_id": {a.config.ClientID},
+		"scope":     {strings.Join(a.config.Scopes, " ")},
+	}, &dc)
+	if err != nil {
+		return nil, fmt.Errorf("unable to request device code: %w", err)
+	}
+
+	uri := dc.VerificationURI
+	if uri == "" {
+		uri = dc.VerificationURL
+	}
+
+	fmt.Fprintf(a.opts.Out, "Open %s and enter the code %s\n", uri, dc.UserCode)
+
+	interval := time.Duration(dc.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+
+	expiry := a.clock.Now().Add(time.Duration(dc.ExpiresIn) * time.Second)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-a.clock.After(interval):
+		}
+
+		if dc.ExpiresIn > 0 && a.clock.Now().After(expiry) {
+			return nil, errors.New("device code expired")
+		}
+
+		var res tokenResponse
+
+		err := post(ctx, a.config.Endpoint.TokenURL, url.Values{
+			"client_id":     {a.config.ClientID},
+			"client_secret": {a.config.ClientSecret},
+			"device_code":   {dc.DeviceCode},
+			"grant_type":    {"urn:ietf:params:oauth:grant-type:device_code"},
+		}, &res)
+
+		var oe *oauthError
+
+		if errors.As(err, &oe) {
+			switch oe.Code {
+			case "authorization_pending":
+				continue
+			case "slow_down":
+				interval += 5 * time.Second
+				continue
+			}
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve token: %w", err)
+		}
+
+		tok := &oauth2.Token{
+			AccessToken:  res.AccessToken,
+			TokenType:    res.TokenType,
+			RefreshToken: res.RefreshToken,
+		}
+
+		if res.ExpiresIn > 0 {
+			tok.Expiry = a.clock.Now().Add(time.Duration(res.ExpiresIn) * time.Second)
+		}
+
+		return tok, nil
+	}
+}
+
+// post sends the form and decodes the JSON response
+func post(ctx context.Context, endpoint string, form url.Values, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		oe := &oauthError{}
+
+		if json.NewDecoder(res.Body).Decode(oe) == nil && oe.Code != "" {
+			return oe
+		}
+
+		return fmt.Errorf("unexpected status: %s", res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,423 @@
+package mail_test
+
+import (
+	"bytes"
+	"camrec/clock"
+	"camrec/mail"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+// fakeOAuth is the authorization server of the code and device grants
+type fakeOAuth struct {
+	*httptest.Server
+
+	lock      sync.Mutex
+	challenge string
+	pending   int
+	polls     int
+}
+
+func newFakeOAuth(t *testing.T) *fakeOAuth {
+	f := &fakeOAuth{}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device", f.handleDevice)
+	mux.HandleFunc("/token", f.handleToken)
+
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+
+	return f
+}
+
+func (f *fakeOAuth) config() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		Scopes:       []string{"gmail", "pubsub"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  f.URL + "/auth",
+			TokenURL: f.URL + "/token",
+		},
+	}
+}
+
+func (f *fakeOAuth) handleDevice(w http.ResponseWriter, r *http.Request) {
+	if r.FormValue("client_id") != "client" || r.FormValue("scope") != "gmail pubsub" {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"device_code":      "device",
+		"user_code":        "ABCD-EFGH",
+		"verification_url": "https://www.google.com/device",
+		"expires_in":       60,
+		"interval":         5,
+	})
+}
+
+func (f *fakeOAuth) handleToken(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.FormValue("grant_type") {
+	case "authorization_code":
+		sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+
+		if r.FormValue("code") != "code" || base64.RawURLEncoding.EncodeToString(sum[:]) != f.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+	case "urn:ietf:params:oauth:grant-type:device_code":
+		f.polls++
+
+		if r.FormValue("device_code") != "device" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant"}`))
+			return
+		}
+
+		if f.pending > 0 {
+			f.pending--
+
+			w.WriteHeader(http.StatusPreconditionRequired)
+			w.Write([]byte(`{"error":"authorization_pending"}`))
+			return
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token":  "access",
+		"token_type":    "Bearer",
+		"refresh_token": "refresh",
+		"expires_in":    3600,
+	})
+}
+
+func (f *fakeOAuth) pollCount() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.polls
+}
+
+// syncBuffer is the output of the auth instructions read by the test
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.buf.String()
+}
+
+func TestAuth(t *testing.T) {
+	t.Run("loopback redirect", func(t *testing.T) {
+		f := newFakeOAuth(t)
+		out := &syncBuffer{}
+
+		result := make(chan error, 1)
+		var tok *oauth2.Token
+
+		go func() {
+			var err error
+			tok, err = mail.NewAuth(f.config(), mail.AuthOptions{Out: out}).Token(context.Background())
+			result <- err
+		}()
+
+		// the browser opens the link and the user grants the access
+		var authURL *url.URL
+
+		require.Eventually(t, func() bool {
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+
+			var err error
+			authURL, err = url.Parse(lines[len(lines)-1])
+
+			return err == nil && authURL.Host != ""
+		}, time.Second, time.Millisecond)
+
+		q := authURL.Query()
+		require.Equal(t, "offline", q.Get("access_type"))
+		require.Equal(t, "S256", q.Get("code_challenge_method"))
+
+		f.lock.Lock()
+		f.challenge = q.Get("code_challenge")
+		f.lock.Unlock()
+
+		redirect := q.Get("redirect_uri")
+		require.True(t, strings.HasPrefix(redirect, "http://127.0.0.1:"))
+
+		res, err := http.Get(redirect + "?state=wrong&code=code")
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+		res, err = http.Get(redirect + "?" + url.Values{"state": {q.Get("state")}, "code": {"code"}}.Encode())
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+
+		require.NoError(t, <-result)
+		require.Equal(t, "access", tok.AccessToken)
+		require.Equal(t, "refresh", tok.RefreshToken)
+
+		// the listener is closed
+		_, err = http.Get(redirect)
+		require.Error(t, err)
+	})
+
+	t.Run("loopback access denied", func(t *testing.T) {
+		f := newFakeOAuth(t)
+		out := &syncBuffer{}
+
+		result := make(chan error, 1)
+
+		go func() {
+			_, err := mail.NewAuth(f.config(), mail.AuthOptions{Out: out}).Token(context.Background())
+			result <- err
+		}()
+
+		require.Eventually(t, func() bool { return strings.Contains(out.String(), "redirect_uri") }, time.Second, time.Millisecond)
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		authURL, err := url.Parse(lines[len(lines)-1])
+		require.NoError(t, err)
+
+		q := authURL.Query()
+
+		res, err := http.Get(q.Get("redirect_uri") + "?" + url.Values{"state": {q.Get("state")}, "error": {"access_denied"}}.Encode())
+		require.NoError(t, err)
+		res.Body.Close()
+
+		require.ErrorContains(t, <-result, "access_denied")
+	})
+
+	t.Run("device code", func(t *testing.T) {
+		f := newFakeOAuth(t)
+		f.pending = 2
+
+		out := &syncBuffer{}
+		c := clock.NewVirtual(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC))
+
+		a := mail.NewAuth(f.config(), mail.AuthOptions{
+			Device:        true,
+			DeviceAuthURL: f.URL + "/device",
+			Out:           out,
+		})
+		a.SetClock(c)
+
+		result := make(chan error, 1)
+		var tok *oauth2.Token
+
+		go func() {
+			var err error
+			tok, err = a.Token(context.Background())
+			result <- err
+		}()
+
+		for i := 0; i < 3; i++ {
+			require.Eventually(t, func() bool { return c.Waiters() == 1 }, time.Second, time.Millisecond)
+			c.Advance(5 * time.Second)
+		}
+
+		require.NoError(t, <-result)
+		require.Equal(t, "Open https://www.google.com/device and enter the code ABCD-EFGH\n", out.String())
+		require.Equal(t, 3, f.pollCount())
+		require.Equal(t, "access", tok.AccessToken)
+		require.Equal(t, c.Now().Add(time.Hour), tok.Expiry)
+	})
+
+	t.Run("device code expired", func(t *testing.T) {
+		f := newFakeOAuth(t)
+		f.pending = 100
+
+		c := clock.NewVirtual(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC))
+
+		a := mail.NewAuth(f.config(), mail.AuthOptions{
+			Device:        true,
+			DeviceAuthURL: f.URL + "/device",
+			Out:           &syncBuffer{},
+		})
+		a.SetClock(c)
+
+		result := make(chan error, 1)
+
+		go func() {
+			_, err := a.Token(context.Background())
+			result <- err
+		}()
+
+		for {
+			select {
+			case err := <-result:
+				require.ErrorContains(t, err, "expired")
+				require.Equal(t, 12, f.pollCount())
+				return
+			default:
+			}
+
+			if c.Waiters() == 1 {
+				c.Advance(5 * time.Second)
+			}
+
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
+
+func TestGetClient(t *testing.T) {
+	dir := t.TempDir()
+
+	mail.CredentialsFile = filepath.Join(dir, "credentials.json")
+	mail.TokenFile = filepath.Join(dir, "token.json")
+
+	_, err := mail.GetClient()
+	require.ErrorContains(t, err, "client secret")
+
+	credentials := `{"installed":{"client_id":"client","client_secret":"secret",` +
+		`"auth_uri":"https://accounts.google.com/o/oauth2/auth","token_uri":"https://oauth2.googleapis.com/token",` +
+		`"redirect_uris":["http://localhost"]}}`
+	require.NoError(t, os.WriteFile(mail.CredentialsFile, []byte(credentials), 0600))
+
+	_, err = mail.GetClient()
+	require.ErrorIs(t, err, mail.ErrAuthorizationRequired)
+
+	require.NoError(t, mail.SaveToken(mail.TokenFile, &oauth2.Token{
+		AccessToken:  "access",
+		RefreshToken: "refresh",
+		Expiry:       time.Now().Add(time.Hour),
+	}))
+
+	stat, err := os.Stat(mail.TokenFile)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+
+	client, err := mail.GetClient()
+	require.NoError(t, err)
+	require.NotNil(t, client)
+
+	require.NoError(t, mail.SaveToken(mail.TokenFile, &oauth2.Token{
+		AccessToken: "access",
+		Expiry:      time.Now().Add(-time.Hour),
+	}))
+
+	_, err = mail.GetClient()
+	require.ErrorIs(t, err, mail.ErrReauthorizationRequired)
+}
+
+func TestTokenSource(t *testing.T) {
+	expired := &oauth2.Token{
+		AccessToken:  "old",
+		RefreshToken: "refresh",
+		Expiry:       time.Now().Add(-time.Minute),
+	}
+
+	t.Run("refresh", func(t *testing.T) {
+		refreshes := 0
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, "refresh_token", r.FormValue("grant_type"))
+			require.Equal(t, "refresh", r.FormValue("refresh_token"))
+
+			refreshes++
+
+			w.Header().Set("Content-Type", "application/json")
+
+			// the refresh token isn't rotated by Google
+			json.NewEncoder(w).Encode(map[string]any{
+				"access_token": "new",
+				"token_type":   "Bearer",
+				"expires_in":   3600,
+			})
+		}))
+		defer srv.Close()
+
+		path := filepath.Join(t.TempDir(), "token.json")
+
+		ts := mail.NewTokenSource(context.Background(), &oauth2.Config{
+			Endpoint: oauth2.Endpoint{TokenURL: srv.URL},
+		}, expired, path)
+
+		for i := 0; i < 2; i++ {
+			tok, err := ts.Token()
+			require.NoError(t, err)
+			require.Equal(t, "new", tok.AccessToken)
+		}
+
+		require.Equal(t, 1, refreshes)
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		saved := &oauth2.Token{}
+		require.NoError(t, json.Unmarshal(data, saved))
+		require.Equal(t, "new", saved.AccessToken)
+		require.Equal(t, "refresh", saved.RefreshToken)
+		require.True(t, saved.Expiry.After(time.Now()))
+
+		stat, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant","error_description":"Token has been expired or revoked."}`))
+		}))
+		defer srv.Close()
+
+		path := filepath.Join(t.TempDir(), "token.json")
+
+		_, err := mail.NewTokenSource(context.Background(), &oauth2.Config{
+			Endpoint: oauth2.Endpoint{TokenURL: srv.URL},
+		}, expired, path).Token()
+		require.ErrorIs(t, err, mail.ErrReauthorizationRequired)
+		require.ErrorContains(t, err, "invalid_grant")
+
+		require.NoFileExists(t, path)
+	})
+
+	t.Run("network failure", func(t *testing.T) {
+		srv := httptest.NewServer(http.NotFoundHandler())
+		srv.Close()
+
+		_, err := mail.NewTokenSource(context.Background(), &oauth2.Config{
+			Endpoint: oauth2.Endpoint{TokenURL: srv.URL},
+		}, expired, filepath.Join(t.TempDir(), "token.json")).Token()
+		require.Error(t, err)
+		require.NotErrorIs(t, err, mail.ErrReauthorizationRequired)
+	})
+}
@@ -1,18 +1,84 @@
 package mail
 
 import (
+	"camrec/alert"
+	"camrec/clock"
+	"camrec/config"
+	"camrec/trigger"
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
+	"html"
+	"log/slog"
+	"net/http"
+	"strings"
 	"time"
 
 	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/pubsub/v1"
 )
 
 type Mail struct {
-	service       *gmail.Service
-	lastMessageId string
-	Done          chan error
+	service *gmail.Service
+	pubsub  *pubsub.Service
+	opts    Options
+	clock   clock.Clock
+	state   *state
+	labels  labels
+	alerts  *alert.Registry
+	skews   map[string]*skew
+	notify  chan uint64
+	Done    chan error
+	// OnInvalid is called with the trigger of the alert without the time,
+	// it should label the message as failed without holding the mail loop
+	OnInvalid func(t trigger.Trigger, err error)
+}
+
+// DefaultQuery selects messages of the last day on the first start, it has
+// no sender filter since the rules of the SMTP cameras match any sender
+const DefaultQuery = "newer_than:1d"
+
+// Options enable push notifications of new messages, the mailbox is polled
+// when the topic is not set or the watch request fails
+type Options struct {
+	// Topic is the Pub/Sub topic of users.watch notifications
+	Topic string
+	// PushListen is the address of the Pub/Sub push endpoint
+	PushListen string
+	// PushToken is the token query parameter of the push endpoint URL
+	PushToken string
+	// Subscription is the Pub/Sub pull subscription of the topic
+	Subscription string
+	// Query selects the messages checked when there is no history cursor,
+	// the messages added to the history are checked when they match its
+	// from: and subject: terms
+	Query string
+	// Label limits the messages to the label ID
+	Label string
+	// StateFile keeps the history cursor and the processed message IDs
+	StateFile string
+	// Modify requests the modify scope to label the processed messages
+	Modify bool
+	// ProcessedLabel is applied after the event is saved
+	ProcessedLabel string
+	// FailedLabel is applied when the event extraction fails
+	FailedLabel string
+	// Archive removes the processed messages from the inbox
+	Archive bool
+	// MarkRead marks the processed messages as read
+	MarkRead bool
+	// Alerts parses the alert emails, the built-in rules are used
+	// when it's not set
+	Alerts *alert.Registry
+	// Cameras map the camera names of the alerts to the camera IDs
+	// and time zones
+	Cameras []config.Camera
+	// MaxSkew is the camera clock error corrected by the receive times
+	// of the alerts, DefaultMaxSkew is used when it's not set
+	MaxSkew time.Duration
 }
 
 type Message struct {
@@ -20,39 +86,112 @@ type Message struct {
 	Timestamp time.Time
 }
 
-func Initialize() (m *Mail, err error) {
-	srv, err := GetService()
+// Scopes returns the OAuth scopes required by the options
+func (opts Options) Scopes() []string {
+	scopes := []string{gmail.GmailReadonlyScope}
+
+	if opts.Modify {
+		scopes = []string{gmail.GmailModifyScope}
+	}
+
+	if opts.Subscription != "" {
+		scopes = append(scopes, pubsub.PubsubScope)
+	}
+
+	return scopes
+}
+
+func Initialize(opts Options) (m *Mail, err error) {
+	client, err := GetClient(opts.Scopes()...)
 	if err != nil {
 		return
 	}
 
-	log.Printf("Gmail service was initialized")
+	srv, err := gmail.NewService(context.Background(), option.WithHTTPClient(client))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create Gmail client: %w", err)
+	}
+
+	slog.Debug("Gmail service was initialized")
+
+	m = New(srv, opts)
+
+	if opts.Subscription != "" {
+		m.pubsub, err = pubsub.NewService(context.Background(), option.WithHTTPClient(client))
+		if err != nil {
+			return nil, fmt.Errorf("unable to create Pub/Sub client: %w", err)
+		}
+	}
+
+	return
+}
+
+// New creates the mail checker of the Gmail service
+func New(srv *gmail.Service, opts Options) *Mail {
+	alerts := opts.Alerts
+	if alerts == nil {
+		alerts = alert.Default()
+	}
+
+	if opts.MaxSkew == 0 {
+		opts.MaxSkew = DefaultMaxSkew
+	}
 
-	m = &Mail{
+	return &Mail{
 		service: srv,
+		opts:    opts,
+		alerts:  alerts,
+		skews:   make(map[string]*skew),
+		clock:   clock.Real,
+		notify:  make(chan uint64, 1),
 		Done:    make(chan error, 1),
 	}
+}
 
-	return
+// SetPubSub sets the Pub/Sub service of the pull subscription
+func (m *Mail) SetPubSub(srv *pubsub.Service) {
+	m.pubsub = srv
+}
+
+// SetClock sets the clock of the check interval
+func (m *Mail) SetClock(c clock.Clock) {
+	m.clock = c
 }
 
-func (m *Mail) StartMessageChecker(ctx context.Context, checkInterval time.Duration) chan time.Time {
-	mch := make(chan time.Time)
+// StartMessageChecker watches the mailbox if the topic is set, otherwise
+// the mailbox is polled with the check interval
+func (m *Mail) StartMessageChecker(ctx context.Context, checkInterval time.Duration) chan trigger.Trigger {
+	mch := make(chan trigger.Trigger)
+
+	if m.opts.Topic != "" {
+		err := m.watch()
+		if err == nil {
+			go m.startWatcher(ctx, mch)
+			return mch
+		}
+
+		slog.Warn("mailbox watch failed, fall back to polling", "err", err)
+	}
 
 	go func() {
-		ticker := time.NewTicker(checkInterval)
+		ticker := m.clock.NewTicker(checkInterval)
 
 		defer ticker.Stop()
 		defer close(mch)
 
-		log.Printf("start mail loop")
+		slog.Info("start mail loop")
+
+		if err := m.start(mch); err != nil {
+			m.Done <- err
+			return
+		}
 
 		for {
 			select {
 			case <-ctx.Done():
 				return
-			case <-ticker.C:
-				if err := m.update(mch); err != nil {
+			case <-ticker.C():
+				if err := m.syncHistory(mch); err != nil {
 					m.Done <- err
 					return
 				}
@@ -63,78 +202,220 @@ func (m *Mail) StartMessageChecker(ctx context.Context, checkInterval time.Durat
 	return mch
 }
 
-func (m *Mail) update(mch chan time.Time) (err error) {
-	messages, err := m.getUnreadMessages()
+// start loads the cursor, the recent messages matching the query are
+// checked when there is no cursor
+func (m *Mail) start(mch chan trigger.Trigger) (err error) {
+	if m.state != nil {
+		return
+	}
+
+	if m.state, err = loadState(m.opts.StateFile); err != nil {
+		return
+	}
+
+	if m.state.HistoryId != 0 {
+		slog.Debug("resume mailbox history", "history", m.state.HistoryId)
+		return
+	}
+
+	return m.syncMessages(mch)
+}
+
+// syncMessages checks the messages matching the query and starts the
+// history from the current mailbox state
+func (m *Mail) syncMessages(mch chan trigger.Trigger) (err error) {
+	// the history ID is taken before the listing, so the messages
+	// received during the listing are in the history
+	profile, err := m.service.Users.GetProfile("me").Do()
+	if err != nil {
+		return fmt.Errorf("unable to get profile: %w", err)
+	}
+
+	ids, err := m.listMessages()
 	if err != nil {
 		return
 	}
 
-	for _, msg := range messages {
-		tsString := ParseTimestamp(msg.Snippet)
-		if tsString == "" {
-			continue
+	// the list starts with the latest message
+	for i := len(ids) - 1; i >= 0; i-- {
+		if err = m.process(ids[i], mch); err != nil {
+			return
+		}
+	}
+
+	m.state.HistoryId = profile.HistoryId
+
+	return m.state.save()
+}
+
+// listMessages returns IDs of the messages matching the query
+func (m *Mail) listMessages() (ids []string, err error) {
+	pageToken := ""
+
+	for {
+		call := m.service.Users.Messages.List("me").Q(m.opts.Query)
+
+		if m.opts.Label != "" {
+			call = call.LabelIds(m.opts.Label)
+		}
+
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
 		}
 
-		ts, err := BuildTimestamp(tsString)
+		res, err := call.Do()
 		if err != nil {
-			continue
+			return nil, fmt.Errorf("unable to fetch messages: %w", err)
 		}
 
-		mch <- *ts
-	}
+		for _, msg := range res.Messages {
+			ids = append(ids, msg.Id)
+		}
 
-	return
+		if res.NextPageToken == "" {
+			return ids, nil
+		}
+
+		pageToken = res.NextPageToken
+	}
 }
 
-func (m *Mail) getUnreadMessages() ([]*gmail.Message, error) {
-	respList, err := m.service.Users.Messages.List("me").Do()
-	if err != nil {
-		return nil, fmt.Errorf("unable to fetch messages: %w", err)
+// process sends the timestamp of the camera message once
+func (m *Mail) process(id string, mch chan trigger.Trigger) error {
+	if m.state.processed(id) {
+		return nil
 	}
 
-	messages := make([]*gmail.Message, 0)
+	msg, err := m.service.Users.Messages.Get("me", id).Do()
+	if err != nil {
+		var apiErr *googleapi.Error
 
-	for _, msg := range respList.Messages {
-		if m.lastMessageId == msg.Id {
-			break
+		// the message was deleted after it was listed
+		if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+			return nil
 		}
 
-		respMsg, err := m.service.Users.Messages.Get("me", msg.Id).Do()
-		if err != nil {
-			return nil, err
+		return fmt.Errorf("unable to fetch message: %w", err)
+	}
+
+	m.state.add(id)
+
+	m.handle(msg, mch)
+
+	return nil
+}
+
+// handle sends the trigger of the alert email, the alert without the
+// time is passed to OnInvalid, the messages out of the query are skipped
+func (m *Mail) handle(msg *gmail.Message, mch chan trigger.Trigger) {
+	content := message(msg)
+
+	if !matchQuery(m.opts.Query, content) {
+		return
+	}
+
+	a, ok, err := m.alerts.Parse(content)
+	if !ok {
+		return
+	}
+
+	camera := m.camera(a)
+
+	t := trigger.Trigger{
+		Camera:    camera.ID,
+		Event:     string(a.Event),
+		MessageID: msg.Id,
+	}
+
+	if err != nil {
+		slog.Warn("invalid alert", "message", msg.Id, "err", err)
+
+		if m.OnInvalid != nil {
+			m.OnInvalid(t, err)
 		}
 
-		if !isHicloudMessage(respMsg) {
+		return
+	}
+
+	loc := camera.Location
+	if loc == nil {
+		loc = time.Local
+	}
+
+	// the unknown cameras are told apart by the alert
+	key := camera.ID
+	if key == "" {
+		key = a.Rule + "/" + a.Serial + "/" + a.Camera
+	}
+
+	rcv := received(msg)
+
+	t.Time = m.correct(key, a.Near(loc, rcv), rcv)
+
+	mch <- t
+}
+
+// camera returns the camera of the alert, the empty ID means all cameras
+func (m *Mail) camera(a alert.Alert) config.Camera {
+	for _, c := range m.opts.Cameras {
+		if c.Alert == "" {
 			continue
 		}
 
-		messages = append(messages, respMsg)
+		if strings.EqualFold(c.Alert, a.Serial) || strings.EqualFold(c.Alert, a.Camera) {
+			return c
+		}
 	}
 
-	for _, msg := range respList.Messages {
-		if m.lastMessageId == msg.Id {
-			break
-		}
+	return config.Camera{}
+}
+
+// message returns the sender, the subject and the plain text of the email,
+// the snippet is taken when there is no plain text part
+func message(msg *gmail.Message) alert.Message {
+	m := alert.Message{
+		Text: html.UnescapeString(msg.Snippet),
+	}
+
+	if msg.Payload == nil {
+		return m
+	}
 
-		if m.lastMessageId == "" || m.lastMessageId != msg.Id {
-			m.lastMessageId = msg.Id
-			break
+	for _, h := range msg.Payload.Headers {
+		switch h.Name {
+		case "From":
+			m.From = h.Value
+		case "Subject":
+			m.Subject = h.Value
 		}
 	}
 
-	return messages, nil
+	if text, ok := plainText(msg.Payload); ok {
+		m.Text = text
+	}
+
+	return m
 }
 
-func isHicloudMessage(msg *gmail.Message) bool {
-	if msg.Payload == nil || msg.Payload.Headers == nil {
-		return false
+// plainText returns the first text/plain part of the message
+func plainText(part *gmail.MessagePart) (string, bool) {
+	if part.MimeType == "text/plain" && part.Body != nil && part.Body.Data != "" {
+		data, err := base64.URLEncoding.DecodeString(part.Body.Data)
+		if err != nil {
+			// the padding is omitted by some clients
+			data, err = base64.RawURLEncoding.DecodeString(part.Body.Data)
+		}
+
+		if err == nil {
+			return string(data), true
+		}
 	}
 
-	for _, v := range msg.Payload.Headers {
-		if v.Name == "From" && v.Value == "no_reply@hicloudcam.com" {
-			return true
+	for _, p := range part.Parts {
+		if text, ok := plainText(p); ok {
+			return text, true
 		}
 	}
 
-	return false
+	return "", false
 }
@@ -0,0 +1,861 @@
+package mail_test
+
+import (
+	"bytes"
+	"camrec/alert"
+	"camrec/clock"
+	"camrec/config"
+	"camrec/mail"
+	"camrec/trigger"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/option"
+	"google.golang.org/api/pubsub/v1"
+)
+
+// fakeGmail serves camera messages, the history is split into pages
+// of a single record
+type fakeGmail struct {
+	t      *testing.T
+	server *httptest.Server
+
+	lock      sync.Mutex
+	messages  []*gmail.Message
+	historyId uint64
+	watch     *gmail.WatchRequest
+	failWatch bool
+	expired   bool
+	calls     map[string]int
+	query     string
+	pulled    chan []byte
+	acked     []string
+	labels    []*gmail.Label
+	modified  map[string]*gmail.ModifyMessageRequest
+}
+
+func newFakeGmail(t *testing.T) *fakeGmail {
+	f := &fakeGmail{
+		t:         t,
+		historyId: 100,
+		calls:     make(map[string]int),
+		pulled:    make(chan []byte, 1),
+		labels:    []*gmail.Label{{Id: "Label_1", Name: "camrec/processed"}},
+		modified:  make(map[string]*gmail.ModifyMessageRequest),
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/gmail/v1/users/me/watch", f.handleWatch)
+	mux.HandleFunc("/gmail/v1/users/me/profile", f.handleProfile)
+	mux.HandleFunc("/gmail/v1/users/me/history", f.handleHistory)
+	mux.HandleFunc("/gmail/v1/users/me/messages", f.handleList)
+	mux.HandleFunc("/gmail/v1/users/me/messages/", f.handleGet)
+	mux.HandleFunc("/gmail/v1/users/me/labels", f.handleLabels)
+	mux.HandleFunc("/v1/projects/p/subscriptions/s:pull", f.handlePull)
+	mux.HandleFunc("/v1/projects/p/subscriptions/s:acknowledge", f.handleAcknowledge)
+
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+
+	return f
+}
+
+func (f *fakeGmail) service() *gmail.Service {
+	srv, err := gmail.NewService(context.Background(),
+		option.WithEndpoint(f.server.URL+"/"),
+		option.WithHTTPClient(f.server.Client()),
+	)
+	require.NoError(f.t, err)
+
+	return srv
+}
+
+func (f *fakeGmail) pubsub() *pubsub.Service {
+	srv, err := pubsub.NewService(context.Background(),
+		option.WithEndpoint(f.server.URL+"/"),
+		option.WithHTTPClient(f.server.Client()),
+	)
+	require.NoError(f.t, err)
+
+	return srv
+}
+
+// add adds the camera message and returns the new history ID
+func (f *fakeGmail) add(from string, ts string) uint64 {
+	return f.addMessage(&gmail.Message{
+		Snippet: "C3WN(K49112334) Motion detection alarm " + ts,
+		Payload: &gmail.MessagePart{
+			Headers: []*gmail.MessagePartHeader{
+				{Name: "From", Value: from},
+			},
+		},
+	})
+}
+
+func (f *fakeGmail) addMessage(msg *gmail.Message) uint64 {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.historyId++
+
+	msg.Id = fmt.Sprintf("m%d", f.historyId)
+	msg.HistoryId = f.historyId
+
+	f.messages = append(f.messages, msg)
+
+	return f.historyId
+}
+
+func (f *fakeGmail) count(call string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.calls[call]
+}
+
+func (f *fakeGmail) handleWatch(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.calls["watch"]++
+
+	if f.failWatch {
+		http.Error(w, `{"error":{"code":403,"message":"forbidden"}}`, http.StatusForbidden)
+		return
+	}
+
+	f.watch = &gmail.WatchRequest{}
+	json.NewDecoder(r.Body).Decode(f.watch)
+
+	json.NewEncoder(w).Encode(gmail.WatchResponse{
+		HistoryId:  f.historyId,
+		Expiration: time.Now().Add(7 * 24 * time.Hour).UnixMilli(),
+	})
+}
+
+func (f *fakeGmail) handleProfile(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	json.NewEncoder(w).Encode(gmail.Profile{HistoryId: f.historyId})
+}
+
+func (f *fakeGmail) handleHistory(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.calls["history"]++
+
+	if f.expired {
+		http.Error(w, `{"error":{"code":404,"message":"not found"}}`, http.StatusNotFound)
+		return
+	}
+
+	var start uint64
+	fmt.Sscan(r.URL.Query().Get("startHistoryId"), &start)
+
+	var records []*gmail.History
+
+	for _, msg := range f.messages {
+		if msg.HistoryId > start {
+			records = append(records, &gmail.History{
+				Id:            msg.HistoryId,
+				MessagesAdded: []*gmail.HistoryMessageAdded{{Message: &gmail.Message{Id: msg.Id}}},
+			})
+		}
+	}
+
+	page := 0
+	fmt.Sscan(r.URL.Query().Get("pageToken"), &page)
+
+	res := gmail.ListHistoryResponse{HistoryId: f.historyId}
+
+	if page < len(records) {
+		res.History = records[page : page+1]
+	}
+
+	if page+1 < len(records) {
+		res.NextPageToken = fmt.Sprint(page + 1)
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
+
+func (f *fakeGmail) handleList(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.calls["list"]++
+	f.query = r.URL.Query().Get("q")
+
+	from := ""
+
+	for _, term := range strings.Fields(f.query) {
+		if v, ok := strings.CutPrefix(term, "from:"); ok {
+			from = v
+		}
+	}
+
+	var ids []string
+
+	// the latest message is the first
+	for i := len(f.messages) - 1; i >= 0; i-- {
+		if from == "" || f.messages[i].Payload.Headers[0].Value == from {
+			ids = append(ids, f.messages[i].Id)
+		}
+	}
+
+	page := 0
+	fmt.Sscan(r.URL.Query().Get("pageToken"), &page)
+
+	res := gmail.ListMessagesResponse{}
+
+	for _, id := range ids[min(page*2, len(ids)):min(page*2+2, len(ids))] {
+		res.Messages = append(res.Messages, &gmail.Message{Id: id})
+	}
+
+	if page*2+2 < len(ids) {
+		res.NextPageToken = fmt.Sprint(page + 1)
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
+
+func (f *fakeGmail) handleLabels(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.calls["labels "+r.Method]++
+
+	if r.Method == http.MethodPost {
+		l := &gmail.Label{}
+		json.NewDecoder(r.Body).Decode(l)
+
+		l.Id = fmt.Sprintf("Label_%d", len(f.labels)+1)
+		f.labels = append(f.labels, l)
+
+		json.NewEncoder(w).Encode(l)
+		return
+	}
+
+	json.NewEncoder(w).Encode(gmail.ListLabelsResponse{Labels: f.labels})
+}
+
+func (f *fakeGmail) handleGet(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if id, ok := strings.CutSuffix(r.URL.Path, "/modify"); ok {
+		req := &gmail.ModifyMessageRequest{}
+		json.NewDecoder(r.Body).Decode(req)
+
+		f.modified[strings.TrimPrefix(id, "/gmail/v1/users/me/messages/")] = req
+
+		json.NewEncoder(w).Encode(gmail.Message{})
+		return
+	}
+
+	f.calls["get"]++
+
+	id := strings.TrimPrefix(r.URL.Path, "/gmail/v1/users/me/messages/")
+
+	for _, msg := range f.messages {
+		if msg.Id == id {
+			json.NewEncoder(w).Encode(msg)
+			return
+		}
+	}
+
+	http.Error(w, `{"error":{"code":404,"message":"not found"}}`, http.StatusNotFound)
+}
+
+func (f *fakeGmail) handlePull(w http.ResponseWriter, r *http.Request) {
+	res := pubsub.PullResponse{}
+
+	select {
+	case data := <-f.pulled:
+		res.ReceivedMessages = append(res.ReceivedMessages, &pubsub.ReceivedMessage{
+			AckId:   "ack1",
+			Message: &pubsub.PubsubMessage{Data: base64.StdEncoding.EncodeToString(data)},
+		})
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
+
+func (f *fakeGmail) handleAcknowledge(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var req pubsub.AcknowledgeRequest
+	json.NewDecoder(r.Body).Decode(&req)
+
+	f.acked = append(f.acked, req.AckIds...)
+
+	w.Write([]byte("{}"))
+}
+
+func notification(historyId uint64) []byte {
+	data, _ := json.Marshal(map[string]any{
+		"emailAddress": "user@example.com",
+		"historyId":    historyId,
+	})
+
+	return data
+}
+
+func push(t *testing.T, handler http.Handler, target string, historyId uint64) int {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]any{
+		"message": map[string]any{
+			"data":      base64.StdEncoding.EncodeToString(notification(historyId)),
+			"messageId": "1",
+		},
+		"subscription": "projects/p/subscriptions/s",
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, target, bytes.NewReader(body)))
+
+	return rec.Code
+}
+
+func receive(t *testing.T, tschan chan trigger.Trigger) time.Time {
+	t.Helper()
+
+	return receiveTrigger(t, tschan).Time
+}
+
+func receiveTrigger(t *testing.T, tschan chan trigger.Trigger) trigger.Trigger {
+	t.Helper()
+
+	select {
+	case tr := <-tschan:
+		return tr
+	case <-time.After(time.Second):
+		t.Fatal("no timestamp")
+	}
+
+	return trigger.Trigger{}
+}
+
+const hicloud = "no_reply@hicloudcam.com"
+
+// hicloudQuery selects the messages of the EZVIZ cloud
+const hicloudQuery = "from:" + hicloud + " newer_than:1d"
+
+var (
+	start = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	alarm = time.Date(2023, 8, 30, 22, 41, 6, 0, time.Local)
+)
+
+func TestMessageChecker(t *testing.T) {
+	t.Run("polling", func(t *testing.T) {
+		f := newFakeGmail(t)
+		f.add(hicloud, "2023-08-30 22:41:06")
+
+		c := clock.NewVirtual(start)
+
+		m := mail.New(f.service(), mail.Options{Query: mail.DefaultQuery})
+		m.SetClock(c)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		tschan := m.StartMessageChecker(ctx, 5*time.Second)
+
+		// the messages matching the query are checked on start
+		require.Equal(t, alarm, receive(t, tschan))
+		require.Equal(t, mail.DefaultQuery, f.query)
+
+		f.add(hicloud, "2023-08-30 22:41:07")
+
+		// the checker polls only on the ticks
+		require.Eventually(t, func() bool { return c.Waiters() == 1 }, time.Second, time.Millisecond)
+
+		c.Advance(4 * time.Second)
+		require.Zero(t, f.count("history"))
+
+		c.Advance(time.Second)
+		require.Equal(t, alarm.Add(time.Second), receive(t, tschan))
+		require.Equal(t, 1, f.count("history"))
+
+		// the messages are not reported twice
+		c.Advance(5 * time.Second)
+		require.Eventually(t, func() bool { return f.count("history") == 2 }, time.Second, time.Millisecond)
+
+		require.Equal(t, 1, f.count("list"))
+		require.Equal(t, 2, f.count("get"))
+		require.Empty(t, tschan)
+
+		cancel()
+
+		_, ok := <-tschan
+		require.False(t, ok)
+	})
+
+	t.Run("pagination and query", func(t *testing.T) {
+		f := newFakeGmail(t)
+
+		for i := 0; i < 5; i++ {
+			f.add(hicloud, fmt.Sprintf("2023-08-30 22:41:0%d", i))
+			f.add("someone@example.com", "2023-08-30 22:42:00")
+		}
+
+		m := mail.New(f.service(), mail.Options{Query: hicloudQuery})
+		m.SetClock(clock.NewVirtual(start))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		tschan := m.StartMessageChecker(ctx, 5*time.Second)
+
+		// the oldest message is the first
+		for i := 0; i < 5; i++ {
+			require.Equal(t, time.Date(2023, 8, 30, 22, 41, i, 0, time.Local), receive(t, tschan))
+		}
+
+		// three pages of two messages
+		require.Equal(t, 3, f.count("list"))
+		require.Equal(t, 5, f.count("get"))
+	})
+
+	t.Run("persisted cursor", func(t *testing.T) {
+		f := newFakeGmail(t)
+		f.add(hicloud, "2023-08-30 22:41:06")
+
+		stateFile := filepath.Join(t.TempDir(), "mail.json")
+
+		run := func(expected ...time.Time) {
+			c := clock.NewVirtual(start)
+
+			m := mail.New(f.service(), mail.Options{Query: mail.DefaultQuery, StateFile: stateFile})
+			m.SetClock(c)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			tschan := m.StartMessageChecker(ctx, 5*time.Second)
+
+			require.Eventually(t, func() bool { return c.Waiters() == 1 }, time.Second, time.Millisecond)
+			c.Advance(5 * time.Second)
+
+			for _, ts := range expected {
+				require.Equal(t, ts, receive(t, tschan))
+			}
+
+			cancel()
+
+			for range tschan {
+				t.Fatal("unexpected timestamp")
+			}
+		}
+
+		run(alarm)
+
+		info, err := os.Stat(stateFile)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+		// the messages received while stopped are in the history
+		f.add(hicloud, "2023-08-30 22:41:07")
+
+		run(alarm.Add(time.Second))
+
+		require.Equal(t, 1, f.count("list"))
+		require.Equal(t, 2, f.count("get"))
+
+		// the processed messages are skipped when the history is expired
+		f.expired = true
+
+		run()
+
+		require.Equal(t, 2, f.count("list"))
+		require.Equal(t, 2, f.count("get"))
+	})
+
+	t.Run("invalid state", func(t *testing.T) {
+		f := newFakeGmail(t)
+
+		stateFile := filepath.Join(t.TempDir(), "mail.json")
+		require.NoError(t, os.WriteFile(stateFile, []byte("{"), 0600))
+
+		m := mail.New(f.service(), mail.Options{StateFile: stateFile})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		m.StartMessageChecker(ctx, 5*time.Second)
+
+		require.Error(t, <-m.Done)
+	})
+}
+func TestWatcher(t *testing.T) {
+	t.Run("push", func(t *testing.T) {
+		f := newFakeGmail(t)
+		f.add(hicloud, "2023-08-30 22:00:00")
+
+		m := mail.New(f.service(), mail.Options{Topic: "projects/p/topics/gmail", PushToken: "secret", Query: hicloudQuery})
+		m.SetClock(clock.NewVirtual(start))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		tschan := m.StartMessageChecker(ctx, 5*time.Second)
+
+		require.Equal(t, "projects/p/topics/gmail", f.watch.TopicName)
+		require.Equal(t, []string{"INBOX"}, f.watch.LabelIds)
+
+		// the messages before the watch are checked on start
+		require.Equal(t, time.Date(2023, 8, 30, 22, 0, 0, 0, time.Local), receive(t, tschan))
+
+		f.add(hicloud, "2023-08-30 22:41:06")
+		// the alert of the rule sender out of the query
+		f.add("alerts@ezvizlife.com", "2023-08-30 22:41:07")
+		historyId := f.add(hicloud, "2023-08-30 22:41:08")
+
+		require.Equal(t, http.StatusForbidden, push(t, m.PushHandler(), "/?token=wrong", historyId))
+		require.Equal(t, http.StatusNoContent, push(t, m.PushHandler(), "/?token=secret", historyId))
+
+		require.Equal(t, alarm, receive(t, tschan))
+		require.Equal(t, alarm.Add(2*time.Second), receive(t, tschan))
+
+		// three pages of a single record
+		require.Equal(t, 3, f.count("history"))
+
+		// the sender of the added messages is matched locally, the query
+		// isn't searched again
+		require.Equal(t, 1, f.count("list"))
+		require.Equal(t, 4, f.count("get"))
+
+		// the outdated notification is skipped
+		require.Equal(t, http.StatusNoContent, push(t, m.PushHandler(), "/?token=secret", historyId))
+		require.Never(t, func() bool { return f.count("history") > 3 }, 50*time.Millisecond, time.Millisecond)
+	})
+
+	t.Run("pull", func(t *testing.T) {
+		f := newFakeGmail(t)
+
+		m := mail.New(f.service(), mail.Options{Topic: "projects/p/topics/gmail", Subscription: "projects/p/subscriptions/s"})
+		m.SetPubSub(f.pubsub())
+		m.SetClock(clock.NewVirtual(start))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		tschan := m.StartMessageChecker(ctx, 5*time.Second)
+
+		f.pulled <- notification(f.add(hicloud, "2023-08-30 22:41:06"))
+
+		require.Equal(t, alarm, receive(t, tschan))
+
+		require.Eventually(t, func() bool {
+			f.lock.Lock()
+			defer f.lock.Unlock()
+
+			return len(f.acked) == 1
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("periodic sync", func(t *testing.T) {
+		f := newFakeGmail(t)
+		c := clock.NewVirtual(start)
+
+		m := mail.New(f.service(), mail.Options{Topic: "projects/p/topics/gmail"})
+		m.SetClock(c)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		tschan := m.StartMessageChecker(ctx, 5*time.Second)
+
+		f.add(hicloud, "2023-08-30 22:41:06")
+
+		// sync and renew tickers
+		require.Eventually(t, func() bool { return c.Waiters() == 2 }, time.Second, time.Millisecond)
+
+		c.Advance(time.Minute)
+		require.Equal(t, alarm, receive(t, tschan))
+
+		c.Advance(24 * time.Hour)
+		require.Eventually(t, func() bool { return f.count("watch") == 2 }, time.Second, time.Millisecond)
+	})
+
+	t.Run("expired history", func(t *testing.T) {
+		f := newFakeGmail(t)
+		f.expired = true
+
+		m := mail.New(f.service(), mail.Options{Topic: "projects/p/topics/gmail"})
+		m.SetClock(clock.NewVirtual(start))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		tschan := m.StartMessageChecker(ctx, 5*time.Second)
+
+		require.Eventually(t, func() bool { return f.count("list") == 1 }, time.Second, time.Millisecond)
+
+		require.Equal(t, http.StatusNoContent, push(t, m.PushHandler(), "/", f.add(hicloud, "2023-08-30 22:41:06")))
+
+		require.Equal(t, alarm, receive(t, tschan))
+		require.Equal(t, 2, f.count("list"))
+	})
+
+	t.Run("fallback to polling", func(t *testing.T) {
+		f := newFakeGmail(t)
+		f.failWatch = true
+		f.add(hicloud, "2023-08-30 22:41:06")
+
+		c := clock.NewVirtual(start)
+
+		m := mail.New(f.service(), mail.Options{Topic: "projects/p/topics/gmail"})
+		m.SetClock(c)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		tschan := m.StartMessageChecker(ctx, 5*time.Second)
+
+		require.Eventually(t, func() bool { return c.Waiters() == 1 }, time.Second, time.Millisecond)
+
+		require.Equal(t, alarm, receive(t, tschan))
+		require.Zero(t, f.count("history"))
+	})
+}
+
+func TestAcknowledge(t *testing.T) {
+	opts := mail.Options{
+		Modify:         true,
+		ProcessedLabel: mail.DefaultProcessedLabel,
+		FailedLabel:    mail.DefaultFailedLabel,
+		Archive:        true,
+		MarkRead:       true,
+	}
+
+	t.Run("processed and failed", func(t *testing.T) {
+		f := newFakeGmail(t)
+		f.add(hicloud, "2023-08-30 22:41:06")
+		f.add(hicloud, "no timestamp")
+
+		m := mail.New(f.service(), opts)
+		m.SetClock(clock.NewVirtual(start))
+		m.OnInvalid = func(t trigger.Trigger, err error) {
+			m.Acknowledge(context.Background(), t, err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		tschan := m.StartMessageChecker(ctx, 5*time.Second)
+
+		tr := receiveTrigger(t, tschan)
+		require.Equal(t, "m101", tr.MessageID)
+
+		m.Acknowledge(ctx, tr, nil)
+		m.Acknowledge(ctx, trigger.Trigger{MessageID: "m103"}, errors.New("not buffered"))
+
+		f.lock.Lock()
+		defer f.lock.Unlock()
+
+		require.Equal(t, &gmail.ModifyMessageRequest{
+			AddLabelIds:    []string{"Label_1"},
+			RemoveLabelIds: []string{"INBOX", "UNREAD"},
+		}, f.modified["m101"])
+
+		// the failed label is created once
+		require.Equal(t, &gmail.ModifyMessageRequest{AddLabelIds: []string{"Label_2"}}, f.modified["m102"])
+		require.Equal(t, &gmail.ModifyMessageRequest{AddLabelIds: []string{"Label_2"}}, f.modified["m103"])
+		require.Equal(t, "camrec/failed", f.labels[1].Name)
+		require.Equal(t, 1, f.calls["labels GET"])
+		require.Equal(t, 1, f.calls["labels POST"])
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		f := newFakeGmail(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		m := mail.New(f.service(), opts)
+		m.Acknowledge(ctx, trigger.Trigger{MessageID: "m101"}, nil)
+
+		require.Empty(t, f.modified)
+		require.Zero(t, f.calls["labels GET"])
+	})
+
+	t.Run("read-only", func(t *testing.T) {
+		f := newFakeGmail(t)
+
+		m := mail.New(f.service(), mail.Options{ProcessedLabel: mail.DefaultProcessedLabel})
+		m.Acknowledge(context.Background(), trigger.Trigger{MessageID: "m101"}, nil)
+
+		require.Empty(t, f.modified)
+	})
+}
+
+func TestAlerts(t *testing.T) {
+	f := newFakeGmail(t)
+
+	f.addMessage(&gmail.Message{
+		Snippet: "This is an auto-sent e-mail from DS-7608NI-K2. EVENT TYPE: Line Crossing",
+		Payload: &gmail.MessagePart{
+			MimeType: "multipart/alternative",
+			Headers: []*gmail.MessagePartHeader{
+				{Name: "From", Value: "nvr@example.com"},
+				{Name: "Subject", Value: "Alarm Notification"},
+			},
+			Parts: []*gmail.MessagePart{
+				{MimeType: "text/html", Body: &gmail.MessagePartBody{
+					Data: base64.URLEncoding.EncodeToString([]byte("<p>EVENT TYPE: Motion</p>")),
+				}},
+				{MimeType: "text/plain", Body: &gmail.MessagePartBody{
+					Data: base64.URLEncoding.EncodeToString([]byte("EVENT TYPE: Line Crossing\n" +
+						"EVENT TIME: 2024-02-14,18:03:27\n" +
+						"CAMERA NAME(NUM): Backyard(D2)\n")),
+				}},
+			},
+		},
+	})
+
+	// an unknown camera triggers all cameras
+	f.add(hicloud, "2023-08-30 22:41:06")
+	// the alert without the time is invalid
+	f.add(hicloud, "")
+
+	m := mail.New(f.service(), mail.Options{
+		Query: "from:",
+		Cameras: []config.Camera{
+			{ID: "front", Alert: "front door"},
+			{ID: "back", Alert: "backyard"},
+		},
+	})
+	m.SetClock(clock.NewVirtual(start))
+
+	invalid := make(chan trigger.Trigger, 1)
+	m.OnInvalid = func(t trigger.Trigger, err error) {
+		if errors.Is(err, alert.ErrNoTime) {
+			invalid <- t
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tschan := m.StartMessageChecker(ctx, 5*time.Second)
+
+	require.Equal(t, trigger.Trigger{
+		Time:      time.Date(2024, 2, 14, 18, 3, 27, 0, time.Local),
+		Camera:    "back",
+		Event:     "line_crossing",
+		MessageID: "m101",
+	}, receiveTrigger(t, tschan))
+
+	require.Equal(t, trigger.Trigger{
+		Time:      time.Date(2023, 8, 30, 22, 41, 6, 0, time.Local),
+		Event:     "motion",
+		MessageID: "m102",
+	}, receiveTrigger(t, tschan))
+
+	select {
+	case tr := <-invalid:
+		require.Equal(t, trigger.Trigger{Event: "motion", MessageID: "m103"}, tr)
+	case <-time.After(time.Second):
+		require.Fail(t, "invalid alert wasn't passed")
+	}
+}
+
+func TestClockSkew(t *testing.T) {
+	f := newFakeGmail(t)
+
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	require.NoError(t, err)
+
+	// alert adds the message of the camera clock time received after the delay
+	alert := func(serial string, ts time.Time, delay time.Duration) {
+		f.addMessage(&gmail.Message{
+			Snippet:      fmt.Sprintf("C3WN(%s) Motion detection alarm %s", serial, ts.Format(time.DateTime)),
+			InternalDate: ts.Add(delay).UnixMilli(),
+			Payload: &gmail.MessagePart{
+				Headers: []*gmail.MessagePartHeader{{Name: "From", Value: hicloud}},
+			},
+		})
+	}
+
+	event := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 4; i++ {
+		ts := event.Add(time.Duration(i) * time.Minute)
+
+		// the time zone is set
+		alert("K49112334", ts.In(shanghai), 10*time.Second)
+		// the camera is set to the next time zone
+		alert("E12345678", ts.Add(time.Hour), -time.Hour+5*time.Second)
+		// the camera clock is 5 minutes ahead
+		alert("C12345678", ts.Add(5*time.Minute), -5*time.Minute+[]time.Duration{20, 5, 30, 10}[i]*time.Second)
+		// the emails of the camera are delivered late but one
+		alert("D12345678", ts, []time.Duration{180, 10, 240, 180}[i]*time.Second)
+		// the camera clock is 10 minutes behind
+		alert("B12345678", ts.Add(-10*time.Minute), 10*time.Minute+[]time.Duration{20, 5, 30, 10}[i]*time.Second)
+	}
+
+	m := mail.New(f.service(), mail.Options{
+		Query: "from:",
+		Cameras: []config.Camera{
+			{ID: "front", Alert: "K49112334", Location: shanghai},
+			{ID: "back", Alert: "E12345678", Location: time.UTC},
+			{ID: "side", Alert: "C12345678", Location: time.UTC},
+			{ID: "late", Alert: "D12345678", Location: time.UTC},
+			{ID: "slow", Alert: "B12345678", Location: time.UTC},
+		},
+	})
+	m.SetClock(clock.NewVirtual(start))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tschan := m.StartMessageChecker(ctx, 5*time.Second)
+
+	for i := 0; i < 4; i++ {
+		ts := event.Add(time.Duration(i) * time.Minute)
+
+		require.True(t, ts.Equal(receive(t, tschan)))
+
+		back := receive(t, tschan)
+		side := receive(t, tschan)
+
+		// the delivery delay isn't taken for the camera clock behind
+		require.True(t, ts.Equal(receive(t, tschan)))
+
+		slow := receive(t, tschan)
+
+		// the first alerts aren't enough to tell the clock error
+		if i < 2 {
+			require.True(t, ts.Add(time.Hour).Equal(back))
+			require.True(t, ts.Add(5*time.Minute).Equal(side))
+			require.True(t, ts.Add(-10*time.Minute).Equal(slow))
+			continue
+		}
+
+		// the time zone error is corrected exactly, the clock error is
+		// corrected up to the delay of the least delayed alert
+		require.True(t, ts.Equal(back), "back %s", back)
+		require.True(t, ts.Add(5*time.Second).Equal(side), "side %s", side)
+		require.True(t, ts.Add(5*time.Second).Equal(slow), "slow %s", slow)
+	}
+}
@@ -0,0 +1,109 @@
+package mail
+
+import (
+	"camrec/trigger"
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// Default labels of the modify mode
+const (
+	DefaultProcessedLabel = "camrec/processed"
+	DefaultFailedLabel    = "camrec/failed"
+)
+
+// labels caches IDs of the user labels by name
+type labels struct {
+	lock sync.Mutex
+	ids  map[string]string
+}
+
+// Acknowledge labels the message of the trigger in the modify mode,
+// the nil error means the event was saved. The context limits the
+// Gmail requests
+func (m *Mail) Acknowledge(ctx context.Context, t trigger.Trigger, err error) {
+	if !m.opts.Modify || t.MessageID == "" {
+		return
+	}
+
+	req := &gmail.ModifyMessageRequest{}
+
+	name := m.opts.ProcessedLabel
+
+	if err != nil {
+		name = m.opts.FailedLabel
+	} else {
+		if m.opts.Archive {
+			req.RemoveLabelIds = append(req.RemoveLabelIds, "INBOX")
+		}
+
+		if m.opts.MarkRead {
+			req.RemoveLabelIds = append(req.RemoveLabelIds, "UNREAD")
+		}
+	}
+
+	if name != "" {
+		id, err := m.labelID(ctx, name)
+		if err != nil {
+			slog.Warn("message label failed", "message", t.MessageID, "err", err)
+			return
+		}
+
+		req.AddLabelIds = append(req.AddLabelIds, id)
+	}
+
+	if len(req.AddLabelIds) == 0 && len(req.RemoveLabelIds) == 0 {
+		return
+	}
+
+	if _, err := m.service.Users.Messages.Modify("me", t.MessageID, req).Context(ctx).Do(); err != nil {
+		slog.Warn("message modify failed", "message", t.MessageID, "err", err)
+	}
+}
+
+// labelID returns ID of the user label, the label is created if it
+// doesn't exist
+func (m *Mail) labelID(ctx context.Context, name string) (string, error) {
+	m.labels.lock.Lock()
+	defer m.labels.lock.Unlock()
+
+	if id, ok := m.labels.ids[name]; ok {
+		return id, nil
+	}
+
+	if m.labels.ids == nil {
+		res, err := m.service.Users.Labels.List("me").Context(ctx).Do()
+		if err != nil {
+			return "", fmt.Errorf("unable to fetch labels: %w", err)
+		}
+
+		m.labels.ids = make(map[string]string)
+
+		for _, l := range res.Labels {
+			m.labels.ids[l.Name] = l.Id
+		}
+
+		if id, ok := m.labels.ids[name]; ok {
+			return id, nil
+		}
+	}
+
+	l, err := m.service.Users.Labels.Create("me", &gmail.Label{
+		Name:                  name,
+		LabelListVisibility:   "labelShow",
+		MessageListVisibility: "show",
+	}).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("unable to create label %q: %w", name, err)
+	}
+
+	slog.Info("label was created", "label", name)
+
+	m.labels.ids[name] = l.Id
+
+	return l.Id, nil
+}
@@ -0,0 +1,184 @@
+package mail
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/pubsub/v1"
+)
+
+// notification is the data of Gmail Pub/Sub message
+type notification struct {
+	EmailAddress string `json:"emailAddress"`
+	HistoryId    uint64 `json:"historyId"`
+}
+
+// pushRequest is the body of Pub/Sub push request
+type pushRequest struct {
+	Message struct {
+		Data      string `json:"data"`
+		MessageId string `json:"messageId"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+}
+
+func parseNotification(data string) (n notification, err error) {
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return n, fmt.Errorf("invalid message data: %w", err)
+	}
+
+	if err = json.Unmarshal(raw, &n); err != nil {
+		return n, fmt.Errorf("invalid notification: %w", err)
+	}
+
+	if n.HistoryId == 0 {
+		return n, errors.New("notification has no history ID")
+	}
+
+	return
+}
+
+// PushHandler receives Pub/Sub push requests, the push endpoint URL must
+// have the token query parameter when the push token is set
+func (m *Mail) PushHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		token := r.URL.Query().Get("token")
+
+		if m.opts.PushToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(m.opts.PushToken)) != 1 {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		var req pushRequest
+
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+
+		n, err := parseNotification(req.Message.Data)
+		if err != nil {
+			// the message is acknowledged, so Pub/Sub doesn't redeliver it
+			slog.Warn("push notification skipped", "err", err)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		m.notifyHistory(n.HistoryId)
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// notifyHistory wakes up the watcher, pending notifications are coalesced
+// since the watcher fetches the whole history since the last sync
+func (m *Mail) notifyHistory(historyId uint64) {
+	for {
+		select {
+		case m.notify <- historyId:
+			return
+		default:
+		}
+
+		select {
+		case pending := <-m.notify:
+			historyId = max(historyId, pending)
+		default:
+		}
+	}
+}
+
+func (m *Mail) startPushReceiver(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.Handle("/", m.PushHandler())
+
+	server := &http.Server{
+		Addr:              m.opts.PushListen,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	slog.Info("start push receiver", "addr", m.opts.PushListen)
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("push receiver failed", "err", err)
+	}
+}
+
+// startPuller pulls notifications from the subscription
+func (m *Mail) startPuller(ctx context.Context) {
+	if m.pubsub == nil {
+		slog.Error("no Pub/Sub service", "subscription", m.opts.Subscription)
+		return
+	}
+
+	slog.Info("start pulling", "subscription", m.opts.Subscription)
+
+	for ctx.Err() == nil {
+		if err := m.pull(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("pull failed", "err", err)
+
+			select {
+			case <-ctx.Done():
+			case <-m.clock.After(historySyncInterval):
+			}
+		}
+	}
+}
+
+func (m *Mail) pull(ctx context.Context) error {
+	subscriptions := m.pubsub.Projects.Subscriptions
+
+	res, err := subscriptions.Pull(m.opts.Subscription, &pubsub.PullRequest{
+		MaxMessages: 10,
+	}).Context(ctx).Do()
+	if err != nil {
+		return err
+	}
+
+	if len(res.ReceivedMessages) == 0 {
+		return nil
+	}
+
+	ackIds := make([]string, 0, len(res.ReceivedMessages))
+
+	for _, received := range res.ReceivedMessages {
+		ackIds = append(ackIds, received.AckId)
+
+		if received.Message == nil {
+			continue
+		}
+
+		n, err := parseNotification(received.Message.Data)
+		if err != nil {
+			slog.Warn("pulled notification skipped", "err", err)
+			continue
+		}
+
+		m.notifyHistory(n.HistoryId)
+	}
+
+	_, err = subscriptions.Acknowledge(m.opts.Subscription, &pubsub.AcknowledgeRequest{
+		AckIds: ackIds,
+	}).Context(ctx).Do()
+
+	return err
+}
@@ -0,0 +1,75 @@
+package mail
+
+import (
+	"camrec/alert"
+	"strings"
+)
+
+// matchQuery tells if the message matches the from: and subject: terms of
+// the Gmail query. The messages added to the history aren't searched, so
+// the sender and the subject are checked locally: any term of the kind
+// matches the part of the header, the other terms are left to the search
+func matchQuery(query string, msg alert.Message) bool {
+	var from, subject []string
+
+	for _, term := range queryTerms(query) {
+		key, value, ok := strings.Cut(term, ":")
+		if !ok {
+			continue
+		}
+
+		value = strings.ToLower(strings.Trim(value, `"()`))
+
+		switch strings.ToLower(key) {
+		case "from":
+			from = append(from, value)
+		case "subject":
+			subject = append(subject, value)
+		}
+	}
+
+	return containsAny(msg.From, from) && containsAny(msg.Subject, subject)
+}
+
+// queryTerms splits the query by the spaces and the braces out of quotes
+func queryTerms(query string) (terms []string) {
+	var (
+		term   strings.Builder
+		quoted bool
+	)
+
+	for _, r := range query + " " {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			term.WriteRune(r)
+		case !quoted && (r == ' ' || r == '\t' || r == '{' || r == '}'):
+			if term.Len() > 0 {
+				terms = append(terms, term.String())
+				term.Reset()
+			}
+		default:
+			term.WriteRune(r)
+		}
+	}
+
+	return
+}
+
+// containsAny tells if the header contains any of the lowercase values,
+// it's true when there are no values
+func containsAny(header string, values []string) bool {
+	if len(values) == 0 {
+		return true
+	}
+
+	header = strings.ToLower(header)
+
+	for _, v := range values {
+		if strings.Contains(header, v) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -3,99 +3,152 @@ package mail
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
-	"time"
+	"sync"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
-	"google.golang.org/api/gmail/v1"
-	"google.golang.org/api/option"
 )
 
-func GetService() (srv *gmail.Service, err error) {
-	ctx := context.Background()
+var (
+	// CredentialsFile is the OAuth client secret downloaded from the console
+	CredentialsFile = "credentials.json"
+	// TokenFile keeps the access and refresh tokens of the user
+	TokenFile = "token.json"
+)
+
+var (
+	// ErrAuthorizationRequired is returned when there is no token,
+	// the token is created by the auth command
+	ErrAuthorizationRequired = errors.New("authorization required, run camrec auth")
+	// ErrReauthorizationRequired is returned when the token can't be refreshed
+	ErrReauthorizationRequired = errors.New("re-authorization required, run camrec auth")
+)
 
-	b, err := os.ReadFile("credentials.json")
+// Config returns the OAuth client config with the scopes
+func Config(scopes ...string) (*oauth2.Config, error) {
+	b, err := os.ReadFile(CredentialsFile)
 	if err != nil {
 		return nil, fmt.Errorf("unable to read client secret: %w", err)
 	}
 
-	// If modifying these scopes, delete your previously saved token.json.
-	config, err := google.ConfigFromJSON(b, gmail.GmailReadonlyScope)
+	config, err := google.ConfigFromJSON(b, scopes...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to parse client secret: %w", err)
 	}
 
-	config.RedirectURL = "http://localhost:8000"
-
-	client := getClient(config)
+	return config, nil
+}
 
-	srv, err = gmail.NewService(ctx, option.WithHTTPClient(client))
+// GetClient returns HTTP client authorized with the scopes
+func GetClient(scopes ...string) (*http.Client, error) {
+	config, err := Config(scopes...)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create Gmail client: %w", err)
+		return nil, err
 	}
 
-	return srv, nil
-}
+	tok, err := tokenFromFile(TokenFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrAuthorizationRequired
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read token: %w", err)
+	}
 
-// Retrieve a token, saves the token, then returns the generated client.
-func getClient(config *oauth2.Config) *http.Client {
-	// The file token.json stores the user's access and refresh tokens, and is
-	// created automatically when the authorization flow completes for the first
-	// time.
-	tokFile := "token.json"
+	ctx := context.Background()
 
-	tok, err := tokenFromFile(tokFile)
+	ts := NewTokenSource(ctx, config, tok, TokenFile)
 
-	if err != nil || tok.Expiry.Before(time.Now()) {
-		tok = getTokenFromWeb(config)
-		saveToken(tokFile, tok)
+	// the revoked token is reported at the start rather than by the first
+	// request, other errors are retried by the requests
+	if _, err = ts.Token(); errors.Is(err, ErrReauthorizationRequired) {
+		return nil, err
 	}
 
-	return config.Client(context.Background(), tok)
+	return oauth2.NewClient(ctx, ts), nil
 }
 
-// Request a token from the web, then returns the retrieved token.
-func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	fmt.Printf("Go to the following link in your browser then type the "+
-		"authorization code: \n%v\n", authURL)
+// tokenSource refreshes the expired token and saves the rotated tokens
+type tokenSource struct {
+	lock sync.Mutex
+	path string
+	src  oauth2.TokenSource
+	last *oauth2.Token
+}
 
-	var authCode string
-	if _, err := fmt.Scan(&authCode); err != nil {
-		log.Fatalf("unable to read authorization code: %v", err)
+// NewTokenSource returns the token source refreshing the token with its
+// refresh token, the new tokens are saved to the file
+func NewTokenSource(ctx context.Context, config *oauth2.Config, tok *oauth2.Token, path string) oauth2.TokenSource {
+	return &tokenSource{
+		path: path,
+		src:  config.TokenSource(ctx, tok),
+		last: tok,
 	}
+}
 
-	tok, err := config.Exchange(context.TODO(), authCode)
+func (s *tokenSource) Token() (*oauth2.Token, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	tok, err := s.src.Token()
 	if err != nil {
-		log.Fatalf("unable to retrieve token from web: %v", err)
+		var retrieveErr *oauth2.RetrieveError
+
+		// the refresh token is rejected, it's revoked or expired
+		rejected := errors.As(err, &retrieveErr) && retrieveErr.Response != nil &&
+			retrieveErr.Response.StatusCode < http.StatusInternalServerError
+
+		if rejected || s.last.RefreshToken == "" {
+			return nil, fmt.Errorf("%w: %w", ErrReauthorizationRequired, err)
+		}
+
+		return nil, err
+	}
+
+	if tok.AccessToken == s.last.AccessToken && tok.RefreshToken == s.last.RefreshToken {
+		return tok, nil
+	}
+
+	s.last = tok
+
+	// the refreshed token is used anyway, it's refreshed again after restart
+	if err := SaveToken(s.path, tok); err != nil {
+		slog.Warn("token save failed", "err", err)
 	}
 
-	return tok
+	return tok, nil
 }
 
-// Retrieves a token from a local file.
+// tokenFromFile reads the token saved by the auth command
 func tokenFromFile(file string) (*oauth2.Token, error) {
 	f, err := os.Open(file)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
+
 	tok := &oauth2.Token{}
 	err = json.NewDecoder(f).Decode(tok)
+
 	return tok, err
 }
 
-// Saves a token to a file path.
-func saveToken(path string, token *oauth2.Token) {
-	fmt.Printf("Saving credential file to: %s\n", path)
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+// SaveToken writes the token atomically, the file is readable by
+// the owner only
+func SaveToken(path string, token *oauth2.Token) error {
+	data, err := json.Marshal(token)
 	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+		return err
 	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
+
+	if err = writeFile(path, data); err != nil {
+		return fmt.Errorf("unable to cache oauth token: %w", err)
+	}
+
+	return nil
 }
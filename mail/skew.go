@@ -0,0 +1,107 @@
+package mail
+
+import (
+	"log/slog"
+	"net/mail"
+	"slices"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+)
+
+// DefaultMaxSkew is the difference between the alert time and the receive
+// time which isn't corrected as the camera clock error and the tolerance of
+// the time zone offsets, it's larger than the usual delivery delay
+const DefaultMaxSkew = 2 * time.Minute
+
+const (
+	// skewSamples is the number of the recent alerts of the skew estimate
+	skewSamples = 5
+	// minSkewSamples prevents the correction by a single delayed email
+	minSkewSamples = 3
+	// zoneStep is the step of the time zone offsets, the skew close to
+	// the step multiple is the wrong time zone of the camera
+	zoneStep = 15 * time.Minute
+)
+
+// skew estimates the camera clock error from the alert and receive times
+type skew struct {
+	samples []time.Duration
+	offset  time.Duration
+}
+
+// correct returns the alert time adjusted by the clock error of the camera.
+// The samples are the clock error less the delivery delay, so the least
+// delayed one is taken, the offsets within the max skew are ignored and
+// the offsets close to the time zone step are corrected by the step
+func (m *Mail) correct(camera string, ts, received time.Time) time.Time {
+	if received.IsZero() {
+		return ts
+	}
+
+	s := m.skews[camera]
+	if s == nil {
+		s = &skew{}
+		m.skews[camera] = s
+	}
+
+	s.samples = append(s.samples, ts.Sub(received))
+
+	if len(s.samples) > skewSamples {
+		s.samples = s.samples[len(s.samples)-skewSamples:]
+	}
+
+	if len(s.samples) < minSkewSamples {
+		return ts.Add(-s.offset)
+	}
+
+	// the least delayed alert is the closest to the clock error
+	offset := slices.Max(s.samples)
+
+	switch {
+	case abs(offset) <= m.opts.MaxSkew:
+		// the small lag is the delivery delay rather than the clock error
+		offset = 0
+	case abs(offset-offset.Round(zoneStep)) <= m.opts.MaxSkew:
+		offset = offset.Round(zoneStep)
+	}
+
+	if offset != s.offset {
+		slog.Warn("camera clock is off, the alert time is corrected", "camera", camera, "offset", offset)
+		s.offset = offset
+	}
+
+	return ts.Add(-offset)
+}
+
+// received returns the time the message was received by Gmail,
+// the Date header is taken when it's not known
+func received(msg *gmail.Message) time.Time {
+	if msg.InternalDate != 0 {
+		return time.UnixMilli(msg.InternalDate)
+	}
+
+	if msg.Payload == nil {
+		return time.Time{}
+	}
+
+	for _, h := range msg.Payload.Headers {
+		if h.Name != "Date" {
+			continue
+		}
+
+		if t, err := mail.ParseDate(h.Value); err == nil {
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+
+	return d
+}
@@ -0,0 +1,122 @@
+package mail
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// maxProcessed bounds the processed message IDs kept in the state
+const maxProcessed = 1000
+
+// state is the mailbox cursor persisted between restarts
+type state struct {
+	HistoryId uint64   `json:"history_id"`
+	Processed []string `json:"processed"`
+
+	path  string
+	index map[string]bool
+}
+
+// loadState reads the state file, the state is empty when the file
+// doesn't exist, the empty path disables persistence
+func loadState(path string) (s *state, err error) {
+	s = &state{
+		path:  path,
+		index: make(map[string]bool),
+	}
+
+	if path == "" {
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("unable to read mail state: %w", err)
+	}
+
+	if err = json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("invalid mail state %s: %w", path, err)
+	}
+
+	for _, id := range s.Processed {
+		s.index[id] = true
+	}
+
+	return
+}
+
+func (s *state) processed(id string) bool {
+	return s.index[id]
+}
+
+func (s *state) add(id string) {
+	if s.index[id] {
+		return
+	}
+
+	s.index[id] = true
+	s.Processed = append(s.Processed, id)
+
+	if n := len(s.Processed) - maxProcessed; n > 0 {
+		for _, old := range s.Processed[:n] {
+			delete(s.index, old)
+		}
+
+		s.Processed = append([]string{}, s.Processed[n:]...)
+	}
+}
+
+// save writes the state atomically
+func (s *state) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	if err = writeFile(s.path, data); err != nil {
+		return fmt.Errorf("unable to save mail state: %w", err)
+	}
+
+	return nil
+}
+
+// writeFile replaces the file atomically, the file is readable by
+// the owner only
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0600)
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+
+	return err
+}
@@ -0,0 +1,169 @@
+package mail
+
+import (
+	"camrec/trigger"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/gmail/v1"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// historySyncInterval fetches the history when notifications are lost
+	historySyncInterval = time.Minute
+
+	// watchRenewInterval renews the watch, it expires in 7 days
+	watchRenewInterval = 24 * time.Hour
+)
+
+// watch requests push notifications of the label changes
+func (m *Mail) watch() (err error) {
+	label := m.opts.Label
+	if label == "" {
+		label = "INBOX"
+	}
+
+	res, err := m.service.Users.Watch("me", &gmail.WatchRequest{
+		TopicName: m.opts.Topic,
+		LabelIds:  []string{label},
+	}).Do()
+	if err != nil {
+		return fmt.Errorf("unable to watch mailbox: %w", err)
+	}
+
+	slog.Debug("mailbox watch expires", "at", time.UnixMilli(res.Expiration))
+
+	return
+}
+
+func (m *Mail) startWatcher(ctx context.Context, mch chan trigger.Trigger) {
+	defer close(mch)
+
+	if m.opts.PushListen != "" {
+		go m.startPushReceiver(ctx)
+	}
+
+	if m.opts.Subscription != "" {
+		go m.startPuller(ctx)
+	}
+
+	sync := m.clock.NewTicker(historySyncInterval)
+	defer sync.Stop()
+
+	renew := m.clock.NewTicker(watchRenewInterval)
+	defer renew.Stop()
+
+	slog.Info("start mailbox watcher")
+
+	if err := m.start(mch); err != nil {
+		m.Done <- err
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case historyId := <-m.notify:
+			if historyId <= m.state.HistoryId {
+				continue
+			}
+
+		case <-sync.C():
+
+		case <-renew.C():
+			if err := m.watch(); err != nil {
+				slog.Warn("mailbox watch renewal failed", "err", err)
+			}
+
+			continue
+		}
+
+		if err := m.syncHistory(mch); err != nil {
+			m.Done <- err
+			return
+		}
+	}
+}
+
+// syncHistory fetches the messages added since the history cursor, the
+// sender and the subject of the query are matched locally, the messages
+// matching the query are checked when the history is expired
+func (m *Mail) syncHistory(mch chan trigger.Trigger) (err error) {
+	ids, historyId, err := m.listHistory()
+
+	var apiErr *googleapi.Error
+
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound {
+		slog.Warn("mailbox history is expired, check the messages of the query", "history", m.state.HistoryId, "query", m.opts.Query)
+		return m.syncMessages(mch)
+	}
+
+	if err != nil {
+		return
+	}
+
+	for _, id := range ids {
+		if err = m.process(id, mch); err != nil {
+			return
+		}
+	}
+
+	if historyId == m.state.HistoryId && len(ids) == 0 {
+		return
+	}
+
+	m.state.HistoryId = historyId
+
+	return m.state.save()
+}
+
+// listHistory returns IDs of the added messages and the latest history ID
+func (m *Mail) listHistory() (ids []string, historyId uint64, err error) {
+	seen := make(map[string]bool)
+	pageToken := ""
+
+	for {
+		call := m.service.Users.History.List("me").
+			StartHistoryId(m.state.HistoryId).
+			HistoryTypes("messageAdded")
+
+		if m.opts.Label != "" {
+			call = call.LabelId(m.opts.Label)
+		}
+
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+
+		res, err := call.Do()
+		if err != nil {
+			return nil, 0, fmt.Errorf("unable to fetch history: %w", err)
+		}
+
+		for _, h := range res.History {
+			for _, added := range h.MessagesAdded {
+				if added.Message == nil || seen[added.Message.Id] {
+					continue
+				}
+
+				seen[added.Message.Id] = true
+				ids = append(ids, added.Message.Id)
+			}
+		}
+
+		historyId = res.HistoryId
+
+		if res.NextPageToken == "" {
+			return ids, historyId, nil
+		}
+
+		pageToken = res.NextPageToken
+	}
+}
@@ -2,92 +2,187 @@ package main
 
 import (
 	"camrec/mail"
-	"camrec/stream"
-	"context"
-	"log"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log/slog"
 	"os"
-	"os/signal"
+	"strconv"
 	"time"
 
 	"github.com/joho/godotenv"
 )
 
-func init() {
-	if err := godotenv.Load(); err != nil {
-		log.Fatal(err)
-	}
+const (
+	bufferSize = 120 * time.Second
+	// triggerDelay is the minimal wait after the trigger is received,
+	// the event waits for the video after the trigger anyway
+	triggerDelay = 20 * time.Second
+	// defaultShutdownGrace limits the wait for the pending events on exit
+	defaultShutdownGrace = 30 * time.Second
+	// defaultCameraStale is the time without frames the camera is down after
+	defaultCameraStale = 30 * time.Second
+	// streamStopTimeout is longer than the time the streams have to exit
+	streamStopTimeout = 10 * time.Second
+)
 
-	url := os.Getenv("STREAM")
+// command is the subcommand of the binary
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
 
-	if url == "" {
-		log.Fatal("no stream URL")
+func commands() []command {
+	return []command{
+		{"run", "record the cameras and save the events of the alerts (default)", run},
+		{"auth", "authorize the Gmail account", auth},
+		{"check-config", "check the settings and the camera streams", checkConfig},
+		{"probe", "show the parameters of the stream URL or file", probe},
+		{"events", "list, export or delete the saved events", events},
+		{"replay", "save the events of the recorded stream", replay},
+		{"trigger", "save the event of the running recorder now", manualTrigger},
+		{"queue", "list the pending and the failed triggers", showQueue},
 	}
 }
 
 func main() {
-	ctx, cancel := context.WithCancel(context.Background())
+	flag.Usage = usage
 
-	sigchan := make(chan os.Signal, 1)
-	signal.Notify(sigchan, os.Interrupt)
+	configFile := flag.String("config", "", "settings file (default: .env if it exists)")
+	logLevel := flag.String("log-level", "info", "log level: debug, info, warn or error")
 
-	m, err := mail.Initialize()
-	if err != nil {
-		log.Printf("mail initialize failed: %s", err)
-		cancel()
-		return
+	flag.Parse()
+
+	if err := setupLogging(*logLevel); err != nil {
+		fail(err)
 	}
 
-	tschan := m.StartMessageChecker(ctx, 5*time.Second)
+	if err := loadConfig(*configFile); err != nil {
+		fail(err)
+	}
+
+	name, args := "run", flag.Args()
 
-	go func() {
-		p := stream.NewFfmpegStreamer(ctx, 120*time.Second)
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+
+	for _, c := range commands() {
+		if c.name != name {
+			continue
+		}
 
-		if err := p.Start(); err != nil {
-			log.Printf("streaming start failed: %s", err)
-			cancel()
-			return
+		if err := c.run(args); err != nil && !errors.Is(err, flag.ErrHelp) {
+			fail(err)
 		}
 
-		for {
-			select {
-			case <-ctx.Done():
-				return
+		return
+	}
 
-			case ts := <-tschan:
-				go func(ts time.Time) {
-					time.Sleep(20 * time.Second)
+	fmt.Fprintf(flag.CommandLine.Output(), "unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
 
-					log.Printf("handle timestamp: %s", ts.Format(time.RFC1123))
+func usage() {
+	w := flag.CommandLine.Output()
 
-					if err := p.HandleTimestamp(ts); err != nil {
-						log.Printf("> failed: %s", err)
-					}
-				}(ts)
+	fmt.Fprintf(w, "Usage: %s [flags] [command] [command flags]\n\nCommands:\n", os.Args[0])
 
-			case err := <-p.Done():
-				log.Printf("streaming end: %s", err)
-				cancel()
-				return
+	for _, c := range commands() {
+		fmt.Fprintf(w, "  %-14s %s\n", c.name, c.usage)
+	}
 
-			case err := <-m.Done:
-				log.Printf("message loop end: %s", err)
-				cancel()
-				return
-			}
-		}
-	}()
+	fmt.Fprintf(w, "\nFlags:\n")
+	flag.PrintDefaults()
+}
 
-	time.Sleep(time.Second)
+// fail logs the error and exits, the error is logged at any level
+func fail(err error) {
+	slog.Error(err.Error())
+	os.Exit(1)
+}
 
-	log.Printf("press ctrl+c to interrupt")
+// loadConfig loads the settings file into the environment, the default
+// file is optional, the variables already set are kept
+func loadConfig(path string) error {
+	if path != "" {
+		return godotenv.Load(path)
+	}
 
-	go func() {
-		sig := <-sigchan
-		log.Printf("signal: %s", sig)
-		cancel()
-	}()
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
 
-	<-ctx.Done()
+// setupLogging routes the log to the leveled logger, the plain log
+// output is kept for the info level
+func setupLogging(level string) error {
+	var l slog.Level
 
-	time.Sleep(time.Second)
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+
+	if l == slog.LevelInfo {
+		return nil
+	}
+
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: l})))
+
+	return nil
+}
+
+// envOr returns the environment variable or the default value if it's empty
+func envOr(key, value string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+
+	return value
+}
+
+// envDuration returns the duration of the environment variable
+// or the default value if it's empty
+func envDuration(key string, value time.Duration) (time.Duration, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return value, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", key, err)
+	}
+
+	return d, nil
+}
+
+// envBool returns true if the environment variable is a true value
+func envBool(key string) bool {
+	v, _ := strconv.ParseBool(os.Getenv(key))
+	return v
+}
+
+// mailOptions returns the mail settings of the environment
+func mailOptions() mail.Options {
+	return mail.Options{
+		Topic:        os.Getenv("GMAIL_TOPIC"),
+		PushListen:   os.Getenv("GMAIL_PUSH_LISTEN"),
+		PushToken:    os.Getenv("GMAIL_PUSH_TOKEN"),
+		Subscription: os.Getenv("GMAIL_SUBSCRIPTION"),
+		Query:        envOr("GMAIL_QUERY", mail.DefaultQuery),
+		Label:        os.Getenv("GMAIL_LABEL"),
+		StateFile:    envOr("GMAIL_STATE", "mail-state.json"),
+		// labeling requires the modify scope instead of the read-only one
+		Modify:         envBool("GMAIL_MODIFY"),
+		ProcessedLabel: envOr("GMAIL_PROCESSED_LABEL", mail.DefaultProcessedLabel),
+		FailedLabel:    envOr("GMAIL_FAILED_LABEL", mail.DefaultFailedLabel),
+		Archive:        envBool("GMAIL_ARCHIVE"),
+		MarkRead:       envBool("GMAIL_MARK_READ"),
+	}
 }
@@ -0,0 +1,90 @@
+package mp4
+
+import (
+	"camrec/codec"
+	"errors"
+)
+
+// NewAudioTrack builds AAC track from ADTS stream
+func NewAudioTrack(adts []byte) (t *Track, err error) {
+	frames, _ := codec.ParseADTS(adts)
+	if len(frames) == 0 {
+		return nil, errors.New("no AAC frames found")
+	}
+
+	first := frames[0]
+
+	if first.SampleRate() == 0 {
+		return nil, errors.New("invalid sample rate")
+	}
+
+	t = &Track{
+		Timescale: uint32(first.SampleRate()),
+		handler:   "soun",
+	}
+
+	for _, f := range frames {
+		t.Samples = append(t.Samples, Sample{
+			Data:     f.Payload,
+			Duration: codec.SamplesPerFrame,
+			Keyframe: true,
+		})
+	}
+
+	t.sampleEntry = box("mp4a",
+		zeros(6),
+		u16(1), // data reference index
+		zeros(8),
+		u16(uint16(first.Channels)),
+		u16(16), // sample size
+		zeros(4),
+		u32(uint32(first.SampleRate())<<16),
+		esds(first.AudioSpecificConfig()),
+	)
+
+	return
+}
+
+// esds builds elementary stream descriptor for AAC
+func esds(config []byte) []byte {
+	decoderSpecificInfo := descriptor(0x05, config)
+
+	decoderConfig := descriptor(0x04,
+		[]byte{
+			0x40,    // MPEG-4 audio
+			0x15,    // audio stream
+			0, 0, 0, // buffer size
+		},
+		u32(0), // max bitrate
+		u32(0), // average bitrate
+		decoderSpecificInfo,
+	)
+
+	slConfig := descriptor(0x06, []byte{0x02})
+
+	return fullBox("esds", 0, 0,
+		descriptor(0x03, u16(0), []byte{0}, decoderConfig, slConfig),
+	)
+}
+
+func descriptor(tag uint8, parts ...[]byte) []byte {
+	size := 0
+	for _, p := range parts {
+		size += len(p)
+	}
+
+	d := []byte{tag}
+
+	// size is encoded as 4 bytes of 7 bit values
+	for shift := 21; shift > 0; shift -= 7 {
+		d = append(d, 0x80|uint8(size>>shift))
+	}
+
+	d = append(d, uint8(size&0x7f))
+
+	for _, p := range parts {
+		d = append(d, p...)
+	}
+
+	return d
+}
@@ -0,0 +1,427 @@
+package mp4
+
+import (
+	"camrec/codec"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+const (
+	movieTimescale = 1000
+	videoTimescale = 90000
+)
+
+// Sample is a frame of the track, the NAL units of the video samples
+// are written with the length prefixes after the data, so they are not
+// copied until the write
+type Sample struct {
+	Data     []byte
+	NALUnits [][]byte
+	Duration uint32
+	Keyframe bool
+}
+
+// Size returns the size of the sample in the file
+func (s Sample) Size() (size int) {
+	size = len(s.Data)
+
+	for _, nal := range s.NALUnits {
+		size += 4 + len(nal)
+	}
+
+	return
+}
+
+// Bytes returns the sample as it's written to the file
+func (s Sample) Bytes() []byte {
+	data := append(make([]byte, 0, s.Size()), s.Data...)
+
+	for _, nal := range s.NALUnits {
+		data = binary.BigEndian.AppendUint32(data, uint32(len(nal)))
+		data = append(data, nal...)
+	}
+
+	return data
+}
+
+func (s Sample) write(w io.Writer) (err error) {
+	if len(s.Data) > 0 {
+		if _, err = w.Write(s.Data); err != nil {
+			return
+		}
+	}
+
+	for _, nal := range s.NALUnits {
+		if _, err = w.Write(u32(uint32(len(nal)))); err != nil {
+			return
+		}
+
+		if _, err = w.Write(nal); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+type Track struct {
+	Timescale uint32
+	Samples   []Sample
+
+	// Delay is the track start relative to the movie start
+	Delay time.Duration
+
+	handler     string
+	width       int
+	height      int
+	sampleEntry []byte
+}
+
+// NewVideoTrack builds H.264 or H.265 track from access units,
+// leading access units before the first keyframe are dropped,
+// frame rate is used for access units without duration
+func NewVideoTrack(c codec.Codec, units []codec.AccessUnit, frameRate float64, width, height int) (t *Track, err error) {
+	if frameRate <= 0 {
+		frameRate = 25
+	}
+
+	t = &Track{
+		Timescale: videoTimescale,
+		handler:   "vide",
+		width:     width,
+		height:    height,
+	}
+
+	duration := uint32(math.Round(videoTimescale / frameRate))
+
+	var vps, sps, pps [][]byte
+
+	for _, au := range units {
+		if len(t.Samples) == 0 && !au.Keyframe {
+			continue
+		}
+
+		var nals [][]byte
+
+		for _, nal := range au.NALUnits {
+			// parameter sets are stored in the sample entry
+			if codec.IsParameterSet(c, nal) {
+				switch codec.NALType(c, nal) {
+				case codec.H265NalVPS:
+					vps = appendOnce(vps, nal)
+				case codec.H264NalSPS, codec.H265NalSPS:
+					sps = appendOnce(sps, nal)
+				default:
+					pps = appendOnce(pps, nal)
+				}
+
+				continue
+			}
+
+			if codec.IsAUD(c, nal) {
+				continue
+			}
+
+			nals = append(nals, nal)
+		}
+
+		if len(nals) == 0 {
+			continue
+		}
+
+		sample := Sample{
+			NALUnits: nals,
+			Duration: duration,
+			Keyframe: au.Keyframe,
+		}
+
+		if au.Duration > 0 {
+			sample.Duration = uint32(au.Duration * videoTimescale / time.Second)
+		}
+
+		t.Samples = append(t.Samples, sample)
+	}
+
+	if len(t.Samples) == 0 {
+		return nil, errors.New("no keyframe found")
+	}
+
+	if len(sps) == 0 || len(pps) == 0 {
+		return nil, errors.New("no parameter sets found")
+	}
+
+	switch c {
+	case codec.H264:
+		if len(sps[0]) < 4 {
+			return nil, errors.New("invalid SPS")
+		}
+
+		if t.width == 0 || t.height == 0 {
+			if info, err := codec.ParseH264SPS(sps[0]); err == nil {
+				t.width, t.height = info.Width, info.Height
+			}
+		}
+
+		t.sampleEntry = visualSampleEntry("avc1", t.width, t.height, avcC(sps, pps))
+
+	case codec.H265:
+		if len(vps) == 0 {
+			return nil, errors.New("no VPS found")
+		}
+
+		info, err := codec.ParseHEVCSPS(sps[0])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse SPS: %w", err)
+		}
+
+		if t.width == 0 || t.height == 0 {
+			t.width, t.height = info.Width, info.Height
+		}
+
+		t.sampleEntry = visualSampleEntry("hvc1", t.width, t.height, hvcC(info, vps, sps, pps))
+
+	default:
+		return nil, fmt.Errorf("unsupported codec %q", c)
+	}
+
+	return
+}
+
+func appendOnce(list [][]byte, nal []byte) [][]byte {
+	for _, v := range list {
+		if string(v) == string(nal) {
+			return list
+		}
+	}
+
+	return append(list, nal)
+}
+
+// Write writes tracks as a progressive MP4 file with the index in front
+func Write(w io.Writer, tracks ...*Track) (err error) {
+	ftyp := box("ftyp", []byte("isom"), u32(0x200), []byte("isomiso2avc1mp41"))
+
+	dataSize := uint64(0)
+	for _, t := range tracks {
+		for _, s := range t.Samples {
+			dataSize += uint64(s.Size())
+		}
+	}
+
+	if dataSize > math.MaxUint32-8 {
+		return errors.New("media data is too large")
+	}
+
+	// moov size doesn't depend on the offsets, so build it once to measure
+	moov := buildMoov(tracks, 0)
+	moov = buildMoov(tracks, uint32(len(ftyp)+len(moov)+8))
+
+	for _, part := range [][]byte{ftyp, moov, u32(uint32(dataSize + 8)), []byte("mdat")} {
+		if _, err = w.Write(part); err != nil {
+			return
+		}
+	}
+
+	for _, t := range tracks {
+		for _, s := range t.Samples {
+			if err = s.write(w); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+func buildMoov(tracks []*Track, dataOffset uint32) []byte {
+	parts := make([][]byte, 0, len(tracks)+1)
+
+	movieDuration := uint64(0)
+	traks := make([][]byte, 0, len(tracks))
+
+	for i, t := range tracks {
+		duration := t.movieDuration()
+		if duration > movieDuration {
+			movieDuration = duration
+		}
+
+		traks = append(traks, t.trak(uint32(i+1), dataOffset))
+
+		for _, s := range t.Samples {
+			dataOffset += uint32(s.Size())
+		}
+	}
+
+	parts = append(parts, mvhd(uint32(movieDuration), uint32(len(tracks)+1)))
+	parts = append(parts, traks...)
+
+	return box("moov", parts...)
+}
+
+func (t *Track) duration() (d uint64) {
+	for _, s := range t.Samples {
+		d += uint64(s.Duration)
+	}
+
+	return
+}
+
+func (t *Track) movieDuration() uint64 {
+	return t.mediaDuration() + t.delay()
+}
+
+func (t *Track) mediaDuration() uint64 {
+	return t.duration() * movieTimescale / uint64(t.Timescale)
+}
+
+func (t *Track) delay() uint64 {
+	if t.Delay <= 0 {
+		return 0
+	}
+
+	return uint64(t.Delay / (time.Second / movieTimescale))
+}
+
+func mvhd(duration uint32, nextTrackID uint32) []byte {
+	return fullBox("mvhd", 0, 0,
+		u32(0), u32(0), // creation and modification time
+		u32(movieTimescale),
+		u32(duration),
+		u32(0x00010000), // rate
+		u16(0x0100),     // volume
+		zeros(10),
+		matrix,
+		zeros(24),
+		u32(nextTrackID),
+	)
+}
+
+func (t *Track) trak(id uint32, dataOffset uint32) []byte {
+	volume := uint16(0)
+	if t.handler == "soun" {
+		volume = 0x0100
+	}
+
+	tkhd := fullBox("tkhd", 0, 3,
+		u32(0), u32(0), // creation and modification time
+		u32(id),
+		zeros(4),
+		u32(uint32(t.movieDuration())),
+		zeros(8),
+		u16(0), u16(0), // layer and alternate group
+		u16(volume),
+		zeros(2),
+		matrix,
+		u32(uint32(t.width)<<16),
+		u32(uint32(t.height)<<16),
+	)
+
+	mdhd := fullBox("mdhd", 0, 0,
+		u32(0), u32(0),
+		u32(t.Timescale),
+		u32(uint32(t.duration())),
+		u16(0x55c4), // "und" language
+		u16(0),
+	)
+
+	name := "VideoHandler"
+	mediaHeader := fullBox("vmhd", 0, 1, zeros(8))
+
+	if t.handler == "soun" {
+		name = "SoundHandler"
+		mediaHeader = fullBox("smhd", 0, 0, zeros(4))
+	}
+
+	hdlr := fullBox("hdlr", 0, 0,
+		u32(0),
+		[]byte(t.handler),
+		zeros(12),
+		[]byte(name+"\x00"),
+	)
+
+	dinf := box("dinf", fullBox("dref", 0, 0, u32(1), fullBox("url ", 0, 1)))
+
+	minf := box("minf", mediaHeader, dinf, t.stbl(dataOffset))
+
+	mdia := box("mdia", mdhd, hdlr, minf)
+
+	if t.delay() == 0 {
+		return box("trak", tkhd, mdia)
+	}
+
+	// empty edit shifts the track presentation by the delay
+	elst := fullBox("elst", 0, 0,
+		u32(2),
+		u32(uint32(t.delay())), u32(0xffffffff), u32(0x00010000),
+		u32(uint32(t.mediaDuration())), u32(0), u32(0x00010000),
+	)
+
+	return box("trak", tkhd, box("edts", elst), mdia)
+}
+
+func (t *Track) stbl(dataOffset uint32) []byte {
+	stsd := fullBox("stsd", 0, 0, u32(1), t.sampleEntry)
+
+	// sample durations are run-length encoded
+	stts := make([]byte, 0)
+	entries := uint32(0)
+
+	for i := 0; i < len(t.Samples); {
+		j := i
+		for j < len(t.Samples) && t.Samples[j].Duration == t.Samples[i].Duration {
+			j++
+		}
+
+		stts = append(stts, u32(uint32(j-i))...)
+		stts = append(stts, u32(t.Samples[i].Duration)...)
+		entries++
+
+		i = j
+	}
+
+	sync := make([]byte, 0)
+	syncCount := uint32(0)
+	allSync := true
+
+	sizes := make([]byte, 0, 4*len(t.Samples))
+	offsets := make([]byte, 0, 4*len(t.Samples))
+
+	for i, s := range t.Samples {
+		if s.Keyframe {
+			sync = append(sync, u32(uint32(i+1))...)
+			syncCount++
+		} else {
+			allSync = false
+		}
+
+		sizes = append(sizes, u32(uint32(s.Size()))...)
+		offsets = append(offsets, u32(dataOffset)...)
+
+		dataOffset += uint32(s.Size())
+	}
+
+	count := u32(uint32(len(t.Samples)))
+
+	parts := [][]byte{
+		stsd,
+		fullBox("stts", 0, 0, u32(entries), stts),
+	}
+
+	// no stss box means that every sample is a sync sample
+	if !allSync {
+		parts = append(parts, fullBox("stss", 0, 0, u32(syncCount), sync))
+	}
+
+	parts = append(parts,
+		// every sample is stored in its own chunk
+		fullBox("stsc", 0, 0, u32(1), u32(1), u32(1), u32(1)),
+		fullBox("stsz", 0, 0, u32(0), count, sizes),
+		fullBox("stco", 0, 0, count, offsets),
+	)
+
+	return box("stbl", parts...)
+}
@@ -0,0 +1,229 @@
+package mp4_test
+
+import (
+	"bytes"
+	"camrec/codec"
+	"camrec/mp4"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	h264SPS   = []byte{0x67, 0x42, 0xc0, 0x1e, 0xd9}
+	h264PPS   = []byte{0x68, 0xce, 0x3c, 0x80}
+	h264IDR   = []byte{0x65, 0x88, 0x84, 0x21}
+	h264Slice = []byte{0x41, 0x9a, 0x02, 0x04}
+
+	hevcVPS = []byte{0x40, 0x01, 0x0c, 0x01, 0xff, 0xff}
+	hevcSPS = []byte{
+		0x42, 0x01, 0x01, 0x01, 0x60, 0x00, 0x00, 0x03, 0x00, 0x90, 0x00, 0x00,
+		0x03, 0x00, 0x00, 0x03, 0x00, 0x5d, 0xa0, 0x02, 0x80, 0x80, 0x2d, 0x16,
+		0x59, 0x59, 0xa4, 0x93, 0x2b, 0xc0, 0x5a, 0x70, 0x80,
+	}
+	hevcPPS   = []byte{0x44, 0x01, 0xc1, 0x72, 0xb4, 0x62, 0x40}
+	hevcIDR   = []byte{0x26, 0x01, 0xaf, 0x1d}
+	hevcTrail = []byte{0x02, 0x01, 0xd0, 0x2c}
+)
+
+// boxes returns top level boxes of data by type
+func boxes(t *testing.T, data []byte) map[string][]byte {
+	found := make(map[string][]byte)
+
+	for len(data) > 0 {
+		require.GreaterOrEqual(t, len(data), 8)
+
+		size := int(binary.BigEndian.Uint32(data))
+		require.LessOrEqual(t, size, len(data))
+
+		found[string(data[4:8])] = data[8:size]
+		data = data[size:]
+	}
+
+	return found
+}
+
+// path walks the container boxes
+func path(t *testing.T, data []byte, types ...string) []byte {
+	for _, typ := range types {
+		payload, ok := boxes(t, data)[typ]
+		require.True(t, ok, "box %s not found", typ)
+
+		data = payload
+	}
+
+	return data
+}
+
+func TestNewVideoTrack(t *testing.T) {
+	t.Run("no keyframe", func(t *testing.T) {
+		_, err := mp4.NewVideoTrack(codec.H264, []codec.AccessUnit{
+			{NALUnits: [][]byte{h264Slice}},
+		}, 25, 0, 0)
+
+		require.Error(t, err)
+	})
+
+	t.Run("no parameter sets", func(t *testing.T) {
+		_, err := mp4.NewVideoTrack(codec.H264, []codec.AccessUnit{
+			{NALUnits: [][]byte{h264IDR}, Keyframe: true},
+		}, 25, 0, 0)
+
+		require.Error(t, err)
+	})
+
+	t.Run("leading frames are dropped", func(t *testing.T) {
+		track, err := mp4.NewVideoTrack(codec.H264, []codec.AccessUnit{
+			{NALUnits: [][]byte{h264Slice}},
+			{NALUnits: [][]byte{h264SPS, h264PPS, h264IDR}, Keyframe: true},
+			{NALUnits: [][]byte{h264Slice}},
+		}, 25, 640, 480)
+
+		require.NoError(t, err)
+		require.Len(t, track.Samples, 2)
+		require.True(t, track.Samples[0].Keyframe)
+		require.Equal(t, uint32(3600), track.Samples[0].Duration)
+		require.Equal(t, append([]byte{0, 0, 0, 4}, h264IDR...), track.Samples[0].Bytes())
+	})
+}
+
+func TestWrite(t *testing.T) {
+	t.Run("h264", func(t *testing.T) {
+		track, err := mp4.NewVideoTrack(codec.H264, []codec.AccessUnit{
+			{NALUnits: [][]byte{h264SPS, h264PPS, h264IDR}, Keyframe: true},
+			{NALUnits: [][]byte{h264Slice}},
+			{NALUnits: [][]byte{h264Slice}},
+		}, 25, 640, 480)
+		require.NoError(t, err)
+
+		var out bytes.Buffer
+		require.NoError(t, mp4.Write(&out, track))
+
+		file := out.Bytes()
+		top := boxes(t, file)
+
+		require.Contains(t, top, "ftyp")
+		require.Contains(t, top, "moov")
+		require.Len(t, top["mdat"], 3*8)
+
+		stbl := path(t, top["moov"], "trak", "mdia", "minf", "stbl")
+
+		stsd := path(t, stbl, "stsd")
+		avc1 := path(t, stsd[8:], "avc1")
+		avcC := path(t, avc1[78:], "avcC")
+		require.Equal(t, []byte{1, 0x42, 0xc0, 0x1e}, avcC[:4])
+
+		stss := path(t, stbl, "stss")
+		require.Equal(t, []byte{0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1}, stss)
+
+		stco := path(t, stbl, "stco")
+		require.Equal(t, uint32(3), binary.BigEndian.Uint32(stco[4:]))
+
+		offset := binary.BigEndian.Uint32(stco[8:])
+		require.Equal(t, h264IDR, file[offset+4:offset+8])
+	})
+
+	t.Run("hevc", func(t *testing.T) {
+		units := codec.SplitAccessUnits(codec.H265, annexB(
+			hevcVPS, hevcSPS, hevcPPS, hevcIDR, hevcTrail, hevcTrail,
+		))
+		require.Len(t, units, 3)
+
+		track, err := mp4.NewVideoTrack(codec.H265, units, 30, 0, 0)
+		require.NoError(t, err)
+
+		var out bytes.Buffer
+		require.NoError(t, mp4.Write(&out, track))
+
+		moov := boxes(t, out.Bytes())["moov"]
+
+		tkhd := path(t, moov, "trak", "tkhd")
+		require.Equal(t, uint32(1280), binary.BigEndian.Uint32(tkhd[76:])>>16)
+		require.Equal(t, uint32(720), binary.BigEndian.Uint32(tkhd[80:])>>16)
+
+		stsd := path(t, moov, "trak", "mdia", "minf", "stbl", "stsd")
+		hvc1 := path(t, stsd[8:], "hvc1")
+		hvcC := path(t, hvc1[78:], "hvcC")
+
+		require.Equal(t, uint8(1), hvcC[0])
+		require.Equal(t, uint8(1), hvcC[1]&0x1f)
+		require.Equal(t, uint8(93), hvcC[12])
+		require.Equal(t, uint8(3), hvcC[22])
+		require.Equal(t, uint8(0x80|codec.H265NalVPS), hvcC[23])
+	})
+}
+
+func annexB(units ...[]byte) (data []byte) {
+	for _, nal := range units {
+		data = append(data, 0, 0, 0, 1)
+		data = append(data, nal...)
+	}
+
+	return
+}
+
+func adtsFrame(payload ...byte) []byte {
+	length := 7 + len(payload)
+
+	frame := []byte{
+		0xff, 0xf1, 0x50,
+		0x80 | uint8(length>>11),
+		uint8(length >> 3),
+		uint8(length&7)<<5 | 0x1f,
+		0xfc,
+	}
+
+	return append(frame, payload...)
+}
+
+func TestAudioTrack(t *testing.T) {
+	t.Run("no frames", func(t *testing.T) {
+		_, err := mp4.NewAudioTrack([]byte{1, 2, 3})
+		require.Error(t, err)
+	})
+
+	t.Run("with video", func(t *testing.T) {
+		video, err := mp4.NewVideoTrack(codec.H264, []codec.AccessUnit{
+			{NALUnits: [][]byte{h264SPS, h264PPS, h264IDR}, Keyframe: true},
+		}, 25, 640, 480)
+		require.NoError(t, err)
+
+		audio, err := mp4.NewAudioTrack(append(adtsFrame(1, 2), adtsFrame(3, 4)...))
+		require.NoError(t, err)
+		require.Len(t, audio.Samples, 2)
+		require.Equal(t, uint32(44100), audio.Timescale)
+
+		audio.Delay = 500 * time.Millisecond
+
+		var out bytes.Buffer
+		require.NoError(t, mp4.Write(&out, video, audio))
+
+		top := boxes(t, out.Bytes())
+		require.Equal(t, []byte{0, 0, 0, 4, 0x65, 0x88, 0x84, 0x21, 1, 2, 3, 4}, top["mdat"])
+
+		moov := top["moov"]
+
+		// the second trak box is the audio track
+		var traks [][]byte
+		for data := moov; len(data) > 0; {
+			size := binary.BigEndian.Uint32(data)
+			if string(data[4:8]) == "trak" {
+				traks = append(traks, data[8:size])
+			}
+			data = data[size:]
+		}
+		require.Len(t, traks, 2)
+
+		elst := path(t, traks[1], "edts", "elst")
+		require.Equal(t, uint32(2), binary.BigEndian.Uint32(elst[4:]))
+		require.Equal(t, uint32(500), binary.BigEndian.Uint32(elst[8:]))
+		require.Equal(t, uint32(0xffffffff), binary.BigEndian.Uint32(elst[12:]))
+
+		stsd := path(t, traks[1], "mdia", "minf", "stbl", "stsd")
+		mp4a := path(t, stsd[8:], "mp4a")
+		esds := path(t, mp4a[28:], "esds")
+		require.Contains(t, string(esds), string([]byte{0x05, 0x80, 0x80, 0x80, 0x02, 0x12, 0x10}))
+	})
+}
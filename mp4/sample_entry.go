@@ -0,0 +1,96 @@
+package mp4
+
+import "camrec/codec"
+
+func visualSampleEntry(typ string, width, height int, config []byte) []byte {
+	return box(typ,
+		zeros(6),
+		u16(1), // data reference index
+		zeros(16),
+		u16(uint16(width)),
+		u16(uint16(height)),
+		u32(0x00480000), // 72 dpi
+		u32(0x00480000),
+		zeros(4),
+		u16(1), // frame count
+		zeros(32),
+		u16(0x0018), // depth
+		u16(0xffff),
+		config,
+	)
+}
+
+// avcC builds AVCDecoderConfigurationRecord
+func avcC(sps, pps [][]byte) []byte {
+	record := []byte{
+		1,
+		sps[0][1], // profile
+		sps[0][2], // profile compatibility
+		sps[0][3], // level
+		0xff,      // 4 bytes NAL unit length
+		0xe0 | uint8(len(sps)),
+	}
+
+	for _, nal := range sps {
+		record = append(record, u16(uint16(len(nal)))...)
+		record = append(record, nal...)
+	}
+
+	record = append(record, uint8(len(pps)))
+
+	for _, nal := range pps {
+		record = append(record, u16(uint16(len(nal)))...)
+		record = append(record, nal...)
+	}
+
+	return box("avcC", record)
+}
+
+// hvcC builds HEVCDecoderConfigurationRecord
+func hvcC(info *codec.HEVCSPS, vps, sps, pps [][]byte) []byte {
+	nesting := uint8(0)
+	if info.TemporalIDNesting {
+		nesting = 1
+	}
+
+	record := []byte{
+		1,
+		info.ProfileSpace<<6 | info.TierFlag<<5 | info.ProfileIDC,
+	}
+
+	record = append(record, u32(info.ProfileCompatibility)...)
+	record = append(record, u64(info.ConstraintIndicator)[2:]...)
+	record = append(record,
+		info.LevelIDC,
+		0xf0, 0x00, // min_spatial_segmentation_idc
+		0xfc, // parallelismType
+		0xfc|info.ChromaFormatIDC,
+		0xf8|info.BitDepthLumaMinus8,
+		0xf8|info.BitDepthChromaMinus8,
+		0, 0, // avgFrameRate
+		(info.MaxSubLayersMinus1+1)<<3|nesting<<2|3,
+		3, // VPS, SPS and PPS arrays
+	)
+
+	arrays := []struct {
+		typ   uint8
+		units [][]byte
+	}{
+		{codec.H265NalVPS, vps},
+		{codec.H265NalSPS, sps},
+		{codec.H265NalPPS, pps},
+	}
+
+	for _, a := range arrays {
+		// array_completeness is set since parameter sets are not sent in-band
+		record = append(record, 0x80|a.typ)
+		record = append(record, u16(uint16(len(a.units)))...)
+
+		for _, nal := range a.units {
+			record = append(record, u16(uint16(len(nal)))...)
+			record = append(record, nal...)
+		}
+	}
+
+	return box("hvcC", record)
+}
@@ -0,0 +1,294 @@
+package mpegts
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+const (
+	PacketSize = 188
+	syncByte   = 0x47
+	patPID     = 0
+)
+
+// Elementary stream types
+const (
+	StreamTypeAAC  = 0x0f
+	StreamTypeH264 = 0x1b
+	StreamTypeH265 = 0x24
+)
+
+// NoTimestamp is used when PES has no PTS or DTS
+const NoTimestamp = -1
+
+// Packet is a complete PES packet payload
+type Packet struct {
+	PID        uint16
+	StreamType uint8
+	// PTS and DTS are in 90 kHz units
+	PTS  int64
+	DTS  int64
+	Data []byte
+}
+
+// Timestamp returns DTS if present, otherwise PTS
+func (p Packet) Timestamp() int64 {
+	if p.DTS != NoTimestamp {
+		return p.DTS
+	}
+
+	return p.PTS
+}
+
+type pes struct {
+	streamType uint8
+	data       []byte
+}
+
+type Demuxer struct {
+	r       *bufio.Reader
+	pmtPIDs map[uint16]bool
+	streams map[uint16]*pes
+	queue   []*Packet
+	eof     bool
+}
+
+func NewDemuxer(r io.Reader) *Demuxer {
+	return &Demuxer{
+		r:       bufio.NewReaderSize(r, 64*PacketSize),
+		pmtPIDs: make(map[uint16]bool),
+		streams: make(map[uint16]*pes),
+	}
+}
+
+// ReadPacket returns the next complete PES packet of known elementary stream,
+// the last packets are returned on the end of input
+func (d *Demuxer) ReadPacket() (pkt *Packet, err error) {
+	for len(d.queue) == 0 {
+		if d.eof {
+			return nil, io.EOF
+		}
+
+		if err = d.readTSPacket(); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				return nil, err
+			}
+
+			d.eof = true
+			d.flush()
+		}
+	}
+
+	pkt = d.queue[0]
+	d.queue = d.queue[1:]
+
+	return pkt, nil
+}
+
+func (d *Demuxer) flush() {
+	pids := make([]uint16, 0, len(d.streams))
+	for pid := range d.streams {
+		pids = append(pids, pid)
+	}
+
+	sort.Slice(pids, func(i, j int) bool { return pids[i] < pids[j] })
+
+	for _, pid := range pids {
+		d.emit(pid, d.streams[pid])
+	}
+}
+
+func (d *Demuxer) readTSPacket() (err error) {
+	// resync on the sync byte
+	for {
+		b, err := d.r.Peek(1)
+		if err != nil {
+			return err
+		}
+
+		if b[0] == syncByte {
+			break
+		}
+
+		d.r.Discard(1)
+	}
+
+	buf := make([]byte, PacketSize)
+	if _, err = io.ReadFull(d.r, buf); err != nil {
+		return
+	}
+
+	pusi := buf[1]&0x40 != 0
+	pid := uint16(buf[1]&0x1f)<<8 | uint16(buf[2])
+	afc := buf[3] >> 4 & 0x03
+
+	payload := buf[4:]
+
+	if afc&0x02 != 0 {
+		length := int(payload[0])
+		if length+1 > len(payload) {
+			return fmt.Errorf("invalid adaptation field length %d", length)
+		}
+
+		payload = payload[1+length:]
+	}
+
+	if afc&0x01 == 0 {
+		return nil
+	}
+
+	switch {
+	case pid == patPID:
+		if pusi {
+			d.parsePAT(payload)
+		}
+
+	case d.pmtPIDs[pid]:
+		if pusi {
+			d.parsePMT(payload)
+		}
+
+	default:
+		s, ok := d.streams[pid]
+		if !ok {
+			return nil
+		}
+
+		if pusi {
+			d.emit(pid, s)
+		}
+
+		// continuation without the start is skipped
+		if pusi || len(s.data) > 0 {
+			s.data = append(s.data, payload...)
+		}
+	}
+
+	return nil
+}
+
+// section returns PSI section payload without header and CRC
+func section(payload []byte, tableID uint8) []byte {
+	if len(payload) == 0 {
+		return nil
+	}
+
+	pointer := int(payload[0])
+	if 1+pointer+3 > len(payload) {
+		return nil
+	}
+
+	s := payload[1+pointer:]
+
+	if s[0] != tableID {
+		return nil
+	}
+
+	length := int(s[1]&0x0f)<<8 | int(s[2])
+	if 3+length > len(s) || length < 9 {
+		return nil
+	}
+
+	// skip table header (5 bytes) and strip CRC32
+	return s[8 : 3+length-4]
+}
+
+func (d *Demuxer) parsePAT(payload []byte) {
+	s := section(payload, 0x00)
+
+	for i := 0; i+4 <= len(s); i += 4 {
+		program := uint16(s[i])<<8 | uint16(s[i+1])
+		pid := uint16(s[i+2]&0x1f)<<8 | uint16(s[i+3])
+
+		// program 0 is the network PID
+		if program != 0 {
+			d.pmtPIDs[pid] = true
+		}
+	}
+}
+
+func (d *Demuxer) parsePMT(payload []byte) {
+	s := section(payload, 0x02)
+	if len(s) < 4 {
+		return
+	}
+
+	infoLength := int(s[2]&0x0f)<<8 | int(s[3])
+
+	for i := 4 + infoLength; i+5 <= len(s); {
+		streamType := s[i]
+		pid := uint16(s[i+1]&0x1f)<<8 | uint16(s[i+2])
+		esInfoLength := int(s[i+3]&0x0f)<<8 | int(s[i+4])
+
+		switch streamType {
+		case StreamTypeAAC, StreamTypeH264, StreamTypeH265:
+			if _, ok := d.streams[pid]; !ok {
+				d.streams[pid] = &pes{streamType: streamType}
+			}
+		}
+
+		i += 5 + esInfoLength
+	}
+}
+
+func (d *Demuxer) emit(pid uint16, s *pes) {
+	if len(s.data) == 0 {
+		return
+	}
+
+	pkt, err := parsePES(s.data)
+
+	s.data = nil
+
+	if err != nil {
+		return
+	}
+
+	pkt.PID = pid
+	pkt.StreamType = s.streamType
+
+	d.queue = append(d.queue, pkt)
+}
+
+func parsePES(data []byte) (pkt *Packet, err error) {
+	if len(data) < 9 || data[0] != 0 || data[1] != 0 || data[2] != 1 {
+		return nil, errors.New("invalid PES start code")
+	}
+
+	headerLength := int(data[8])
+	if 9+headerLength > len(data) {
+		return nil, errors.New("invalid PES header length")
+	}
+
+	pkt = &Packet{
+		PTS: NoTimestamp,
+		DTS: NoTimestamp,
+	}
+
+	flags := data[7] >> 6
+	header := data[9 : 9+headerLength]
+
+	if flags&0x02 != 0 && len(header) >= 5 {
+		pkt.PTS = parseTimestamp(header)
+	}
+
+	if flags == 0x03 && len(header) >= 10 {
+		pkt.DTS = parseTimestamp(header[5:])
+	}
+
+	// PES packet length is ignored since it is zero for video
+	pkt.Data = data[9+headerLength:]
+
+	return
+}
+
+func parseTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 |
+		int64(b[1])<<22 |
+		int64(b[2]>>1)<<15 |
+		int64(b[3])<<7 |
+		int64(b[4]>>1)
+}
@@ -0,0 +1,68 @@
+package mpegts_test
+
+import (
+	"bytes"
+	"camrec/mpegts"
+	"camrec/mpegts/mpegtstest"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func stream(t *testing.T) []byte {
+	t.Helper()
+
+	var data bytes.Buffer
+
+	w := mpegtstest.NewWriter(&data)
+
+	require.NoError(t, w.WriteVideo(90000, bytes.Repeat([]byte{1}, 500)))
+	require.NoError(t, w.WriteAudio(90100, []byte{2, 2}))
+	require.NoError(t, w.WriteVideo(93600, []byte{3, 3, 3}))
+
+	return data.Bytes()
+}
+
+func TestDemuxer(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		d := mpegts.NewDemuxer(bytes.NewReader(nil))
+
+		_, err := d.ReadPacket()
+		require.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("packets", func(t *testing.T) {
+		// garbage before the first packet is skipped
+		data := append([]byte{1, 2, 3}, stream(t)...)
+
+		d := mpegts.NewDemuxer(bytes.NewReader(data))
+
+		packets := make(map[uint16][]*mpegts.Packet)
+
+		for {
+			pkt, err := d.ReadPacket()
+			if err == io.EOF {
+				break
+			}
+
+			require.NoError(t, err)
+			packets[pkt.PID] = append(packets[pkt.PID], pkt)
+		}
+
+		video := packets[mpegtstest.VideoPID]
+		require.Len(t, video, 2)
+		require.Equal(t, uint8(mpegts.StreamTypeH264), video[0].StreamType)
+		require.Equal(t, int64(90000), video[0].PTS)
+		require.Equal(t, int64(90000), video[0].Timestamp())
+		require.Equal(t, bytes.Repeat([]byte{1}, 500), video[0].Data)
+		require.Equal(t, []byte{3, 3, 3}, video[1].Data)
+		require.Equal(t, int64(mpegts.NoTimestamp), video[1].DTS)
+
+		audio := packets[mpegtstest.AudioPID]
+		require.Len(t, audio, 1)
+		require.Equal(t, uint8(mpegts.StreamTypeAAC), audio[0].StreamType)
+		require.Equal(t, []byte{2, 2}, audio[0].Data)
+		require.Equal(t, int64(90100), audio[0].PTS)
+	})
+}
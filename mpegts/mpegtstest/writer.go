@@ -0,0 +1,120 @@
+// Package mpegtstest provides minimal MPEG-TS muxer for tests
+package mpegtstest
+
+import (
+	"bytes"
+	"io"
+)
+
+const (
+	PMTPID   = 0x1000
+	VideoPID = 0x100
+	AudioPID = 0x101
+)
+
+// Writer writes the single program stream with H.264 video and AAC audio,
+// PAT and PMT are written once before the first PES packet
+type Writer struct {
+	w       io.Writer
+	started bool
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WriteVideo writes the access unit with the presentation timestamp
+func (w *Writer) WriteVideo(pts int64, data []byte) error {
+	return w.write(VideoPID, PES(0xe0, pts, data))
+}
+
+// WriteAudio writes ADTS frames with the presentation timestamp
+func (w *Writer) WriteAudio(pts int64, data []byte) error {
+	return w.write(AudioPID, PES(0xc0, pts, data))
+}
+
+func (w *Writer) write(pid uint16, pes []byte) (err error) {
+	if !w.started {
+		w.started = true
+
+		if _, err = w.w.Write(Tables()); err != nil {
+			return
+		}
+	}
+
+	_, err = w.w.Write(Packets(pid, pes))
+
+	return
+}
+
+// Tables returns PAT and PMT packets
+func Tables() (data []byte) {
+	data = append(data, Packets(0, Section(0x00, []byte{0x00, 0x01, 0xe0 | PMTPID>>8, PMTPID & 0xff}))...)
+	data = append(data, Packets(PMTPID, Section(0x02, []byte{
+		0xe1, 0x00, 0xf0, 0x00,
+		0x1b, 0xe0 | VideoPID>>8, VideoPID & 0xff, 0xf0, 0x00,
+		0x0f, 0xe0 | AudioPID>>8, AudioPID & 0xff, 0xf0, 0x00,
+	}))...)
+
+	return
+}
+
+// Packets splits payload into TS packets, the last one is stuffed
+func Packets(pid uint16, payload []byte) (data []byte) {
+	for first := true; first || len(payload) > 0; first = false {
+		header := []byte{0x47, uint8(pid >> 8), uint8(pid), 0x10}
+		if first {
+			header[1] |= 0x40
+		}
+
+		n := min(len(payload), 184)
+		pkt := append([]byte{}, header...)
+
+		if n < 184 {
+			// adaptation field with stuffing
+			pkt[3] = 0x30
+			stuffing := 184 - n - 1
+			pkt = append(pkt, uint8(stuffing))
+
+			if stuffing > 0 {
+				pkt = append(pkt, 0x00)
+				pkt = append(pkt, bytes.Repeat([]byte{0xff}, stuffing-1)...)
+			}
+		}
+
+		pkt = append(pkt, payload[:n]...)
+		payload = payload[n:]
+
+		data = append(data, pkt...)
+	}
+
+	return
+}
+
+// Section returns PSI section, CRC is not calculated
+func Section(tableID uint8, body []byte) []byte {
+	length := 5 + len(body) + 4
+
+	s := []byte{0x00, tableID, 0xb0 | uint8(length>>8), uint8(length), 0x00, 0x01, 0xc1, 0x00, 0x00}
+	s = append(s, body...)
+
+	return append(s, 0, 0, 0, 0)
+}
+
+// PES returns PES packet with PTS
+func PES(streamID uint8, pts int64, data []byte) []byte {
+	header := []byte{0, 0, 1, streamID, 0, 0, 0x80, 0x80, 5}
+	header = append(header, timestamp(2, pts)...)
+
+	return append(header, data...)
+}
+
+func timestamp(prefix uint8, ts int64) []byte {
+	return []byte{
+		prefix<<4 | uint8(ts>>29)&0x0e | 1,
+		uint8(ts >> 22),
+		uint8(ts>>14) | 1,
+		uint8(ts >> 7),
+		uint8(ts<<1) | 1,
+	}
+}
@@ -0,0 +1,64 @@
+package mpegts
+
+import "time"
+
+const (
+	// ClockRate is the PTS and DTS frequency
+	ClockRate = 90000
+
+	// timestamps are 33 bit values
+	wrapPeriod = int64(1) << 33
+)
+
+// Timeline maps 90 kHz timestamps to wall-clock time
+type Timeline struct {
+	// MaxDrift re-anchors the timeline when the mapped time differs from
+	// the wall-clock time more than this value, zero disables re-anchoring
+	MaxDrift time.Duration
+
+	anchor time.Time
+	first  int64
+	last   int64
+	offset int64
+	set    bool
+}
+
+// Time returns wall-clock time of the timestamp received at now
+func (t *Timeline) Time(ts int64, now time.Time) time.Time {
+	if !t.set {
+		t.reset(ts, now)
+	}
+
+	// timestamp wrapped around
+	if ts+t.offset < t.last-wrapPeriod/2 {
+		t.offset += wrapPeriod
+	}
+
+	ts += t.offset
+	t.last = ts
+
+	mapped := t.anchor.Add(Duration(ts - t.first))
+
+	if t.MaxDrift > 0 {
+		drift := mapped.Sub(now)
+
+		if drift > t.MaxDrift || drift < -t.MaxDrift {
+			t.reset(ts, now)
+			return now
+		}
+	}
+
+	return mapped
+}
+
+func (t *Timeline) reset(ts int64, now time.Time) {
+	t.anchor = now
+	t.first = ts
+	t.last = ts
+	t.set = true
+}
+
+// Duration converts 90 kHz ticks to duration
+func Duration(ticks int64) time.Duration {
+	return time.Duration(ticks) * time.Second / ClockRate
+}
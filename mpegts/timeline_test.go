@@ -0,0 +1,45 @@
+package mpegts_test
+
+import (
+	"camrec/mpegts"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeline(t *testing.T) {
+	now := time.Now()
+
+	t.Run("mapping", func(t *testing.T) {
+		tl := mpegts.Timeline{}
+
+		require.Equal(t, now, tl.Time(90000, now))
+		require.Equal(t, now.Add(time.Second), tl.Time(180000, now))
+		require.Equal(t, now.Add(-time.Second), tl.Time(0, now))
+	})
+
+	t.Run("wrap around", func(t *testing.T) {
+		tl := mpegts.Timeline{}
+
+		max := int64(1)<<33 - 90000
+
+		require.Equal(t, now, tl.Time(max, now))
+		require.Equal(t, now.Add(2*time.Second), tl.Time(90000, now))
+	})
+
+	t.Run("drift", func(t *testing.T) {
+		tl := mpegts.Timeline{MaxDrift: 5 * time.Second}
+
+		require.Equal(t, now, tl.Time(0, now))
+		require.Equal(t, now.Add(time.Second), tl.Time(90000, now))
+
+		later := now.Add(time.Minute)
+		require.Equal(t, later, tl.Time(180000, later))
+		require.Equal(t, later.Add(time.Second), tl.Time(270000, later))
+	})
+}
+
+func TestDuration(t *testing.T) {
+	require.Equal(t, 40*time.Millisecond, mpegts.Duration(3600))
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"camrec/api"
+	"camrec/config"
+	"camrec/event"
+	"camrec/mail"
+	"camrec/notify"
+	"camrec/queue"
+	"camrec/recorder"
+	"camrec/stream"
+	"camrec/trigger"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// notifyTimeout limits the thumbnail decoding and the sending of the event
+const notifyTimeout = 2 * time.Minute
+
+// acknowledgeTimeout limits the labeling of the trigger message
+const acknowledgeTimeout = 30 * time.Second
+
+// smtpOptions returns the email settings of the environment, ok is false
+// when the SMTP server isn't set
+func smtpOptions() (opts notify.SMTPOptions, ok bool, err error) {
+	opts = notify.SMTPOptions{
+		Addr:     os.Getenv("SMTP_ADDR"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+		StartTLS: os.Getenv("SMTP_STARTTLS"),
+		BaseURL:  os.Getenv("API_URL"),
+	}
+
+	if opts.Addr == "" {
+		return opts, false, nil
+	}
+
+	for _, to := range strings.Split(os.Getenv("SMTP_TO"), ",") {
+		if to = strings.TrimSpace(to); to != "" {
+			opts.To = append(opts.To, to)
+		}
+	}
+
+	if v := os.Getenv("SMTP_MAX_ATTACHMENT"); v != "" {
+		if opts.MaxAttachment, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return opts, false, fmt.Errorf("SMTP_MAX_ATTACHMENT: %w", err)
+		}
+	}
+
+	return opts, true, nil
+}
+
+// telegramOptions returns the bot settings of the environment, ok is false
+// when the bot token isn't set
+func telegramOptions() (opts notify.TelegramOptions, ok bool, err error) {
+	opts = notify.TelegramOptions{
+		Token:    os.Getenv("TELEGRAM_TOKEN"),
+		BaseURL:  os.Getenv("TELEGRAM_API_URL"),
+		EventURL: os.Getenv("API_URL"),
+	}
+
+	if opts.Token == "" {
+		return opts, false, nil
+	}
+
+	if opts.ChatID, err = strconv.ParseInt(os.Getenv("TELEGRAM_CHAT_ID"), 10, 64); err != nil {
+		return opts, false, fmt.Errorf("TELEGRAM_CHAT_ID: %w", err)
+	}
+
+	if v := os.Getenv("TELEGRAM_MAX_VIDEO"); v != "" {
+		if opts.MaxVideo, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return opts, false, fmt.Errorf("TELEGRAM_MAX_VIDEO: %w", err)
+		}
+	}
+
+	return opts, true, nil
+}
+
+// webhookOptions returns the webhooks of the environment, WEBHOOKS lists
+// webhook IDs configured with <ID>_URL, <ID>_METHOD, <ID>_EVENTS,
+// <ID>_BODY or <ID>_BODY_FILE, <ID>_HEADERS like "Name: value; Other: value",
+// <ID>_SECRET, <ID>_ATTEMPTS and <ID>_RATE_LIMIT
+func webhookOptions() (list []notify.WebhookOptions, err error) {
+	for _, id := range strings.FieldsFunc(os.Getenv("WEBHOOKS"), isSeparator) {
+		opts := notify.WebhookOptions{
+			Name:   id,
+			URL:    config.Get(id, "URL"),
+			Method: config.Get(id, "METHOD"),
+			Kinds:  strings.FieldsFunc(config.Get(id, "EVENTS"), isSeparator),
+			Body:   config.Get(id, "BODY"),
+			Secret: config.Get(id, "SECRET"),
+		}
+
+		if file := config.Get(id, "BODY_FILE"); file != "" {
+			body, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("webhook %s: %w", id, err)
+			}
+
+			opts.Body = string(body)
+		}
+
+		if opts.Headers, err = parseHeaders(config.Get(id, "HEADERS")); err != nil {
+			return nil, fmt.Errorf("webhook %s: %w", id, err)
+		}
+
+		if v := config.Get(id, "ATTEMPTS"); v != "" {
+			opts.Backoff = notify.DefaultWebhookBackoff
+
+			if opts.Backoff.Attempts, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("webhook %s: attempts: %w", id, err)
+			}
+		}
+
+		if v := config.Get(id, "RATE_LIMIT"); v != "" {
+			if opts.RateLimit, err = time.ParseDuration(v); err != nil {
+				return nil, fmt.Errorf("webhook %s: rate limit: %w", id, err)
+			}
+		}
+
+		list = append(list, opts)
+	}
+
+	return
+}
+
+func isSeparator(r rune) bool {
+	return r == ',' || r == ' '
+}
+
+// parseHeaders parses the headers like "Name: value; Other: value"
+func parseHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+
+	for _, h := range strings.Split(s, ";") {
+		if strings.TrimSpace(h) == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(h, ":")
+		if !ok || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid header %q", strings.TrimSpace(h))
+		}
+
+		headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	return headers, nil
+}
+
+// notifiers returns the configured notifiers of the settings, the bot
+// is nil when Telegram isn't configured
+func notifiers(s settings) (list []notify.Notifier, bot *notify.Telegram, err error) {
+	if s.smtp != nil {
+		n, err := notify.NewSMTP(*s.smtp)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		list = append(list, n)
+	}
+
+	if s.telegram != nil {
+		if bot, err = notify.NewTelegram(*s.telegram); err != nil {
+			return nil, nil, err
+		}
+
+		list = append(list, bot)
+	}
+
+	return
+}
+
+// webhooks returns the configured webhooks of the settings
+func webhooks(s settings) (list []*notify.Webhook, err error) {
+	for _, opts := range s.webhooks {
+		w, err := notify.NewWebhook(opts)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: %w", opts.Name, err)
+		}
+
+		list = append(list, w)
+	}
+
+	return
+}
+
+// notifications sends the saved events and the hooks in the background
+type notifications struct {
+	notifier  notify.Notifier
+	webhooks  []*notify.Webhook
+	snapshots *snapshots
+	// mail labels the trigger messages
+	mail *mail.Mail
+	wg   sync.WaitGroup
+}
+
+// acknowledge labels the message of the handled trigger in the background,
+// so the Gmail requests don't hold the trigger loop
+func (n *notifications) acknowledge(t trigger.Trigger, err error) {
+	n.wg.Add(1)
+
+	go func() {
+		defer n.wg.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), acknowledgeTimeout)
+		defer cancel()
+
+		n.mail.Acknowledge(ctx, t, err)
+	}()
+}
+
+// hook sends the hook to the webhooks
+func (n *notifications) hook(h notify.Hook) {
+	if h.Time.IsZero() {
+		h.Time = time.Now()
+	}
+
+	for _, w := range n.webhooks {
+		n.wg.Add(1)
+
+		go func(w *notify.Webhook) {
+			defer n.wg.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+			defer cancel()
+
+			if err := w.Send(ctx, h); err != nil {
+				slog.Warn("webhook failed", "webhook", w.Name(), "kind", h.Kind, "err", err)
+			}
+		}(w)
+	}
+}
+
+// triggered sends the hook of the received trigger
+func (n *notifications) triggered(t trigger.Trigger) {
+	n.hook(notify.Hook{Kind: notify.TriggerReceived, Time: t.Time, Camera: t.Camera, Trigger: &t})
+}
+
+// failed sends the hook of the trigger without saved events
+func (n *notifications) failed(t trigger.Trigger, err error) {
+	n.hook(notify.Hook{Kind: notify.EventFailed, Time: t.Time, Camera: t.Camera, Trigger: &t, Error: err.Error()})
+}
+
+// camera sends the hook of the stalled or recovered camera
+func (n *notifications) camera(camera string, down bool, st stream.Status) {
+	kind := notify.CameraRecovered
+	if down {
+		kind = notify.CameraDown
+	}
+
+	h := notify.Hook{Kind: kind, Camera: camera}
+	if !st.Last.IsZero() {
+		h.LastFrame = &st.Last
+	}
+
+	n.hook(h)
+}
+
+// send saves the snapshot of the event and sends the event of the trigger
+// with the snapshot as the thumbnail
+func (n *notifications) send(t trigger.Trigger, e *event.Event) {
+	n.wg.Add(1)
+
+	go func() {
+		defer n.wg.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		defer cancel()
+
+		ev := notify.Event{
+			ID:      e.ID(),
+			Camera:  e.Camera(),
+			Trigger: t,
+			File:    e.File(),
+			Size:    int64(e.Size()),
+		}
+
+		if st, err := os.Stat(ev.File); err == nil {
+			ev.Size = st.Size()
+		}
+
+		ev.Thumbnail = n.snapshots.save(ctx, t, e)
+
+		if err := n.notifier.Notify(ctx, ev); err != nil {
+			slog.Warn("notification failed", "camera", ev.Camera, "event", ev.ID, "err", err)
+		}
+	}()
+}
+
+// Wait waits for the notifications and the acknowledgements in progress
+func (n *notifications) Wait() {
+	n.wg.Wait()
+}
+
+// botCommands runs the bot commands on the running recorder
+type botCommands struct {
+	rec       *recorder.Recorder
+	server    *api.Server
+	queue     *queue.Queue
+	snapshots *snapshots
+	cameras   []config.Camera
+}
+
+func (b botCommands) Snapshot(ctx context.Context, camera string) ([]byte, error) {
+	img, err := b.snapshots.Latest(ctx, camera)
+	return img.Data, err
+}
+
+func (b botCommands) Last(ctx context.Context) (notify.Event, error) {
+	events, err := event.List("")
+	if err != nil {
+		return notify.Event{}, err
+	}
+
+	if len(events) == 0 {
+		return notify.Event{}, errors.New("no events are saved")
+	}
+
+	last := events[len(events)-1]
+
+	e := notify.Event{
+		ID:      last.ID,
+		Camera:  last.Camera,
+		Trigger: trigger.Trigger{Time: last.Time, Note: last.Note()},
+		File:    last.File,
+		Size:    last.Size,
+	}
+
+	if last.Snapshot != "" {
+		if e.Thumbnail, err = os.ReadFile(last.Snapshot); err != nil {
+			return e, err
+		}
+	}
+
+	return e, nil
+}
+
+func (b botCommands) Trigger(ctx context.Context, camera string) error {
+	_, err := b.server.Trigger(ctx, api.TriggerRequest{Camera: camera})
+	return err
+}
+
+func (b botCommands) Status(ctx context.Context) (string, error) {
+	var lines []string
+
+	status := b.rec.Status()
+	now := time.Now()
+
+	for _, c := range b.cameras {
+		st := status[c.ID]
+
+		if st.Last.IsZero() {
+			lines = append(lines, c.Name()+": no video")
+			continue
+		}
+
+		lines = append(lines, fmt.Sprintf("%s: %s buffered, the last frame %s ago",
+			c.Name(), st.Buffered.Round(time.Second), now.Sub(st.Last).Round(time.Second)))
+	}
+
+	lines = append(lines, fmt.Sprintf("%d triggers are pending, %d failed", len(b.queue.Pending()), len(b.queue.Dead())))
+
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,53 @@
+// Package notify sends the saved events to the people and the systems
+// outside of the recorder
+package notify
+
+import (
+	"camrec/trigger"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Event is the saved event of the camera
+type Event struct {
+	// ID is the event ID of the events API, like front/2024-03-01_10-00-00
+	ID      string          `json:"id"`
+	Camera  string          `json:"camera,omitempty"`
+	Trigger trigger.Trigger `json:"-"`
+	File    string          `json:"file"`
+	Size    int64           `json:"size"`
+	// Thumbnail is the JPEG image of the keyframe near the trigger time,
+	// it's nil when the frame isn't decoded
+	Thumbnail []byte `json:"-"`
+}
+
+// Time returns the time of the event
+func (e Event) Time() time.Time {
+	return e.Trigger.Time
+}
+
+// Notifier sends the event
+type Notifier interface {
+	Notify(ctx context.Context, e Event) error
+}
+
+// All sends the event by all notifiers, the errors are joined
+func All(notifiers ...Notifier) Notifier {
+	return all(notifiers)
+}
+
+type all []Notifier
+
+func (a all) Notify(ctx context.Context, e Event) error {
+	var errs []error
+
+	for _, n := range a {
+		if err := n.Notify(ctx, e); err != nil {
+			errs = append(errs, fmt.Errorf("%T: %w", n, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
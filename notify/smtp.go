@@ -0,0 +1,331 @@
+package notify
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// StartTLS modes of the SMTP connection
+const (
+	// StartTLSAuto upgrades the connection when the server offers it
+	StartTLSAuto = "auto"
+	// StartTLSAlways fails when the server doesn't offer the upgrade
+	StartTLSAlways = "always"
+	StartTLSNever  = "never"
+)
+
+// DefaultMaxAttachment is the size of the largest clip attached to the email
+const DefaultMaxAttachment = 10 << 20
+
+// SMTPOptions are the SMTP server and the email settings
+type SMTPOptions struct {
+	// Addr is the host:port of the server
+	Addr     string
+	Username string
+	Password string
+	From     string
+	To       []string
+	// StartTLS is the StartTLS mode, auto by default
+	StartTLS string
+	// TLSConfig is the config of the upgraded connection, the server
+	// name is taken from the address when it's nil
+	TLSConfig *tls.Config
+	// MaxAttachment is the size of the largest attached clip, the larger
+	// clips are linked, a negative value disables the attachments
+	MaxAttachment int64
+	// BaseURL is the URL of the HTTP API the clips are linked to,
+	// the event ID is written when it's empty
+	BaseURL string
+}
+
+// SMTP emails the event with the thumbnail and the clip
+type SMTP struct {
+	opts SMTPOptions
+	host string
+}
+
+func NewSMTP(opts SMTPOptions) (*SMTP, error) {
+	host, _, err := net.SplitHostPort(opts.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP address: %w", err)
+	}
+
+	if opts.From == "" || len(opts.To) == 0 {
+		return nil, errors.New("SMTP sender and recipients are required")
+	}
+
+	switch opts.StartTLS {
+	case "":
+		opts.StartTLS = StartTLSAuto
+	case StartTLSAuto, StartTLSAlways, StartTLSNever:
+	default:
+		return nil, fmt.Errorf("invalid StartTLS mode %q", opts.StartTLS)
+	}
+
+	if opts.MaxAttachment == 0 {
+		opts.MaxAttachment = DefaultMaxAttachment
+	}
+
+	return &SMTP{opts: opts, host: host}, nil
+}
+
+// Notify sends the email, the clip is read from the event file
+// during the send
+func (s *SMTP) Notify(ctx context.Context, e Event) (err error) {
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "tcp", s.opts.Addr)
+	if err != nil {
+		return
+	}
+
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, s.host)
+	if err != nil {
+		return
+	}
+
+	defer c.Close()
+
+	if err = s.startTLS(c); err != nil {
+		return
+	}
+
+	if s.opts.Username != "" {
+		if err = c.Auth(smtp.PlainAuth("", s.opts.Username, s.opts.Password, s.host)); err != nil {
+			return fmt.Errorf("SMTP auth failed: %w", err)
+		}
+	}
+
+	if err = c.Mail(s.opts.From); err != nil {
+		return
+	}
+
+	for _, to := range s.opts.To {
+		if err = c.Rcpt(to); err != nil {
+			return
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return
+	}
+
+	if err = s.write(w, e); err != nil {
+		w.Close()
+		return
+	}
+
+	if err = w.Close(); err != nil {
+		return
+	}
+
+	return c.Quit()
+}
+
+func (s *SMTP) startTLS(c *smtp.Client) error {
+	if s.opts.StartTLS == StartTLSNever {
+		return nil
+	}
+
+	if ok, _ := c.Extension("STARTTLS"); !ok {
+		if s.opts.StartTLS == StartTLSAlways {
+			return errors.New("SMTP server doesn't support STARTTLS")
+		}
+
+		return nil
+	}
+
+	config := s.opts.TLSConfig
+	if config == nil {
+		config = &tls.Config{ServerName: s.host}
+	}
+
+	return c.StartTLS(config)
+}
+
+// write writes the email with the thumbnail and the clip
+func (s *SMTP) write(w io.Writer, e Event) (err error) {
+	bw := bufio.NewWriter(w)
+	mw := multipart.NewWriter(bw)
+
+	subject := fmt.Sprintf("%s: %s", cameraName(e.Camera), e.Time().Format("2006-01-02 15:04:05"))
+	if e.Trigger.Event != "" {
+		subject = e.Trigger.Event + " on " + subject
+	}
+
+	headers := []string{
+		"From: " + s.opts.From,
+		"To: " + strings.Join(s.opts.To, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: multipart/mixed; boundary=" + mw.Boundary(),
+	}
+
+	if _, err = bw.WriteString(strings.Join(headers, "\r\n") + "\r\n\r\n"); err != nil {
+		return
+	}
+
+	attach := s.opts.MaxAttachment >= 0 && e.Size <= s.opts.MaxAttachment
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"quoted-printable"},
+	})
+	if err != nil {
+		return
+	}
+
+	if err = s.writeText(part, e, attach); err != nil {
+		return
+	}
+
+	if e.Thumbnail != nil {
+		err = writeAttachment(mw, "image/jpeg", "inline", "snapshot.jpg", bytes.NewReader(e.Thumbnail))
+		if err != nil {
+			return
+		}
+	}
+
+	if attach {
+		f, err := os.Open(e.File)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		if err = writeAttachment(mw, "video/mp4", "attachment", filepath.Base(e.File), f); err != nil {
+			return err
+		}
+	}
+
+	if err = mw.Close(); err != nil {
+		return
+	}
+
+	return bw.Flush()
+}
+
+func (s *SMTP) writeText(w io.Writer, e Event, attach bool) error {
+	qw := quotedprintable.NewWriter(w)
+
+	fmt.Fprintf(qw, "Camera: %s\r\n", cameraName(e.Camera))
+	fmt.Fprintf(qw, "Time: %s\r\n", e.Time().Format(time.RFC1123))
+
+	if e.Trigger.Event != "" {
+		fmt.Fprintf(qw, "Event: %s\r\n", e.Trigger.Event)
+	}
+
+	if e.Trigger.Note != "" {
+		fmt.Fprintf(qw, "Note: %s\r\n", e.Trigger.Note)
+	}
+
+	size := float64(e.Size) / (1 << 20)
+
+	switch {
+	case attach:
+		fmt.Fprintf(qw, "\r\nThe clip is attached (%.1f MB).\r\n", size)
+	case s.opts.BaseURL != "":
+		fmt.Fprintf(qw, "\r\nThe clip is too large to attach (%.1f MB): %s\r\n", size, EventURL(s.opts.BaseURL, e.ID))
+	default:
+		fmt.Fprintf(qw, "\r\nThe clip is too large to attach (%.1f MB), event %s.\r\n", size, e.ID)
+	}
+
+	return qw.Close()
+}
+
+// EventURL returns the URL of the event clip on the HTTP API
+func EventURL(base, id string) string {
+	return strings.TrimSuffix(base, "/") + "/events/" + id
+}
+
+// writeAttachment writes the base64 encoded part
+func writeAttachment(mw *multipart.Writer, contentType, disposition, name string, r io.Reader) error {
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {mime.FormatMediaType(contentType, map[string]string{"name": name})},
+		"Content-Disposition":       {mime.FormatMediaType(disposition, map[string]string{"filename": name})},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return err
+	}
+
+	lw := &lineWriter{w: part}
+	enc := base64.NewEncoder(base64.StdEncoding, lw)
+
+	if _, err = io.Copy(enc, r); err != nil {
+		return err
+	}
+
+	if err = enc.Close(); err != nil {
+		return err
+	}
+
+	_, err = part.Write([]byte("\r\n"))
+
+	return err
+}
+
+// lineWriter breaks the base64 text into the lines of 76 characters
+type lineWriter struct {
+	w      io.Writer
+	column int
+}
+
+func (l *lineWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if l.column == 76 {
+			if _, err = l.w.Write([]byte("\r\n")); err != nil {
+				return
+			}
+
+			l.column = 0
+		}
+
+		chunk := p[:min(len(p), 76-l.column)]
+
+		written, err := l.w.Write(chunk)
+		n += written
+		l.column += written
+
+		if err != nil {
+			return n, err
+		}
+
+		p = p[len(chunk):]
+	}
+
+	return
+}
+
+func cameraName(id string) string {
+	if id == "" {
+		return "default"
+	}
+
+	return id
+}
@@ -0,0 +1,304 @@
+package notify_test
+
+import (
+	"bufio"
+	"camrec/notify"
+	"camrec/trigger"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http/httptest"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSMTP is the in-process SMTP server of a single message
+type fakeSMTP struct {
+	addr string
+	// tls is the config of STARTTLS, it's not offered when nil
+	tls *tls.Config
+
+	lock     sync.Mutex
+	upgraded bool
+	auth     string
+	from     string
+	to       []string
+	data     string
+}
+
+func newFakeSMTP(t *testing.T, config *tls.Config) *fakeSMTP {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	t.Cleanup(func() { ln.Close() })
+
+	s := &fakeSMTP{addr: ln.Addr().String(), tls: config}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := textprotoReader(conn)
+	reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+	reply("220 fake ESMTP")
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		line = strings.TrimRight(line, "\r\n")
+		verb, arg, _ := strings.Cut(line, " ")
+
+		s.lock.Lock()
+
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			reply("250-fake")
+
+			if s.tls != nil && !s.upgraded {
+				reply("250-STARTTLS")
+			}
+
+			reply("250 AUTH PLAIN")
+
+		case "STARTTLS":
+			reply("220 ready")
+
+			tlsConn := tls.Server(conn, s.tls)
+			if tlsConn.Handshake() != nil {
+				s.lock.Unlock()
+				return
+			}
+
+			conn = tlsConn
+			r = textprotoReader(conn)
+			s.upgraded = true
+
+		case "AUTH":
+			creds, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(arg, "PLAIN "))
+			s.auth = string(creds)
+			reply("235 accepted")
+
+		case "MAIL":
+			s.from = arg
+			reply("250 ok")
+
+		case "RCPT":
+			s.to = append(s.to, arg)
+			reply("250 ok")
+
+		case "DATA":
+			reply("354 go ahead")
+
+			var data strings.Builder
+
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil || line == ".\r\n" {
+					break
+				}
+
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+
+			s.data = data.String()
+			reply("250 queued")
+
+		case "QUIT":
+			reply("221 bye")
+			s.lock.Unlock()
+
+			return
+
+		default:
+			reply("502 unknown")
+		}
+
+		s.lock.Unlock()
+	}
+}
+
+func textprotoReader(conn net.Conn) *bufio.Reader {
+	return bufio.NewReader(conn)
+}
+
+// message parses the received message into the headers and the parts
+func (s *fakeSMTP) message(t *testing.T) (*mail.Message, map[string][]byte) {
+	t.Helper()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	msg, err := mail.ReadMessage(strings.NewReader(s.data))
+	require.NoError(t, err)
+
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	require.NoError(t, err)
+
+	parts := make(map[string][]byte)
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+
+		require.NoError(t, err)
+
+		var r io.Reader = p
+		if p.Header.Get("Content-Transfer-Encoding") == "base64" {
+			r = base64.NewDecoder(base64.StdEncoding, p)
+		}
+
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+
+		contentType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		parts[contentType] = data
+	}
+
+	return msg, parts
+}
+
+// testEvent returns the event of the clip file
+func testEvent(t *testing.T, clip []byte) notify.Event {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "2024-03-01_10-00-00.mp4")
+	require.NoError(t, os.WriteFile(file, clip, 0644))
+
+	return notify.Event{
+		ID:     "front/2024-03-01_10-00-00",
+		Camera: "front",
+		Trigger: trigger.Trigger{
+			Time:  time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC),
+			Event: "motion",
+			Note:  "parcel at the door",
+		},
+		File:      file,
+		Size:      int64(len(clip)),
+		Thumbnail: []byte("\xff\xd8jpeg"),
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	// the certificate of the test server is valid for 127.0.0.1
+	tlsServer := httptest.NewTLSServer(nil)
+	defer tlsServer.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(tlsServer.Certificate())
+
+	t.Run("clip attached", func(t *testing.T) {
+		server := newFakeSMTP(t, tlsServer.TLS)
+
+		n, err := notify.NewSMTP(notify.SMTPOptions{
+			Addr:      server.addr,
+			Username:  "camrec",
+			Password:  "secret",
+			From:      "camrec@example.com",
+			To:        []string{"alice@example.com", "bob@example.com"},
+			StartTLS:  notify.StartTLSAlways,
+			TLSConfig: &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"},
+		})
+		require.NoError(t, err)
+
+		clip := []byte(strings.Repeat("video", 100))
+
+		require.NoError(t, n.Notify(context.Background(), testEvent(t, clip)))
+
+		require.True(t, server.upgraded)
+		require.Equal(t, "\x00camrec\x00secret", server.auth)
+		require.Equal(t, "FROM:<camrec@example.com>", server.from)
+		require.Len(t, server.to, 2)
+
+		msg, parts := server.message(t)
+
+		subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+		require.NoError(t, err)
+		require.Equal(t, "motion on front: 2024-03-01 10:00:00", subject)
+
+		require.Contains(t, string(parts["text/plain"]), "Note: parcel at the door")
+		require.Contains(t, string(parts["text/plain"]), "The clip is attached")
+		require.Equal(t, []byte("\xff\xd8jpeg"), parts["image/jpeg"])
+		require.Equal(t, clip, parts["video/mp4"])
+	})
+
+	t.Run("clip linked", func(t *testing.T) {
+		server := newFakeSMTP(t, nil)
+
+		n, err := notify.NewSMTP(notify.SMTPOptions{
+			Addr:          server.addr,
+			From:          "camrec@example.com",
+			To:            []string{"alice@example.com"},
+			MaxAttachment: 10,
+			BaseURL:       "https://camrec.example.com/",
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, n.Notify(context.Background(), testEvent(t, []byte(strings.Repeat("video", 100)))))
+
+		require.False(t, server.upgraded)
+		require.Empty(t, server.auth)
+
+		_, parts := server.message(t)
+
+		require.Contains(t, string(parts["text/plain"]), "https://camrec.example.com/events/front/2024-03-01_10-00-00")
+		require.NotContains(t, parts, "video/mp4")
+		require.Contains(t, parts, "image/jpeg")
+	})
+
+	t.Run("STARTTLS required", func(t *testing.T) {
+		server := newFakeSMTP(t, nil)
+
+		n, err := notify.NewSMTP(notify.SMTPOptions{
+			Addr:     server.addr,
+			From:     "camrec@example.com",
+			To:       []string{"alice@example.com"},
+			StartTLS: notify.StartTLSAlways,
+		})
+		require.NoError(t, err)
+
+		err = n.Notify(context.Background(), testEvent(t, []byte("video")))
+		require.ErrorContains(t, err, "STARTTLS")
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := notify.NewSMTP(notify.SMTPOptions{Addr: "smtp.example.com"})
+		require.Error(t, err)
+
+		_, err = notify.NewSMTP(notify.SMTPOptions{Addr: "smtp.example.com:587"})
+		require.Error(t, err)
+	})
+}
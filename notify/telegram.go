@@ -0,0 +1,378 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTelegramURL is the base URL of the Bot API
+const DefaultTelegramURL = "https://api.telegram.org"
+
+// DefaultMaxVideo is the size of the largest clip the bots may upload
+const DefaultMaxVideo = 50 << 20
+
+const (
+	// pollTimeout is the long polling timeout of the updates
+	pollTimeout = 30 * time.Second
+	// pollRetry is the delay of the polling after the failure
+	pollRetry = 5 * time.Second
+)
+
+// TelegramOptions are the bot settings
+type TelegramOptions struct {
+	Token string
+	// ChatID is the chat of the notifications, the commands of the other
+	// chats are ignored
+	ChatID int64
+	// BaseURL is the Bot API URL, DefaultTelegramURL by default
+	BaseURL string
+	// MaxVideo is the size of the largest uploaded clip, the larger clips
+	// are linked, a negative value disables the uploads
+	MaxVideo int64
+	// EventURL is the URL of the HTTP API the clips are linked to,
+	// the event ID is written when it's empty
+	EventURL string
+	// Client is the HTTP client of the Bot API, the default one is used
+	// when it's nil
+	Client *http.Client
+}
+
+// Commands are the bot commands of the running recorder
+type Commands interface {
+	// Snapshot returns the JPEG image of the latest frame of the camera
+	Snapshot(ctx context.Context, camera string) ([]byte, error)
+	// Last returns the last saved event
+	Last(ctx context.Context) (Event, error)
+	// Trigger saves the event of the camera at the current time
+	Trigger(ctx context.Context, camera string) error
+	// Status describes the state of the recorder
+	Status(ctx context.Context) (string, error)
+}
+
+// Telegram posts the events to the chat and answers the bot commands
+type Telegram struct {
+	opts   TelegramOptions
+	client *http.Client
+}
+
+func NewTelegram(opts TelegramOptions) (*Telegram, error) {
+	if opts.Token == "" || opts.ChatID == 0 {
+		return nil, errors.New("Telegram token and chat ID are required")
+	}
+
+	if opts.BaseURL == "" {
+		opts.BaseURL = DefaultTelegramURL
+	}
+
+	if opts.MaxVideo == 0 {
+		opts.MaxVideo = DefaultMaxVideo
+	}
+
+	t := &Telegram{opts: opts, client: opts.Client}
+
+	if t.client == nil {
+		// the long polling outlasts the default transport timeouts
+		t.client = &http.Client{}
+	}
+
+	return t, nil
+}
+
+// Notify posts the thumbnail with the event caption and the clip
+func (t *Telegram) Notify(ctx context.Context, e Event) error {
+	return t.send(ctx, t.opts.ChatID, e)
+}
+
+func (t *Telegram) send(ctx context.Context, chat int64, e Event) error {
+	upload := t.opts.MaxVideo >= 0 && e.File != "" && e.Size <= t.opts.MaxVideo
+	caption := t.caption(e, upload)
+
+	var err error
+
+	if e.Thumbnail != nil {
+		err = t.sendFile(ctx, "sendPhoto", chat, caption, "photo", "snapshot.jpg", bytes.NewReader(e.Thumbnail))
+	} else {
+		err = t.sendMessage(ctx, chat, caption)
+	}
+
+	if err != nil || !upload {
+		return err
+	}
+
+	f, err := os.Open(e.File)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	return t.sendFile(ctx, "sendVideo", chat, "", "video", filepath.Base(e.File), f)
+}
+
+func (t *Telegram) caption(e Event, upload bool) string {
+	var b strings.Builder
+
+	if e.Trigger.Event != "" {
+		fmt.Fprintf(&b, "%s on ", e.Trigger.Event)
+	}
+
+	fmt.Fprintf(&b, "%s: %s", cameraName(e.Camera), e.Time().Format("2006-01-02 15:04:05"))
+
+	if e.Trigger.Note != "" {
+		fmt.Fprintf(&b, "\n%s", e.Trigger.Note)
+	}
+
+	size := float64(e.Size) / (1 << 20)
+
+	switch {
+	case upload:
+	case t.opts.EventURL != "":
+		fmt.Fprintf(&b, "\nThe clip is too large to upload (%.1f MB): %s", size, EventURL(t.opts.EventURL, e.ID))
+	default:
+		fmt.Fprintf(&b, "\nThe clip is too large to upload (%.1f MB), event %s.", size, e.ID)
+	}
+
+	return b.String()
+}
+
+// Serve answers the bot commands of the chat until the context is done
+func (t *Telegram) Serve(ctx context.Context, commands Commands) error {
+	offset := int64(0)
+
+	for {
+		updates, err := t.updates(ctx, offset)
+
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err != nil {
+			slog.Warn("Telegram updates failed", "err", err)
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(pollRetry):
+			}
+
+			continue
+		}
+
+		for _, u := range updates {
+			offset = u.ID + 1
+
+			if u.Message == nil || u.Message.Chat.ID != t.opts.ChatID {
+				continue
+			}
+
+			if err := t.handle(ctx, commands, u.Message.Text); err != nil {
+				slog.Warn("Telegram command failed", "command", u.Message.Text, "err", err)
+			}
+		}
+	}
+}
+
+// handle runs the command of the message and replies to the chat,
+// the command errors are replied too
+func (t *Telegram) handle(ctx context.Context, commands Commands, text string) error {
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "/") {
+		return nil
+	}
+
+	// the commands of the group chats have the bot name suffix
+	name, _, _ := strings.Cut(fields[0], "@")
+	camera := ""
+
+	if len(fields) > 1 {
+		camera = fields[1]
+	}
+
+	chat := t.opts.ChatID
+	err := errUnknownCommand
+
+	switch name {
+	case "/snapshot":
+		var image []byte
+
+		if image, err = commands.Snapshot(ctx, camera); err == nil {
+			return t.sendFile(ctx, "sendPhoto", chat, cameraName(camera), "photo", "snapshot.jpg", bytes.NewReader(image))
+		}
+
+	case "/last":
+		var e Event
+
+		if e, err = commands.Last(ctx); err == nil {
+			return t.send(ctx, chat, e)
+		}
+
+	case "/trigger":
+		if err = commands.Trigger(ctx, camera); err == nil {
+			return t.sendMessage(ctx, chat, "triggered "+cameraName(camera))
+		}
+
+	case "/status":
+		var status string
+
+		if status, err = commands.Status(ctx); err == nil {
+			return t.sendMessage(ctx, chat, status)
+		}
+
+	case "/start", "/help":
+		return t.sendMessage(ctx, chat, help)
+	}
+
+	if replyErr := t.sendMessage(ctx, chat, err.Error()); replyErr != nil {
+		return replyErr
+	}
+
+	return err
+}
+
+var errUnknownCommand = errors.New("unknown command, see /help")
+
+const help = `/snapshot [camera] - the latest frame of the camera
+/last - the last saved event
+/trigger [camera] - save the event now
+/status - the recorder state`
+
+// update is the Bot API update of the message
+type update struct {
+	ID      int64 `json:"update_id"`
+	Message *struct {
+		Chat struct {
+			ID int64 `json:"id"`
+		} `json:"chat"`
+		Text string `json:"text"`
+	} `json:"message"`
+}
+
+func (t *Telegram) updates(ctx context.Context, offset int64) (updates []update, err error) {
+	form := url.Values{
+		"offset":          {strconv.FormatInt(offset, 10)},
+		"timeout":         {strconv.Itoa(int(pollTimeout.Seconds()))},
+		"allowed_updates": {`["message"]`},
+	}
+
+	err = t.call(ctx, "getUpdates", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()), &updates)
+
+	return
+}
+
+func (t *Telegram) sendMessage(ctx context.Context, chat int64, text string) error {
+	form := url.Values{
+		"chat_id": {strconv.FormatInt(chat, 10)},
+		"text":    {text},
+	}
+
+	return t.call(ctx, "sendMessage", "application/x-www-form-urlencoded", strings.NewReader(form.Encode()), nil)
+}
+
+// sendFile uploads the file of the field, the file is streamed
+// into the request body
+func (t *Telegram) sendFile(ctx context.Context, method string, chat int64, caption, field, name string, r io.Reader) error {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+
+	go func() {
+		pw.CloseWithError(writeForm(mw, chat, caption, field, name, r))
+	}()
+
+	err := t.call(ctx, method, mw.FormDataContentType(), pr, nil)
+
+	// the writer is unblocked when the request ends early
+	pr.CloseWithError(io.ErrClosedPipe)
+
+	return err
+}
+
+func writeForm(mw *multipart.Writer, chat int64, caption, field, name string, r io.Reader) error {
+	if err := mw.WriteField("chat_id", strconv.FormatInt(chat, 10)); err != nil {
+		return err
+	}
+
+	if caption != "" {
+		if err := mw.WriteField("caption", caption); err != nil {
+			return err
+		}
+	}
+
+	if field == "video" {
+		if err := mw.WriteField("supports_streaming", "true"); err != nil {
+			return err
+		}
+	}
+
+	part, err := mw.CreateFormFile(field, name)
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(part, r); err != nil {
+		return err
+	}
+
+	return mw.Close()
+}
+
+// response is the Bot API response
+type response struct {
+	OK          bool            `json:"ok"`
+	Description string          `json:"description"`
+	Result      json.RawMessage `json:"result"`
+}
+
+// call calls the Bot API method, the result is decoded into out
+// unless it's nil
+func (t *Telegram) call(ctx context.Context, method, contentType string, body io.Reader, out any) error {
+	u := strings.TrimSuffix(t.opts.BaseURL, "/") + "/bot" + t.opts.Token + "/" + method
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		// the URL error contains the token
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+
+		return fmt.Errorf("%s: %w", method, err)
+	}
+
+	defer resp.Body.Close()
+
+	var result response
+
+	if err = json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("%s: invalid response (%s): %w", method, resp.Status, err)
+	}
+
+	if !result.OK {
+		return fmt.Errorf("%s: %s", method, result.Description)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.Unmarshal(result.Result, out)
+}
@@ -0,0 +1,271 @@
+package notify_test
+
+import (
+	"camrec/notify"
+	"camrec/trigger"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const token = "123:secret"
+
+// sent is the Bot API request of the fake server
+type sent struct {
+	method  string
+	fields  map[string]string
+	file    string
+	content []byte
+}
+
+// fakeBotAPI is the Bot API server with the queued updates
+type fakeBotAPI struct {
+	*httptest.Server
+
+	lock    sync.Mutex
+	updates []map[string]any
+	sent    []sent
+}
+
+func newFakeBotAPI(t *testing.T) *fakeBotAPI {
+	t.Helper()
+
+	b := &fakeBotAPI{}
+	b.Server = httptest.NewServer(http.HandlerFunc(b.handle))
+
+	t.Cleanup(b.Close)
+
+	return b
+}
+
+func (b *fakeBotAPI) handle(w http.ResponseWriter, r *http.Request) {
+	method, ok := strings.CutPrefix(r.URL.Path, "/bot"+token+"/")
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]any{"ok": false, "description": "Unauthorized"})
+
+		return
+	}
+
+	s := sent{method: method, fields: make(map[string]string)}
+
+	if mr, err := r.MultipartReader(); err == nil {
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+
+			data, _ := io.ReadAll(p)
+
+			if p.FileName() != "" {
+				s.file, s.content = p.FormName(), data
+			} else {
+				s.fields[p.FormName()] = string(data)
+			}
+		}
+	} else {
+		r.ParseForm()
+
+		for key := range r.PostForm {
+			s.fields[key] = r.PostForm.Get(key)
+		}
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	result := any(true)
+
+	if method == "getUpdates" {
+		// the updates are sent once, the polling waits for the next ones
+		result, b.updates = b.updates, nil
+
+		if result == nil {
+			time.Sleep(10 * time.Millisecond)
+			result = []any{}
+		}
+	} else {
+		b.sent = append(b.sent, s)
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{"ok": true, "result": result})
+}
+
+// message queues the message update of the chat
+func (b *fakeBotAPI) message(chat int64, text string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.updates = append(b.updates, map[string]any{
+		"update_id": len(b.updates) + 1,
+		"message": map[string]any{
+			"chat": map[string]any{"id": chat},
+			"text": text,
+		},
+	})
+}
+
+func (b *fakeBotAPI) requests() []sent {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return append([]sent(nil), b.sent...)
+}
+
+func TestTelegram(t *testing.T) {
+	t.Run("clip uploaded", func(t *testing.T) {
+		api := newFakeBotAPI(t)
+
+		n, err := notify.NewTelegram(notify.TelegramOptions{Token: token, ChatID: 42, BaseURL: api.URL})
+		require.NoError(t, err)
+
+		clip := []byte(strings.Repeat("video", 100))
+
+		require.NoError(t, n.Notify(context.Background(), testEvent(t, clip)))
+
+		requests := api.requests()
+		require.Len(t, requests, 2)
+
+		require.Equal(t, "sendPhoto", requests[0].method)
+		require.Equal(t, "42", requests[0].fields["chat_id"])
+		require.Equal(t, "motion on front: 2024-03-01 10:00:00\nparcel at the door", requests[0].fields["caption"])
+		require.Equal(t, "photo", requests[0].file)
+		require.Equal(t, []byte("\xff\xd8jpeg"), requests[0].content)
+
+		require.Equal(t, "sendVideo", requests[1].method)
+		require.Equal(t, "video", requests[1].file)
+		require.Equal(t, clip, requests[1].content)
+	})
+
+	t.Run("clip linked", func(t *testing.T) {
+		api := newFakeBotAPI(t)
+
+		n, err := notify.NewTelegram(notify.TelegramOptions{
+			Token:    token,
+			ChatID:   42,
+			BaseURL:  api.URL,
+			MaxVideo: 10,
+			EventURL: "https://camrec.example.com",
+		})
+		require.NoError(t, err)
+
+		e := testEvent(t, []byte(strings.Repeat("video", 100)))
+		e.Thumbnail = nil
+
+		require.NoError(t, n.Notify(context.Background(), e))
+
+		requests := api.requests()
+		require.Len(t, requests, 1)
+		require.Equal(t, "sendMessage", requests[0].method)
+		require.Contains(t, requests[0].fields["text"], "https://camrec.example.com/events/front/2024-03-01_10-00-00")
+	})
+
+	t.Run("API error", func(t *testing.T) {
+		api := newFakeBotAPI(t)
+
+		n, err := notify.NewTelegram(notify.TelegramOptions{Token: "321:wrong", ChatID: 42, BaseURL: api.URL})
+		require.NoError(t, err)
+
+		err = n.Notify(context.Background(), testEvent(t, []byte("video")))
+		require.ErrorContains(t, err, "Unauthorized")
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := notify.NewTelegram(notify.TelegramOptions{Token: token})
+		require.Error(t, err)
+	})
+}
+
+// fakeCommands records the bot commands
+type fakeCommands struct {
+	lock      sync.Mutex
+	triggered []string
+	last      notify.Event
+}
+
+func (c *fakeCommands) Snapshot(ctx context.Context, camera string) ([]byte, error) {
+	if camera != "front" {
+		return nil, fmt.Errorf("unknown camera %q", camera)
+	}
+
+	return []byte("\xff\xd8front"), nil
+}
+
+func (c *fakeCommands) Last(ctx context.Context) (notify.Event, error) {
+	return c.last, nil
+}
+
+func (c *fakeCommands) Trigger(ctx context.Context, camera string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.triggered = append(c.triggered, camera)
+
+	return nil
+}
+
+func (c *fakeCommands) Status(ctx context.Context) (string, error) {
+	return "", errors.New("recorder is starting")
+}
+
+func TestTelegramCommands(t *testing.T) {
+	api := newFakeBotAPI(t)
+
+	n, err := notify.NewTelegram(notify.TelegramOptions{Token: token, ChatID: 42, BaseURL: api.URL})
+	require.NoError(t, err)
+
+	commands := &fakeCommands{last: testEvent(t, []byte("video"))}
+	commands.last.Trigger = trigger.Trigger{Time: commands.last.Time()}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- n.Serve(ctx, commands)
+	}()
+
+	api.message(42, "/snapshot front")
+	api.message(42, "/trigger@camrec_bot back")
+	api.message(7, "/trigger front")
+	api.message(42, "/last")
+	api.message(42, "/status")
+	api.message(42, "/snapshot garage")
+	api.message(42, "hello")
+	api.message(42, "/unknown")
+
+	require.Eventually(t, func() bool {
+		return len(api.requests()) == 7
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+
+	requests := api.requests()
+
+	require.Equal(t, "sendPhoto", requests[0].method)
+	require.Equal(t, []byte("\xff\xd8front"), requests[0].content)
+
+	require.Equal(t, "sendMessage", requests[1].method)
+	require.Equal(t, "triggered back", requests[1].fields["text"])
+	require.Equal(t, []string{"back"}, commands.triggered)
+
+	require.Equal(t, "sendPhoto", requests[2].method)
+	require.Equal(t, "front: 2024-03-01 10:00:00", requests[2].fields["caption"])
+	require.Equal(t, "sendVideo", requests[3].method)
+	require.Equal(t, []byte("video"), requests[3].content)
+
+	require.Equal(t, "recorder is starting", requests[4].fields["text"])
+	require.Equal(t, `unknown camera "garage"`, requests[5].fields["text"])
+	require.Equal(t, "unknown command, see /help", requests[6].fields["text"])
+}
@@ -0,0 +1,286 @@
+package notify
+
+import (
+	"bytes"
+	"camrec/clock"
+	"camrec/queue"
+	"camrec/trigger"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Hook kinds of the recorder lifecycle
+const (
+	TriggerReceived = "trigger.received"
+	EventSaved      = "event.saved"
+	EventFailed     = "event.failed"
+	CameraDown      = "camera.down"
+	CameraRecovered = "camera.recovered"
+)
+
+// Kinds are all hook kinds
+var Kinds = []string{TriggerReceived, EventSaved, EventFailed, CameraDown, CameraRecovered}
+
+const (
+	// SignatureHeader is the hex HMAC-SHA256 of the body with the sha256= prefix
+	SignatureHeader = "X-Camrec-Signature"
+	// KindHeader is the hook kind of the request
+	KindHeader = "X-Camrec-Event"
+)
+
+// DefaultWebhookBody is the JSON of the hook
+const DefaultWebhookBody = "{{json .}}"
+
+// DefaultWebhookBackoff retries the request for about 15 seconds
+var DefaultWebhookBackoff = queue.Backoff{
+	Initial:  time.Second,
+	Max:      10 * time.Second,
+	Attempts: 5,
+}
+
+// Hook is the recorder lifecycle point, it's the data of the body template
+type Hook struct {
+	Kind string    `json:"kind"`
+	Time time.Time `json:"time"`
+	// Camera is empty for the triggers of all cameras
+	Camera  string           `json:"camera,omitempty"`
+	Trigger *trigger.Trigger `json:"trigger,omitempty"`
+	// Event is the saved event
+	Event *Event `json:"event,omitempty"`
+	// Error is the failure of the event
+	Error string `json:"error,omitempty"`
+	// LastFrame is the time of the last buffered frame of the camera,
+	// it's nil when the camera has no frames
+	LastFrame *time.Time `json:"last_frame,omitempty"`
+}
+
+// WebhookOptions are the target and the request settings
+type WebhookOptions struct {
+	// Name identifies the target in the log
+	Name string
+	URL  string
+	// Method is POST by default
+	Method string
+	// Kinds are the hook kinds sent to the target, all by default
+	Kinds []string
+	// Body is the text/template of the body, the json function encodes
+	// the value, DefaultWebhookBody by default
+	Body    string
+	Headers map[string]string
+	// Secret signs the body, see SignatureHeader
+	Secret string
+	// Backoff is the retry policy of the failed requests,
+	// DefaultWebhookBackoff by default
+	Backoff queue.Backoff
+	// RateLimit is the minimal interval between the requests,
+	// the later requests wait
+	RateLimit time.Duration
+	// Client is the HTTP client, the default one is used when it's nil
+	Client *http.Client
+}
+
+// Webhook sends the hooks to the HTTP target
+type Webhook struct {
+	opts   WebhookOptions
+	body   *template.Template
+	client *http.Client
+	clock  clock.Clock
+
+	// next is the time of the next request of the rate limit
+	lock sync.Mutex
+	next time.Time
+}
+
+func NewWebhook(opts WebhookOptions) (*Webhook, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid webhook URL %q", opts.URL)
+	}
+
+	if opts.Method == "" {
+		opts.Method = http.MethodPost
+	}
+
+	if opts.Body == "" {
+		opts.Body = DefaultWebhookBody
+	}
+
+	if opts.Backoff == (queue.Backoff{}) {
+		opts.Backoff = DefaultWebhookBackoff
+	}
+
+	for _, kind := range opts.Kinds {
+		if !slices.Contains(Kinds, kind) {
+			return nil, fmt.Errorf("unknown hook kind %q", kind)
+		}
+	}
+
+	body, err := template.New("body").Funcs(template.FuncMap{"json": encodeJSON}).Parse(opts.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook body: %w", err)
+	}
+
+	w := &Webhook{opts: opts, body: body, client: opts.Client, clock: clock.Real}
+
+	if w.client == nil {
+		w.client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	return w, nil
+}
+
+// Name returns the name of the target
+func (w *Webhook) Name() string {
+	return w.opts.Name
+}
+
+// SetClock sets the clock of the retries and the rate limit
+func (w *Webhook) SetClock(c clock.Clock) {
+	w.clock = c
+}
+
+// Notify sends the hook of the saved event
+func (w *Webhook) Notify(ctx context.Context, e Event) error {
+	return w.Send(ctx, Hook{
+		Kind:    EventSaved,
+		Time:    e.Time(),
+		Camera:  e.Camera,
+		Trigger: &e.Trigger,
+		Event:   &e,
+	})
+}
+
+// Send sends the hook unless the target skips its kind, the failed
+// requests are retried until the backoff is over
+func (w *Webhook) Send(ctx context.Context, h Hook) error {
+	if len(w.opts.Kinds) > 0 && !slices.Contains(w.opts.Kinds, h.Kind) {
+		return nil
+	}
+
+	var body bytes.Buffer
+
+	if err := w.body.Execute(&body, h); err != nil {
+		return fmt.Errorf("webhook body failed: %w", err)
+	}
+
+	for attempts := 1; ; attempts++ {
+		if err := w.wait(ctx); err != nil {
+			return err
+		}
+
+		retry, err := w.send(ctx, h.Kind, body.Bytes())
+		if err == nil {
+			return nil
+		}
+
+		delay, ok := w.opts.Backoff.Delay(attempts)
+		if !retry || !ok {
+			return err
+		}
+
+		slog.Warn("webhook failed, retry", "webhook", w.opts.Name, "kind", h.Kind, "delay", delay, "err", err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-w.clock.After(delay):
+		}
+	}
+}
+
+// wait waits for the request slot of the rate limit
+func (w *Webhook) wait(ctx context.Context) error {
+	if w.opts.RateLimit <= 0 {
+		return nil
+	}
+
+	w.lock.Lock()
+
+	now := w.clock.Now()
+	at := w.next
+
+	if at.Before(now) {
+		at = now
+	}
+
+	w.next = at.Add(w.opts.RateLimit)
+
+	w.lock.Unlock()
+
+	if !at.After(now) {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-w.clock.After(at.Sub(now)):
+		return nil
+	}
+}
+
+// send sends the request, retry is false when the target rejects it
+func (w *Webhook) send(ctx context.Context, kind string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, w.opts.Method, w.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(KindHeader, kind)
+
+	for key, value := range w.opts.Headers {
+		req.Header.Set(key, value)
+	}
+
+	if w.opts.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(w.opts.Secret, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+
+	defer resp.Body.Close()
+
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("webhook response: %s", resp.Status)
+	}
+
+	return false, fmt.Errorf("webhook response: %s", resp.Status)
+}
+
+// Sign returns the signature header value of the body
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func encodeJSON(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
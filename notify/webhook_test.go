@@ -0,0 +1,200 @@
+package notify_test
+
+import (
+	"camrec/clock"
+	"camrec/notify"
+	"camrec/queue"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// target is the webhook target replying with the queued statuses
+type target struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	statuses []int
+	requests []*http.Request
+	bodies   []string
+}
+
+func newTarget(t *testing.T, statuses ...int) *target {
+	t.Helper()
+
+	tg := &target{statuses: statuses}
+	tg.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		tg.lock.Lock()
+		defer tg.lock.Unlock()
+
+		tg.requests = append(tg.requests, r)
+		tg.bodies = append(tg.bodies, string(body))
+
+		if len(tg.statuses) > 0 {
+			w.WriteHeader(tg.statuses[0])
+			tg.statuses = tg.statuses[1:]
+		}
+	}))
+
+	t.Cleanup(tg.Close)
+
+	return tg
+}
+
+func (tg *target) count() int {
+	tg.lock.Lock()
+	defer tg.lock.Unlock()
+
+	return len(tg.requests)
+}
+
+// fastBackoff retries the requests at once
+var fastBackoff = queue.Backoff{Initial: time.Millisecond, Max: time.Millisecond, Attempts: 3}
+
+func TestWebhook(t *testing.T) {
+	t.Run("default body", func(t *testing.T) {
+		tg := newTarget(t)
+
+		w, err := notify.NewWebhook(notify.WebhookOptions{
+			URL:     tg.URL,
+			Headers: map[string]string{"Authorization": "Bearer token"},
+			Secret:  "secret",
+		})
+		require.NoError(t, err)
+
+		e := testEvent(t, []byte("video"))
+
+		require.NoError(t, w.Notify(context.Background(), e))
+		require.Equal(t, 1, tg.count())
+
+		r, body := tg.requests[0], tg.bodies[0]
+
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+		require.Equal(t, notify.EventSaved, r.Header.Get(notify.KindHeader))
+		require.Equal(t, notify.Sign("secret", []byte(body)), r.Header.Get(notify.SignatureHeader))
+
+		var hook map[string]any
+
+		require.NoError(t, json.Unmarshal([]byte(body), &hook))
+		require.Equal(t, "event.saved", hook["kind"])
+		require.Equal(t, "front", hook["camera"])
+		require.Equal(t, "2024-03-01T10:00:00Z", hook["time"])
+		require.Equal(t, "front/2024-03-01_10-00-00", hook["event"].(map[string]any)["id"])
+		require.Equal(t, "parcel at the door", hook["trigger"].(map[string]any)["Note"])
+		require.NotContains(t, hook, "last_frame")
+	})
+
+	t.Run("template body", func(t *testing.T) {
+		tg := newTarget(t)
+		last := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+		w, err := notify.NewWebhook(notify.WebhookOptions{
+			URL:    tg.URL,
+			Method: http.MethodPut,
+			Kinds:  []string{notify.CameraDown, notify.CameraRecovered},
+			Body:   `{"text": {{printf "%s is down since %s" .Camera (.LastFrame.Format "15:04") | json}}}`,
+		})
+		require.NoError(t, err)
+
+		ctx := context.Background()
+
+		require.NoError(t, w.Send(ctx, notify.Hook{Kind: notify.TriggerReceived, Camera: "front"}))
+		require.NoError(t, w.Send(ctx, notify.Hook{
+			Kind:      notify.CameraDown,
+			Camera:    "front",
+			LastFrame: &last,
+		}))
+
+		require.Equal(t, 1, tg.count())
+		require.Equal(t, http.MethodPut, tg.requests[0].Method)
+		require.Equal(t, `{"text": "front is down since 10:00"}`, tg.bodies[0])
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		tg := newTarget(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+
+		w, err := notify.NewWebhook(notify.WebhookOptions{URL: tg.URL, Backoff: fastBackoff})
+		require.NoError(t, err)
+
+		require.NoError(t, w.Send(context.Background(), notify.Hook{Kind: notify.EventFailed}))
+		require.Equal(t, 3, tg.count())
+	})
+
+	t.Run("retries are over", func(t *testing.T) {
+		tg := newTarget(t, 500, 500, 500)
+
+		w, err := notify.NewWebhook(notify.WebhookOptions{URL: tg.URL, Backoff: fastBackoff})
+		require.NoError(t, err)
+
+		err = w.Send(context.Background(), notify.Hook{Kind: notify.EventFailed})
+		require.ErrorContains(t, err, "500")
+		require.Equal(t, 3, tg.count())
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		tg := newTarget(t, http.StatusBadRequest)
+
+		w, err := notify.NewWebhook(notify.WebhookOptions{URL: tg.URL, Backoff: fastBackoff})
+		require.NoError(t, err)
+
+		err = w.Send(context.Background(), notify.Hook{Kind: notify.EventFailed})
+		require.ErrorContains(t, err, "400")
+		require.Equal(t, 1, tg.count())
+	})
+
+	t.Run("rate limit", func(t *testing.T) {
+		tg := newTarget(t)
+
+		w, err := notify.NewWebhook(notify.WebhookOptions{URL: tg.URL, RateLimit: time.Minute})
+		require.NoError(t, err)
+
+		c := clock.NewVirtual(time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC))
+		w.SetClock(c)
+
+		var wg sync.WaitGroup
+
+		for i := 0; i < 3; i++ {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+				require.NoError(t, w.Send(context.Background(), notify.Hook{Kind: notify.TriggerReceived}))
+			}()
+		}
+
+		require.Eventually(t, func() bool {
+			return tg.count() == 1 && c.Waiters() == 2
+		}, time.Second, time.Millisecond)
+
+		c.Advance(time.Minute)
+
+		require.Eventually(t, func() bool { return tg.count() == 2 }, time.Second, time.Millisecond)
+		require.Never(t, func() bool { return tg.count() > 2 }, 50*time.Millisecond, time.Millisecond)
+
+		c.Advance(time.Minute)
+
+		wg.Wait()
+		require.Equal(t, 3, tg.count())
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := notify.NewWebhook(notify.WebhookOptions{URL: "example.com/hook"})
+		require.Error(t, err)
+
+		_, err = notify.NewWebhook(notify.WebhookOptions{URL: "https://example.com", Kinds: []string{"event.deleted"}})
+		require.Error(t, err)
+
+		_, err = notify.NewWebhook(notify.WebhookOptions{URL: "https://example.com", Body: "{{.Kind"})
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"camrec/queue"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// defaultQueueFile is the journal of the pending triggers
+const defaultQueueFile = "queue.jsonl"
+
+// showQueue lists the pending and the dead triggers of the journal,
+// the journal is read without changes, so the recorder may run
+func showQueue(args []string) (err error) {
+	flags := flag.NewFlagSet("queue", flag.ContinueOnError)
+
+	dead := flags.Bool("dead", false, "list the triggers which failed all attempts only")
+
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	q, err := queue.Load(envOr("QUEUE_FILE", defaultQueueFile))
+	if err != nil {
+		return
+	}
+
+	items := q.Dead()
+	if !*dead {
+		items = append(q.Pending(), items...)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "STATE\tTRIGGER\tCAMERAS\tATTEMPTS\tERROR")
+
+	for _, it := range items {
+		state := "pending"
+		if it.Dead {
+			state = "dead"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", state, it.Trigger.Time.Local().Format(time.DateTime),
+			strings.Join(it.Cameras, ","), it.Attempts, it.Error)
+	}
+
+	return w.Flush()
+}
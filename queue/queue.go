@@ -0,0 +1,344 @@
+// Package queue keeps the pending event extractions in an on-disk journal,
+// so the triggers survive the save failures and restarts
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"camrec/trigger"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxDead is the number of the dead items kept in the journal
+const maxDead = 100
+
+// Item is the pending extraction of the trigger
+type Item struct {
+	ID      string          `json:"id"`
+	Trigger trigger.Trigger `json:"trigger"`
+	// Cameras are IDs of the cameras the event isn't saved for yet
+	Cameras []string `json:"cameras"`
+	// Saved is the number of the cameras the event is saved for
+	Saved    int       `json:"saved,omitempty"`
+	Due      time.Time `json:"due"`
+	Attempts int       `json:"attempts,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	// Dead items failed all attempts, they are kept for inspection
+	Dead bool `json:"dead,omitempty"`
+}
+
+// record is the journal line, the item is nil when it's deleted
+type record struct {
+	ID   string `json:"id"`
+	Item *Item  `json:"item,omitempty"`
+}
+
+// Queue is the journal of the items, the journal is rewritten on open
+// and when it grows twice as large as the item list
+type Queue struct {
+	lock    sync.Mutex
+	path    string
+	file    *os.File
+	items   map[string]*Item
+	records int
+}
+
+// Open loads the journal, the empty path means the queue in memory
+func Open(path string) (q *Queue, err error) {
+	if q, err = Load(path); err != nil || path == "" {
+		return
+	}
+
+	q.path = path
+
+	if err = q.compact(); err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// Load returns the queue in memory of the journal items, the journal
+// isn't modified, so it's read while the recorder runs
+func Load(path string) (q *Queue, err error) {
+	q = &Queue{
+		items: make(map[string]*Item),
+	}
+
+	if path == "" {
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("unable to read queue: %w", err)
+	}
+
+	lines := bytes.Split(data, []byte("\n"))
+
+	for i, line := range lines {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var r record
+
+		if err = json.Unmarshal(line, &r); err != nil {
+			// the last line is cut by the crash during the write
+			if i == len(lines)-1 {
+				break
+			}
+
+			return nil, fmt.Errorf("invalid queue %s line %d: %w", path, i+1, err)
+		}
+
+		if r.Item == nil {
+			delete(q.items, r.ID)
+		} else {
+			q.items[r.ID] = r.Item
+		}
+	}
+
+	return q, nil
+}
+
+// Put adds or updates the item, the ID of the new item is generated
+func (q *Queue) Put(it Item) (Item, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if it.ID == "" {
+		b := make([]byte, 8)
+
+		if _, err := rand.Read(b); err != nil {
+			return it, err
+		}
+
+		it.ID = hex.EncodeToString(b)
+	}
+
+	it.Cameras = append([]string{}, it.Cameras...)
+	q.items[it.ID] = &it
+
+	if err := q.write(record{ID: it.ID, Item: &it}); err != nil {
+		return it, err
+	}
+
+	if it.Dead {
+		return it, q.trimDead()
+	}
+
+	return it, nil
+}
+
+// Delete removes the completed item
+func (q *Queue) Delete(id string) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if _, ok := q.items[id]; !ok {
+		return nil
+	}
+
+	delete(q.items, id)
+
+	return q.write(record{ID: id})
+}
+
+// Pending returns the items to process by the due time
+func (q *Queue) Pending() []Item {
+	return q.list(false)
+}
+
+// Dead returns the items which failed all attempts
+func (q *Queue) Dead() []Item {
+	return q.list(true)
+}
+
+func (q *Queue) list(dead bool) (items []Item) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for _, it := range q.items {
+		if it.Dead == dead {
+			items = append(items, *it)
+		}
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Due.Equal(items[j].Due) {
+			return items[i].ID < items[j].ID
+		}
+
+		return items[i].Due.Before(items[j].Due)
+	})
+
+	return
+}
+
+// Close closes the journal
+func (q *Queue) Close() error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.file == nil {
+		return nil
+	}
+
+	err := q.file.Close()
+	q.file = nil
+
+	return err
+}
+
+// trimDead removes the oldest dead items above the limit
+func (q *Queue) trimDead() error {
+	var dead []*Item
+
+	for _, it := range q.items {
+		if it.Dead {
+			dead = append(dead, it)
+		}
+	}
+
+	if len(dead) <= maxDead {
+		return nil
+	}
+
+	sort.Slice(dead, func(i, j int) bool { return dead[i].Due.Before(dead[j].Due) })
+
+	for _, it := range dead[:len(dead)-maxDead] {
+		delete(q.items, it.ID)
+
+		if err := q.write(record{ID: it.ID}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// write appends the record to the journal and syncs it
+func (q *Queue) write(r record) error {
+	if q.path == "" {
+		return nil
+	}
+
+	if q.file == nil {
+		return errors.New("queue is closed")
+	}
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	if _, err = q.file.Write(append(data, '\n')); err == nil {
+		err = q.file.Sync()
+	}
+
+	if err != nil {
+		return fmt.Errorf("unable to write queue: %w", err)
+	}
+
+	q.records++
+
+	if q.records > 2*len(q.items)+100 {
+		return q.compact()
+	}
+
+	return nil
+}
+
+// compact rewrites the journal with the current items atomically, the
+// temporary file becomes the journal handle after the rename, so the
+// current journal stays open when the rewrite fails
+func (q *Queue) compact() error {
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), filepath.Base(q.path)+".*")
+	if err != nil {
+		return fmt.Errorf("unable to write queue: %w", err)
+	}
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+
+	for id, it := range q.items {
+		if err = enc.Encode(record{ID: id, Item: it}); err != nil {
+			break
+		}
+	}
+
+	if err == nil {
+		err = w.Flush()
+	}
+
+	if err == nil {
+		err = tmp.Sync()
+	}
+
+	if err == nil {
+		err = os.Rename(tmp.Name(), q.path)
+	}
+
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+
+		return fmt.Errorf("unable to write queue: %w", err)
+	}
+
+	if q.file != nil {
+		q.file.Close()
+	}
+
+	q.file = tmp
+	q.records = len(q.items)
+
+	return nil
+}
+
+// Backoff is the retry policy of the failed items
+type Backoff struct {
+	// Initial is the delay after the first failure, it's doubled
+	// after every next failure up to Max
+	Initial time.Duration
+	Max     time.Duration
+	// Attempts is the number of the attempts before the item is dead
+	Attempts int
+}
+
+// DefaultBackoff retries the item for about five minutes
+var DefaultBackoff = Backoff{
+	Initial:  10 * time.Second,
+	Max:      2 * time.Minute,
+	Attempts: 5,
+}
+
+// Delay returns the delay after the failed attempts, ok is false when
+// there are no attempts left
+func (b Backoff) Delay(attempts int) (d time.Duration, ok bool) {
+	if attempts >= b.Attempts {
+		return 0, false
+	}
+
+	d = b.Initial
+
+	for i := 1; i < attempts && d < b.Max; i++ {
+		d *= 2
+	}
+
+	if d > b.Max {
+		d = b.Max
+	}
+
+	return d, true
+}
@@ -0,0 +1,237 @@
+package queue_test
+
+import (
+	"camrec/queue"
+	"camrec/trigger"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+func item(minutes int) queue.Item {
+	return queue.Item{
+		Trigger: trigger.Trigger{Time: start.Add(time.Duration(minutes) * time.Minute), Camera: "front"},
+		Cameras: []string{"front"},
+		Due:     start.Add(time.Duration(minutes)*time.Minute + 20*time.Second),
+	}
+}
+
+func TestQueue(t *testing.T) {
+	t.Run("journal", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "queue.jsonl")
+
+		q, err := queue.Open(path)
+		require.NoError(t, err)
+
+		second, err := q.Put(item(2))
+		require.NoError(t, err)
+		require.NotEmpty(t, second.ID)
+
+		first, err := q.Put(item(1))
+		require.NoError(t, err)
+
+		done, err := q.Put(item(3))
+		require.NoError(t, err)
+		require.NoError(t, q.Delete(done.ID))
+
+		// the retry of the failed camera
+		first.Attempts = 1
+		first.Error = "timestamp is out of the buffer"
+		first.Due = first.Due.Add(10 * time.Second)
+
+		_, err = q.Put(first)
+		require.NoError(t, err)
+
+		dead := second
+		dead.Dead = true
+
+		_, err = q.Put(dead)
+		require.NoError(t, err)
+		require.NoError(t, q.Close())
+
+		stat, err := os.Stat(path)
+		require.NoError(t, err)
+		require.Equal(t, os.FileMode(0600), stat.Mode().Perm())
+
+		q, err = queue.Open(path)
+		require.NoError(t, err)
+		defer q.Close()
+
+		pending := q.Pending()
+		require.Len(t, pending, 1)
+		require.Equal(t, first.ID, pending[0].ID)
+		require.Equal(t, 1, pending[0].Attempts)
+		require.True(t, first.Due.Equal(pending[0].Due))
+		require.Equal(t, first.Trigger.Camera, pending[0].Trigger.Camera)
+
+		require.Len(t, q.Dead(), 1)
+		require.Equal(t, second.ID, q.Dead()[0].ID)
+
+		// the journal is compacted on open
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, 2, strings.Count(string(data), "\n"))
+	})
+
+	t.Run("cut last line", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "queue.jsonl")
+
+		q, err := queue.Open(path)
+		require.NoError(t, err)
+
+		_, err = q.Put(item(1))
+		require.NoError(t, err)
+		require.NoError(t, q.Close())
+
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		require.NoError(t, err)
+		f.WriteString(`{"id":"abc","item":{"id":"a`)
+		f.Close()
+
+		q, err = queue.Open(path)
+		require.NoError(t, err)
+		defer q.Close()
+
+		require.Len(t, q.Pending(), 1)
+	})
+
+	t.Run("invalid journal", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "queue.jsonl")
+		require.NoError(t, os.WriteFile(path, []byte("{\n{}\n"), 0600))
+
+		_, err := queue.Open(path)
+		require.ErrorContains(t, err, "line 1")
+	})
+
+	t.Run("compaction", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "queue.jsonl")
+
+		q, err := queue.Open(path)
+		require.NoError(t, err)
+		defer q.Close()
+
+		for i := 0; i < 500; i++ {
+			it, err := q.Put(item(i))
+			require.NoError(t, err)
+			require.NoError(t, q.Delete(it.ID))
+		}
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Less(t, strings.Count(string(data), "\n"), 200)
+	})
+
+	t.Run("compaction failure", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "queue")
+		require.NoError(t, os.Mkdir(dir, 0700))
+
+		path := filepath.Join(dir, "queue.jsonl")
+
+		q, err := queue.Open(path)
+		require.NoError(t, err)
+		defer q.Close()
+
+		it, err := q.Put(item(1))
+		require.NoError(t, err)
+
+		// the next write is above the compaction threshold
+		for i := 0; i < 101; i++ {
+			_, err = q.Put(it)
+			require.NoError(t, err)
+		}
+
+		// the temporary file can't be created next to the journal
+		moved := dir + ".moved"
+		require.NoError(t, os.Rename(dir, moved))
+
+		_, err = q.Put(it)
+		require.Error(t, err)
+
+		require.NoError(t, os.Rename(moved, dir))
+
+		// the journal is still open
+		second, err := q.Put(item(2))
+		require.NoError(t, err)
+		require.NoError(t, q.Close())
+
+		q, err = queue.Open(path)
+		require.NoError(t, err)
+
+		pending := q.Pending()
+		require.Len(t, pending, 2)
+		require.Equal(t, []string{it.ID, second.ID}, []string{pending[0].ID, pending[1].ID})
+	})
+
+	t.Run("load", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "queue.jsonl")
+
+		q, err := queue.Open(path)
+		require.NoError(t, err)
+		defer q.Close()
+
+		it, err := q.Put(item(1))
+		require.NoError(t, err)
+
+		dead := item(2)
+		dead.Dead = true
+		dead.Error = "timestamp is out of the buffer"
+
+		_, err = q.Put(dead)
+		require.NoError(t, err)
+		require.NoError(t, q.Delete(it.ID))
+
+		before, err := os.ReadFile(path)
+		require.NoError(t, err)
+
+		loaded, err := queue.Load(path)
+		require.NoError(t, err)
+		require.Empty(t, loaded.Pending())
+		require.Len(t, loaded.Dead(), 1)
+		require.Equal(t, dead.Error, loaded.Dead()[0].Error)
+
+		// the journal of the running queue isn't compacted
+		after, err := os.ReadFile(path)
+		require.NoError(t, err)
+		require.Equal(t, before, after)
+
+		_, err = q.Put(item(3))
+		require.NoError(t, err)
+		require.Len(t, q.Pending(), 1)
+	})
+
+	t.Run("dead letters limit", func(t *testing.T) {
+		q, err := queue.Open("")
+		require.NoError(t, err)
+
+		for i := 0; i < 120; i++ {
+			it := item(i)
+			it.Dead = true
+
+			_, err := q.Put(it)
+			require.NoError(t, err)
+		}
+
+		dead := q.Dead()
+		require.Len(t, dead, 100)
+		require.True(t, item(20).Due.Equal(dead[0].Due))
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	b := queue.Backoff{Initial: 10 * time.Second, Max: time.Minute, Attempts: 5}
+
+	for attempts, want := range []time.Duration{10 * time.Second, 10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute} {
+		d, ok := b.Delay(attempts)
+		require.True(t, ok)
+		require.Equal(t, want, d, "attempts %d", attempts)
+	}
+
+	_, ok := b.Delay(5)
+	require.False(t, ok)
+}
@@ -0,0 +1,441 @@
+// Package recorder connects trigger sources with the camera streams
+package recorder
+
+import (
+	"camrec/clock"
+	"camrec/config"
+	"camrec/event"
+	"camrec/queue"
+	"camrec/stream"
+	"camrec/trigger"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// Recorder saves events of every camera on the trigger timestamps
+type Recorder struct {
+	// Delay lets the cameras buffer the video after the trigger
+	Delay time.Duration
+	// OnTrigger is called for every received trigger
+	OnTrigger func(t trigger.Trigger)
+	// OnHandled is called once the trigger is completed or dead, the error
+	// is nil when the event of the trigger is saved by at least one camera
+	OnHandled func(t trigger.Trigger, err error)
+	// OnSaved is called for every saved event of the trigger cameras,
+	// the event keeps the buffered video while it's referenced
+	OnSaved func(t trigger.Trigger, e *event.Event)
+	// Queue keeps the pending triggers, the queue in memory is used
+	// when it's nil
+	Queue *queue.Queue
+	// Backoff is the retry policy of the failed triggers
+	Backoff queue.Backoff
+	// Grace limits the wait for the pending triggers after the trigger
+	// channel is closed, the rest are saved at once with the video
+	// buffered so far, zero means no limit
+	Grace time.Duration
+
+	cameras    []config.Camera
+	streamers  []stream.StreamingProcess
+	clock      clock.Clock
+	bufferSize time.Duration
+	done       chan error
+	stopped    []chan struct{}
+}
+
+func New(ctx context.Context, cameras []config.Camera, bufferSize time.Duration) *Recorder {
+	r := &Recorder{
+		Backoff:    queue.DefaultBackoff,
+		cameras:    cameras,
+		clock:      clock.Real,
+		bufferSize: bufferSize,
+	}
+
+	for _, camera := range cameras {
+		r.streamers = append(r.streamers, stream.New(ctx, camera, bufferSize))
+	}
+
+	return r
+}
+
+// SetClock sets the clock of the trigger delay and the streams,
+// it must be called before the start
+func (r *Recorder) SetClock(c clock.Clock) {
+	r.clock = c
+
+	for _, p := range r.streamers {
+		p.SetClock(c)
+	}
+}
+
+// Start starts streaming of all cameras
+func (r *Recorder) Start() error {
+	r.done = make(chan error, len(r.streamers))
+
+	for i, p := range r.streamers {
+		if err := p.Start(); err != nil {
+			return fmt.Errorf("camera %s: streaming start failed: %w", r.cameras[i].Name(), err)
+		}
+
+		stopped := make(chan struct{})
+		r.stopped = append(r.stopped, stopped)
+
+		go func(camera config.Camera, p stream.StreamingProcess) {
+			defer close(stopped)
+
+			err := <-p.Done()
+			r.done <- fmt.Errorf("camera %s: %w", camera.Name(), err)
+		}(r.cameras[i], p)
+	}
+
+	return nil
+}
+
+// Wait waits until the started streams are stopped, the streams stop
+// when the context of New is done
+func (r *Recorder) Wait(ctx context.Context) error {
+	for _, stopped := range r.stopped {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-stopped:
+		}
+	}
+
+	return nil
+}
+
+// Run handles the triggers until the context is done or the trigger
+// channel is closed, the triggers waiting for the first attempt are
+// handled before return in the latter case, the retries are left in the
+// queue. Finished file streams keep the buffered video, any other stream
+// end is returned as error
+func (r *Recorder) Run(ctx context.Context, triggers <-chan trigger.Trigger) (err error) {
+	if r.Queue == nil {
+		if r.Queue, err = queue.Open(""); err != nil {
+			return
+		}
+	}
+
+	var (
+		results  = make(chan result)
+		running  = make(map[string]bool)
+		wake     <-chan time.Time
+		wakeAt   time.Time
+		deadline time.Time
+	)
+
+	for {
+		now := r.clock.Now()
+
+		var (
+			next  time.Time
+			first int
+		)
+
+		// the grace period is over, the pending triggers are saved now
+		flush := !deadline.IsZero() && !now.Before(deadline)
+
+		for _, it := range r.Queue.Pending() {
+			if running[it.ID] {
+				continue
+			}
+
+			if it.Attempts == 0 {
+				first++
+			}
+
+			if it.Due.After(now) && !(flush && it.Attempts == 0) {
+				if next.IsZero() || it.Due.Before(next) {
+					next = it.Due
+				}
+
+				continue
+			}
+
+			running[it.ID] = true
+
+			go func(it queue.Item) {
+				res := r.attempt(it)
+
+				select {
+				case results <- res:
+				case <-ctx.Done():
+				}
+			}(it)
+		}
+
+		if triggers == nil && len(running) == 0 && first == 0 {
+			return nil
+		}
+
+		if !deadline.IsZero() && !flush && (next.IsZero() || deadline.Before(next)) {
+			next = deadline
+		}
+
+		if !next.IsZero() && (wake == nil || next.Before(wakeAt)) {
+			wake = r.clock.After(next.Sub(now))
+			wakeAt = next
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case t, ok := <-triggers:
+			if !ok {
+				triggers = nil
+
+				if r.Grace > 0 {
+					deadline = r.clock.Now().Add(r.Grace)
+				}
+
+				continue
+			}
+
+			if r.OnTrigger != nil {
+				r.OnTrigger(t)
+			}
+
+			r.enqueue(t)
+
+		case res := <-results:
+			delete(running, res.item.ID)
+			r.complete(res)
+
+		case <-wake:
+			wake = nil
+
+		case err := <-r.done:
+			if !errors.Is(err, io.EOF) {
+				return err
+			}
+
+			slog.Info("stream finished", "err", err)
+		}
+	}
+}
+
+// result is the item after the attempt, the error joins the camera errors
+type result struct {
+	item queue.Item
+	err  error
+}
+
+// enqueue adds the item of the trigger cameras due after the delay,
+// the item waits for the video after the trigger when it's longer
+func (r *Recorder) enqueue(t trigger.Trigger) {
+	it := queue.Item{
+		Trigger: t,
+		Due:     stream.Due(t, r.clock.Now(), r.Delay),
+	}
+
+	for _, c := range r.cameras {
+		if t.Camera == "" || c.ID == t.Camera {
+			it.Cameras = append(it.Cameras, c.ID)
+		}
+	}
+
+	if len(it.Cameras) == 0 {
+		err := fmt.Errorf("unknown camera %q", t.Camera)
+
+		slog.Warn("trigger failed", "trigger", t.Time, "err", err)
+		r.handled(t, err)
+
+		return
+	}
+
+	// the item is processed from memory when the journal fails
+	if _, err := r.Queue.Put(it); err != nil {
+		slog.Error("trigger queue failed", "trigger", t.Time, "err", err)
+	}
+}
+
+// attempt saves the events of the item cameras, the cameras failed to
+// save the event are kept in the item
+func (r *Recorder) attempt(it queue.Item) result {
+	slog.Debug("handle trigger", "trigger", it.Trigger.Time)
+
+	var (
+		failed []string
+		errs   []error
+	)
+
+	for _, id := range it.Cameras {
+		i := r.index(id)
+
+		// the camera is removed from the settings after the restart
+		if i < 0 {
+			errs = append(errs, fmt.Errorf("unknown camera %q", id))
+			continue
+		}
+
+		e, err := r.streamers[i].HandleTrigger(it.Trigger)
+		if err != nil {
+			slog.Warn("event save failed", "camera", r.cameras[i].Name(), "err", err)
+
+			failed = append(failed, id)
+			errs = append(errs, fmt.Errorf("camera %s: %w", r.cameras[i].Name(), err))
+
+			continue
+		}
+
+		it.Saved++
+
+		if r.OnSaved != nil {
+			r.OnSaved(it.Trigger, e)
+		}
+	}
+
+	err := errors.Join(errs...)
+
+	it.Cameras = failed
+	it.Attempts++
+	it.Error = ""
+
+	if err != nil {
+		it.Error = err.Error()
+	}
+
+	return result{item: it, err: err}
+}
+
+// complete removes the item or schedules the retry of the failed cameras,
+// the item is dead when there are no attempts left or the video of the
+// trigger is out of the buffer
+func (r *Recorder) complete(res result) {
+	it := res.item
+
+	if len(it.Cameras) == 0 {
+		r.delete(it)
+		r.handled(it.Trigger, r.outcome(res))
+
+		return
+	}
+
+	delay, ok := r.Backoff.Delay(it.Attempts)
+
+	if r.clock.Now().Sub(it.Trigger.Time) > r.bufferSize {
+		ok = false
+	}
+
+	if !ok {
+		slog.Error("trigger failed, moved to dead letters", "trigger", it.Trigger.Time, "err", it.Error)
+
+		it.Dead = true
+		r.put(it)
+		r.handled(it.Trigger, r.outcome(res))
+
+		return
+	}
+
+	slog.Warn("trigger failed, retry", "trigger", it.Trigger.Time, "delay", delay)
+
+	it.Due = r.clock.Now().Add(delay)
+	r.put(it)
+}
+
+// outcome is nil when the event is saved by at least one camera
+func (r *Recorder) outcome(res result) error {
+	if res.item.Saved > 0 {
+		return nil
+	}
+
+	return res.err
+}
+
+func (r *Recorder) handled(t trigger.Trigger, err error) {
+	if r.OnHandled != nil {
+		r.OnHandled(t, err)
+	}
+}
+
+func (r *Recorder) put(it queue.Item) {
+	if _, err := r.Queue.Put(it); err != nil {
+		slog.Error("trigger queue failed", "trigger", it.Trigger.Time, "err", err)
+	}
+}
+
+func (r *Recorder) delete(it queue.Item) {
+	if err := r.Queue.Delete(it.ID); err != nil {
+		slog.Error("trigger queue failed", "trigger", it.Trigger.Time, "err", err)
+	}
+}
+
+// Watch calls changed when the camera buffers no frames for longer than
+// stale and when it buffers them again, until the context is done. The
+// cameras without frames are stale since the start of the watch
+func (r *Recorder) Watch(ctx context.Context, stale time.Duration, changed func(camera string, down bool, st stream.Status)) {
+	ticker := r.clock.NewTicker(stale / 4)
+	defer ticker.Stop()
+
+	start := r.clock.Now()
+	down := make([]bool, len(r.cameras))
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		now := r.clock.Now()
+
+		for i, c := range r.cameras {
+			st := r.streamers[i].Status()
+
+			last := st.Last
+			if last.Before(start) {
+				last = start
+			}
+
+			if stalled := now.Sub(last) > stale; stalled != down[i] {
+				down[i] = stalled
+
+				if stalled {
+					slog.Warn("no frames", "camera", c.Name(), "since", last)
+				} else {
+					slog.Info("frames are buffered again", "camera", c.Name())
+				}
+
+				changed(c.ID, stalled, st)
+			}
+		}
+	}
+}
+
+// Peek returns the unsaved event of the buffered video of the camera,
+// the trigger without time takes the last buffered frame
+func (r *Recorder) Peek(camera string, t trigger.Trigger) (*event.Event, error) {
+	i := r.index(camera)
+	if i < 0 {
+		return nil, fmt.Errorf("unknown camera %q", camera)
+	}
+
+	return r.streamers[i].Peek(t)
+}
+
+// Status returns the stream states of the camera IDs
+func (r *Recorder) Status() map[string]stream.Status {
+	status := make(map[string]stream.Status, len(r.cameras))
+
+	for i, c := range r.cameras {
+		status[c.ID] = r.streamers[i].Status()
+	}
+
+	return status
+}
+
+// index returns index of the camera, -1 if it's not configured
+func (r *Recorder) index(id string) int {
+	for i, c := range r.cameras {
+		if c.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,602 @@
+package recorder_test
+
+import (
+	"bytes"
+	"camrec/clock"
+	"camrec/codec"
+	"camrec/config"
+	"camrec/event"
+	"camrec/mpegts/mpegtstest"
+	"camrec/queue"
+	"camrec/recorder"
+	"camrec/rtsp/rtsptest"
+	"camrec/stream"
+	"camrec/trigger"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const frameTicks = 3600 // 25 fps
+
+// start is the virtual time the recorders of the tests start at
+var start = time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+// fixtureFrames returns Annex-B access units with in-band parameter sets
+func fixtureFrames(count int) (frames [][]byte) {
+	for _, f := range rtsptest.FixtureVideo(count, 25) {
+		nals := [][]byte(f)
+
+		if codec.NALType(codec.H264, nals[0]) == codec.H264NalIDR {
+			nals = append([][]byte{rtsptest.SPS, rtsptest.PPS}, nals...)
+		}
+
+		frames = append(frames, codec.AccessUnit{NALUnits: nals}.AnnexB())
+	}
+
+	return
+}
+
+func fixtureTS(t *testing.T, count int) []byte {
+	t.Helper()
+
+	var data bytes.Buffer
+
+	w := mpegtstest.NewWriter(&data)
+
+	for i, f := range fixtureFrames(count) {
+		require.NoError(t, w.WriteVideo(int64(i*frameTicks), f))
+	}
+
+	return data.Bytes()
+}
+
+// fixtureFile writes the H.264 file of the frames
+func fixtureFile(t *testing.T, frames int) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "video.h264")
+	require.NoError(t, os.WriteFile(file, bytes.Join(fixtureFrames(frames), nil), 0644))
+
+	return file
+}
+
+// newRecorder returns the recorder of the cameras with the virtual clock,
+// the events are saved to the temporary directory
+func newRecorder(t *testing.T, cameras ...config.Camera) (*recorder.Recorder, *clock.Virtual) {
+	t.Helper()
+
+	event.OutputDirectory = t.TempDir()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	c := clock.NewVirtual(start)
+
+	rec := recorder.New(ctx, cameras, time.Minute)
+	rec.SetClock(c)
+
+	return rec, c
+}
+
+// startFile starts the recorder of the cameras streaming the H.264 file
+// of the frames, it returns when the file is buffered. The file is read
+// at once, so the video spans ahead of the virtual start
+func startFile(t *testing.T, frames int, ids ...string) (*recorder.Recorder, *clock.Virtual) {
+	t.Helper()
+
+	file := fixtureFile(t, frames)
+
+	var cameras []config.Camera
+
+	for _, id := range ids {
+		cameras = append(cameras, config.Camera{ID: id, Stream: file, Source: config.SourceFile})
+	}
+
+	rec, c := newRecorder(t, cameras...)
+
+	require.NoError(t, rec.Start())
+
+	end := start.Add(time.Duration(frames-1) * time.Second / 25)
+
+	require.Eventually(t, func() bool {
+		for _, id := range ids {
+			if rec.Status()[id].Last.Before(end) {
+				return false
+			}
+		}
+
+		return true
+	}, 5*time.Second, time.Millisecond)
+
+	return rec, c
+}
+
+// advance moves the virtual clock forward by the step until the condition is true
+func advance(t *testing.T, c *clock.Virtual, step time.Duration, cond func() bool) {
+	t.Helper()
+
+	require.Eventually(t, func() bool {
+		if cond() {
+			return true
+		}
+
+		c.Advance(step)
+
+		return false
+	}, 5*time.Second, time.Millisecond)
+}
+
+// run handles the triggers and moves the virtual clock forward until the
+// recorder is done with them
+func run(t *testing.T, rec *recorder.Recorder, c *clock.Virtual, triggers ...trigger.Trigger) {
+	t.Helper()
+
+	ch := make(chan trigger.Trigger, len(triggers))
+
+	for _, tr := range triggers {
+		ch <- tr
+	}
+
+	close(ch)
+
+	result := make(chan error, 1)
+
+	go func() {
+		result <- rec.Run(context.Background(), ch)
+	}()
+
+	var err error
+
+	advance(t, c, time.Second, func() bool {
+		select {
+		case err = <-result:
+			return true
+		default:
+			return false
+		}
+	})
+
+	require.NoError(t, err)
+}
+
+func requireEvent(t *testing.T, camera string) {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(event.OutputDirectory, "events", camera, "*.mp4"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	data, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	require.Equal(t, "ftyp", string(data[4:8]))
+	require.Contains(t, string(data), "avc1")
+}
+
+func TestRecorder(t *testing.T) {
+	t.Run("h264 file", func(t *testing.T) {
+		rec, c := startFile(t, 100, "h264")
+		rec.Delay = 200 * time.Millisecond
+
+		run(t, rec, c, trigger.Trigger{Time: start.Add(2 * time.Second)})
+
+		requireEvent(t, "h264")
+	})
+
+	t.Run("mpegts file in real time loop", func(t *testing.T) {
+		t.Setenv("LOOP_REALTIME", "true")
+		t.Setenv("LOOP_LOOP", "true")
+
+		file := filepath.Join(t.TempDir(), "video.ts")
+		require.NoError(t, os.WriteFile(file, fixtureTS(t, 10), 0644))
+
+		rec, c := newRecorder(t, config.Camera{ID: "loop", Stream: file, Source: config.SourceFile})
+		rec.Delay = 5 * time.Second
+
+		require.NoError(t, rec.Start())
+
+		// the file is 400 ms long, the trigger is in the third loop
+		run(t, rec, c, trigger.Trigger{Time: start.Add(900 * time.Millisecond)})
+
+		requireEvent(t, "loop")
+	})
+
+	t.Run("named pipe", func(t *testing.T) {
+		t.Setenv("PIPE_REALTIME", "true")
+
+		pipe := filepath.Join(t.TempDir(), "video.pipe")
+		require.NoError(t, syscall.Mkfifo(pipe, 0600))
+
+		data := fixtureTS(t, 50)
+
+		go func() {
+			f, err := os.OpenFile(pipe, os.O_WRONLY, 0)
+			if err != nil {
+				return
+			}
+			defer f.Close()
+
+			f.Write(data)
+		}()
+
+		rec, c := newRecorder(t, config.Camera{ID: "pipe", Stream: pipe, Source: config.SourceFile})
+		rec.Delay = 5 * time.Second
+
+		require.NoError(t, rec.Start())
+
+		run(t, rec, c, trigger.Trigger{Time: start.Add(time.Second)})
+
+		requireEvent(t, "pipe")
+	})
+}
+
+func TestRecorderDelay(t *testing.T) {
+	rec, c := startFile(t, 100, "delay")
+	rec.Delay = 20 * time.Second
+
+	fake := trigger.NewFake()
+	result := make(chan error)
+
+	go func() {
+		result <- rec.Run(context.Background(), fake.C)
+	}()
+
+	// the trigger is received late, so the delay outlasts the video after it
+	c.Advance(15 * time.Second)
+
+	fake.Fire(start.Add(2 * time.Second))
+	fake.Close()
+
+	// the statistics ticker and the trigger delay
+	require.Eventually(t, func() bool { return c.Waiters() == 2 }, time.Second, time.Millisecond)
+
+	c.Advance(19 * time.Second)
+
+	select {
+	case <-result:
+		t.Fatal("the trigger was handled before the delay")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	c.Advance(time.Second)
+	require.NoError(t, <-result)
+
+	requireEvent(t, "delay")
+}
+
+func TestGrace(t *testing.T) {
+	event.OutputDirectory = t.TempDir()
+
+	file := fixtureFile(t, 100)
+	c := clock.NewVirtual(start)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	rec := recorder.New(ctx, []config.Camera{{ID: "grace", Stream: file, Source: config.SourceFile}}, time.Minute)
+	rec.Delay = 20 * time.Second
+	rec.Grace = 5 * time.Second
+	rec.SetClock(c)
+
+	require.NoError(t, rec.Start())
+
+	fake := trigger.NewFake()
+	result := make(chan error)
+
+	go func() {
+		result <- rec.Run(context.Background(), fake.C)
+	}()
+
+	fake.Fire(start.Add(2 * time.Second))
+	fake.Close()
+
+	// the statistics ticker, the trigger delay and the grace period
+	require.Eventually(t, func() bool { return c.Waiters() == 3 }, time.Second, time.Millisecond)
+
+	c.Advance(5 * time.Second)
+	require.NoError(t, <-result)
+
+	requireEvent(t, "grace")
+
+	// the streams stop after the pending triggers are saved
+	cancel()
+
+	waitCtx, cancelWait := context.WithTimeout(context.Background(), time.Second)
+	defer cancelWait()
+
+	require.NoError(t, rec.Wait(waitCtx))
+}
+
+func TestOnHandled(t *testing.T) {
+	rec, c := startFile(t, 100, "handled")
+
+	results := make(map[string]error)
+	lock := sync.Mutex{}
+
+	rec.OnHandled = func(t trigger.Trigger, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		results[t.MessageID] = err
+	}
+
+	var saved []*event.Event
+
+	rec.OnSaved = func(t trigger.Trigger, e *event.Event) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		saved = append(saved, e)
+	}
+
+	run(t, rec, c,
+		trigger.Trigger{Time: start.Add(2 * time.Second), MessageID: "saved"},
+		trigger.Trigger{Time: start.Add(-time.Hour), MessageID: "missed"},
+	)
+
+	require.Len(t, results, 2)
+	require.NoError(t, results["saved"])
+	require.ErrorIs(t, results["missed"], stream.ErrNotBuffered)
+
+	require.Len(t, saved, 1)
+	require.Equal(t, "handled", saved[0].Camera())
+	require.FileExists(t, saved[0].File())
+	require.Contains(t, saved[0].ID(), "handled/")
+}
+
+func TestCameraTrigger(t *testing.T) {
+	rec, c := startFile(t, 100, "front", "back")
+
+	var results []error
+
+	rec.OnHandled = func(t trigger.Trigger, err error) {
+		results = append(results, err)
+	}
+
+	run(t, rec, c, trigger.Trigger{Time: start.Add(2 * time.Second), Camera: "back"})
+
+	require.Equal(t, []error{nil}, results)
+	requireEvent(t, "back")
+
+	files, err := filepath.Glob(filepath.Join(event.OutputDirectory, "events", "front", "*.mp4"))
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestManualTrigger(t *testing.T) {
+	rec, c := startFile(t, 100, "manual")
+
+	run(t, rec, c, trigger.Trigger{
+		Time: start.Add(2 * time.Second),
+		Pre:  500 * time.Millisecond,
+		Post: 500 * time.Millisecond,
+		Note: "parcel at the door",
+	})
+
+	requireEvent(t, "manual")
+
+	files, err := filepath.Glob(filepath.Join(event.OutputDirectory, "events", "manual", "*.json"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	data, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+
+	var meta map[string]any
+
+	require.NoError(t, json.Unmarshal(data, &meta))
+	require.Equal(t, "parcel at the door", meta["note"])
+}
+
+func TestPeek(t *testing.T) {
+	rec, _ := newRecorder(t, config.Camera{ID: "peek", Stream: fixtureFile(t, 1), Source: config.SourceFile})
+
+	_, err := rec.Peek("peek", trigger.Trigger{})
+	require.ErrorIs(t, err, stream.ErrNotBuffered)
+
+	rec, _ = startFile(t, 100, "peek")
+
+	status := rec.Status()["peek"]
+	require.False(t, status.Last.IsZero())
+
+	e, err := rec.Peek("peek", trigger.Trigger{})
+	require.NoError(t, err)
+	require.Equal(t, status.Last, e.End())
+	require.Empty(t, e.File())
+
+	_, _, ok := e.Keyframe(e.End())
+	require.True(t, ok)
+
+	_, err = rec.Peek("back", trigger.Trigger{})
+	require.Error(t, err)
+
+	files, err := filepath.Glob(filepath.Join(event.OutputDirectory, "events", "peek", "*.mp4"))
+	require.NoError(t, err)
+	require.Empty(t, files)
+}
+
+func TestWatch(t *testing.T) {
+	rec, c := newRecorder(t, config.Camera{ID: "watched", Stream: fixtureFile(t, 25), Source: config.SourceFile})
+
+	var (
+		lock    sync.Mutex
+		changes []bool
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go rec.Watch(ctx, 10*time.Second, func(camera string, down bool, st stream.Status) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		require.Equal(t, "watched", camera)
+		changes = append(changes, down)
+	})
+
+	changed := func(expected ...bool) func() bool {
+		return func() bool {
+			lock.Lock()
+			defer lock.Unlock()
+
+			return len(changes) == len(expected) && slices.Equal(changes, expected)
+		}
+	}
+
+	// the ticker of the watch
+	require.Eventually(t, func() bool { return c.Waiters() == 1 }, time.Second, time.Millisecond)
+
+	// the stream isn't started yet
+	advance(t, c, 2500*time.Millisecond, changed(true))
+
+	require.NoError(t, rec.Start())
+
+	// the frames are buffered until the end of the file
+	require.Eventually(t, func() bool { return !rec.Status()["watched"].Last.IsZero() }, 5*time.Second, time.Millisecond)
+
+	advance(t, c, 2500*time.Millisecond, changed(true, false))
+	advance(t, c, 2500*time.Millisecond, changed(true, false, true))
+}
+
+func TestRetry(t *testing.T) {
+	t.Setenv("RETRY_REALTIME", "true")
+	t.Setenv("RETRY_LOOP", "true")
+
+	file := filepath.Join(t.TempDir(), "video.ts")
+	require.NoError(t, os.WriteFile(file, fixtureTS(t, 10), 0644))
+
+	rec, c := newRecorder(t, config.Camera{ID: "retry", Stream: file, Source: config.SourceFile})
+	rec.Backoff = queue.Backoff{Initial: 300 * time.Millisecond, Max: 300 * time.Millisecond, Attempts: 5}
+
+	q, err := queue.Open("")
+	require.NoError(t, err)
+
+	rec.Queue = q
+
+	// the video of the trigger isn't recorded yet
+	_, err = q.Put(queue.Item{
+		Trigger: trigger.Trigger{Time: start.Add(500 * time.Millisecond)},
+		Cameras: []string{"retry"},
+		Due:     start,
+	})
+	require.NoError(t, err)
+
+	result := make(chan error, 1)
+
+	rec.OnHandled = func(t trigger.Trigger, err error) {
+		result <- err
+	}
+
+	require.NoError(t, rec.Start())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go rec.Run(ctx, make(chan trigger.Trigger))
+
+	advance(t, c, 100*time.Millisecond, func() bool {
+		select {
+		case err = <-result:
+			return true
+		default:
+			return false
+		}
+	})
+
+	require.NoError(t, err)
+	requireEvent(t, "retry")
+	require.Empty(t, rec.Queue.Pending())
+}
+
+func TestResume(t *testing.T) {
+	file := fixtureFile(t, 100)
+	journal := filepath.Join(t.TempDir(), "queue.jsonl")
+
+	// the triggers were pending on the shutdown
+	q, err := queue.Open(journal)
+	require.NoError(t, err)
+
+	_, err = q.Put(queue.Item{
+		Trigger: trigger.Trigger{Time: start.Add(2 * time.Second), MessageID: "buffered"},
+		Cameras: []string{"resume"},
+		Due:     start.Add(-time.Minute),
+	})
+	require.NoError(t, err)
+
+	_, err = q.Put(queue.Item{
+		Trigger: trigger.Trigger{Time: start.Add(-time.Hour), MessageID: "lost"},
+		Cameras: []string{"resume"},
+		Due:     start.Add(-time.Hour),
+	})
+	require.NoError(t, err)
+	require.NoError(t, q.Close())
+
+	q, err = queue.Open(journal)
+	require.NoError(t, err)
+	defer q.Close()
+
+	rec, c := newRecorder(t, config.Camera{ID: "resume", Stream: file, Source: config.SourceFile})
+	rec.Queue = q
+
+	results := make(map[string]error)
+	lock := sync.Mutex{}
+
+	rec.OnHandled = func(t trigger.Trigger, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		results[t.MessageID] = err
+	}
+
+	require.NoError(t, rec.Start())
+
+	// the file is read at once
+	require.Eventually(t, func() bool {
+		return !rec.Status()["resume"].Last.Before(start.Add(3 * time.Second))
+	}, 5*time.Second, time.Millisecond)
+
+	run(t, rec, c)
+
+	require.NoError(t, results["buffered"])
+	require.ErrorIs(t, results["lost"], stream.ErrNotBuffered)
+	requireEvent(t, "resume")
+
+	require.Empty(t, q.Pending())
+
+	dead := q.Dead()
+	require.Len(t, dead, 1)
+	require.Equal(t, "lost", dead[0].Trigger.MessageID)
+	require.Equal(t, 1, dead[0].Attempts)
+	require.Contains(t, dead[0].Error, "out of the buffer")
+}
+
+func TestFileSource(t *testing.T) {
+	t.Run("missing file", func(t *testing.T) {
+		rec := recorder.New(context.Background(), []config.Camera{
+			{ID: "missing", Stream: filepath.Join(t.TempDir(), "none.ts"), Source: config.SourceFile},
+		}, time.Minute)
+
+		require.Error(t, rec.Start())
+	})
+
+	t.Run("loop requires real time", func(t *testing.T) {
+		t.Setenv("FAST_LOOP", "true")
+
+		file := filepath.Join(t.TempDir(), "video.ts")
+		require.NoError(t, os.WriteFile(file, fixtureTS(t, 10), 0644))
+
+		rec := recorder.New(context.Background(), []config.Camera{
+			{ID: "fast", Stream: file, Source: config.SourceFile},
+		}, time.Minute)
+
+		require.Error(t, rec.Start())
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"camrec/config"
+	"camrec/event"
+	"camrec/stream"
+	"camrec/trigger"
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"time"
+)
+
+// replay regenerates events from the recorded stream and the trigger list
+func replay(args []string) (err error) {
+	flags := flag.NewFlagSet("replay", flag.ContinueOnError)
+
+	input := flags.String("input", "", "recorded MPEG-TS or H.264/H.265 stream file")
+	triggers := flags.String("triggers", "", "CSV file with trigger timestamps in the first column")
+	out := flags.String("out", ".", "output directory of the events")
+	camera := flags.String("camera", "", "camera ID for the event directory and the camera settings")
+	start := flags.String("start", "", "time of the first frame (default: file modification time minus stream duration)")
+	buffer := flags.Duration("buffer", bufferSize, "buffer size")
+	delay := flags.Duration("delay", triggerDelay, "delay between the trigger and the event search")
+
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	if *input == "" || *triggers == "" {
+		flags.Usage()
+		return errors.New("input and triggers files are required")
+	}
+
+	r := stream.Replay{
+		Camera: config.Camera{
+			ID:     *camera,
+			Stream: *input,
+			Source: config.SourceFile,
+		},
+		BufferSize: *buffer,
+		Delay:      *delay,
+	}
+
+	if *start != "" {
+		if r.Start, err = trigger.ParseTime(*start); err != nil {
+			return
+		}
+	}
+
+	f, err := os.Open(*triggers)
+	if err != nil {
+		return
+	}
+
+	list, err := trigger.ReadCSV(f)
+	f.Close()
+
+	if err != nil {
+		return
+	}
+
+	event.OutputDirectory = *out
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	started := time.Now()
+
+	count, err := r.Run(ctx, list)
+	if err != nil {
+		return
+	}
+
+	slog.Info("replay done", "duration", time.Since(started).Round(time.Millisecond), "saved", count, "triggers", len(list))
+
+	return
+}
@@ -0,0 +1,142 @@
+package rtsp
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// authenticator builds Authorization header for Basic or Digest challenge
+type authenticator struct {
+	user     string
+	password string
+	basic    bool
+	realm    string
+	nonce    string
+	opaque   string
+	qop      string
+	count    int
+}
+
+func newAuthenticator(challenge string, user, password string) (*authenticator, error) {
+	scheme, params, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+
+	a := &authenticator{
+		user:     user,
+		password: password,
+	}
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		a.basic = true
+		return a, nil
+
+	case "digest":
+	default:
+		return nil, fmt.Errorf("unsupported authentication scheme %q", scheme)
+	}
+
+	values := parseAuthParams(params)
+
+	if alg, ok := values["algorithm"]; ok && !strings.EqualFold(alg, "MD5") {
+		return nil, fmt.Errorf("unsupported digest algorithm %q", alg)
+	}
+
+	a.realm = values["realm"]
+	a.nonce = values["nonce"]
+	a.opaque = values["opaque"]
+
+	for _, qop := range strings.Split(values["qop"], ",") {
+		if strings.TrimSpace(qop) == "auth" {
+			a.qop = "auth"
+		}
+	}
+
+	if a.nonce == "" {
+		return nil, errors.New("digest challenge without nonce")
+	}
+
+	return a, nil
+}
+
+func parseAuthParams(params string) map[string]string {
+	values := make(map[string]string)
+
+	for len(params) > 0 {
+		params = strings.TrimLeft(params, " ,")
+
+		key, rest, ok := strings.Cut(params, "=")
+		if !ok {
+			break
+		}
+
+		var value string
+
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				break
+			}
+
+			value = rest[1 : end+1]
+			rest = rest[end+2:]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+
+		values[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		params = rest
+	}
+
+	return values
+}
+
+func (a *authenticator) header(method, uri string) string {
+	if a.basic {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(a.user+":"+a.password))
+	}
+
+	ha1 := md5hex(a.user + ":" + a.realm + ":" + a.password)
+	ha2 := md5hex(method + ":" + uri)
+
+	header := fmt.Sprintf(
+		`Digest username="%s", realm="%s", nonce="%s", uri="%s"`,
+		a.user, a.realm, a.nonce, uri,
+	)
+
+	if a.qop == "" {
+		header += fmt.Sprintf(`, response="%s"`, md5hex(ha1+":"+a.nonce+":"+ha2))
+	} else {
+		a.count++
+
+		nc := fmt.Sprintf("%08x", a.count)
+		cnonce := randomHex(8)
+
+		header += fmt.Sprintf(
+			`, qop=auth, nc=%s, cnonce="%s", response="%s"`,
+			nc, cnonce, md5hex(ha1+":"+a.nonce+":"+nc+":"+cnonce+":auth:"+ha2),
+		)
+	}
+
+	if a.opaque != "" {
+		header += fmt.Sprintf(`, opaque="%s"`, a.opaque)
+	}
+
+	return header
+}
+
+func md5hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
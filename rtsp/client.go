@@ -0,0 +1,457 @@
+package rtsp
+
+import (
+	"bufio"
+	"camrec/clock"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultPort           = "554"
+	defaultSessionTimeout = 60 * time.Second
+	userAgent             = "camrec"
+)
+
+type Response struct {
+	StatusCode int
+	Status     string
+	Header     textproto.MIMEHeader
+	Body       []byte
+}
+
+// Client receives H.264 video over RTSP with TCP interleaved transport
+type Client struct {
+	// Timeout limits every request of the session setup
+	Timeout time.Duration
+
+	clock    clock.Clock
+	conn     net.Conn
+	reader   *bufio.Reader
+	writeMu  sync.Mutex
+	url      *url.URL
+	user     string
+	password string
+	auth     *authenticator
+	cseq     int
+
+	session        string
+	sessionTimeout time.Duration
+	contentBase    string
+
+	media        *Media
+	rtpChannel   uint8
+	rtcpChannel  uint8
+	depacketizer *H264Depacketizer
+	frames       []Frame
+
+	ssrc       uint32
+	remoteSSRC atomic.Uint32
+	highestSeq atomic.Uint32
+}
+
+// Dial connects to the RTSP server, credentials are taken from the URL
+func Dial(ctx context.Context, rawURL string) (c *Client, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stream URL: %w", err)
+	}
+
+	if u.Scheme != "rtsp" {
+		return nil, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+	}
+
+	c = &Client{
+		Timeout:        10 * time.Second,
+		clock:          clock.Real,
+		sessionTimeout: defaultSessionTimeout,
+		ssrc:           uint32(time.Now().UnixNano()),
+	}
+
+	if u.User != nil {
+		c.user = u.User.Username()
+		c.password, _ = u.User.Password()
+		u.User = nil
+	}
+
+	c.url = u
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+
+	c.conn = conn
+	c.reader = bufio.NewReaderSize(conn, 64*1024)
+
+	return
+}
+
+// Describe requests session description and selects the H.264 video media
+func (c *Client) Describe() (m *Media, err error) {
+	res, err := c.do("DESCRIBE", c.url.String(), map[string]string{"Accept": "application/sdp"})
+	if err != nil {
+		return
+	}
+
+	c.contentBase = res.Header.Get("Content-Base")
+	if c.contentBase == "" {
+		c.contentBase = c.url.String()
+	}
+
+	media, err := ParseSDP(res.Body)
+	if err != nil {
+		return
+	}
+
+	for i := range media {
+		if media[i].Type != "video" {
+			continue
+		}
+
+		if media[i].Encoding != "H264" {
+			return nil, fmt.Errorf("unsupported video encoding %q: only H.264 is supported", media[i].Encoding)
+		}
+
+		return &media[i], nil
+	}
+
+	return nil, errors.New("no video media found")
+}
+
+// Setup sets up interleaved transport of the media
+func (c *Client) Setup(m *Media) (err error) {
+	res, err := c.do("SETUP", c.controlURL(m.Control), map[string]string{
+		"Transport": "RTP/AVP/TCP;unicast;interleaved=0-1",
+	})
+	if err != nil {
+		return
+	}
+
+	c.rtpChannel, c.rtcpChannel = 0, 1
+
+	for _, param := range strings.Split(res.Header.Get("Transport"), ";") {
+		channels, ok := strings.CutPrefix(param, "interleaved=")
+		if !ok {
+			continue
+		}
+
+		rtp, rtcp, _ := strings.Cut(channels, "-")
+
+		if v, err := strconv.Atoi(rtp); err == nil {
+			c.rtpChannel = uint8(v)
+			c.rtcpChannel = uint8(v + 1)
+		}
+
+		if v, err := strconv.Atoi(rtcp); err == nil {
+			c.rtcpChannel = uint8(v)
+		}
+	}
+
+	session, params, _ := strings.Cut(res.Header.Get("Session"), ";")
+	c.session = strings.TrimSpace(session)
+
+	if timeout, ok := strings.CutPrefix(strings.TrimSpace(params), "timeout="); ok {
+		if v, err := strconv.Atoi(timeout); err == nil && v > 0 {
+			c.sessionTimeout = time.Duration(v) * time.Second
+		}
+	}
+
+	c.media = m
+	c.depacketizer = NewH264Depacketizer(m.ParameterSets())
+
+	return
+}
+
+// Play starts the media delivery
+func (c *Client) Play() (err error) {
+	if c.media == nil {
+		return errors.New("media is not set up")
+	}
+
+	_, err = c.do("PLAY", c.controlURL("*"), map[string]string{"Range": "npt=0.000-"})
+
+	return
+}
+
+// ReadFrame returns the next video frame, RTCP and RTSP messages are skipped
+func (c *Client) ReadFrame() (f Frame, err error) {
+	for len(c.frames) == 0 {
+		channel, data, err := c.readInterleaved()
+		if err != nil {
+			return f, err
+		}
+
+		if channel != c.rtpChannel {
+			continue
+		}
+
+		pkt, err := ParseRTP(data)
+		if err != nil || pkt.PayloadType != c.media.PayloadType {
+			continue
+		}
+
+		c.frames = c.depacketizer.Push(pkt)
+
+		c.remoteSSRC.Store(pkt.SSRC)
+		c.highestSeq.Store(c.depacketizer.ExtendedSequence())
+	}
+
+	f = c.frames[0]
+	c.frames = c.frames[1:]
+
+	return
+}
+
+// SetClock sets the clock of the keepalive, it must be called
+// before Keepalive
+func (c *Client) SetClock(clk clock.Clock) {
+	c.clock = clk
+}
+
+// Keepalive sends RTCP receiver reports until the context is done,
+// so the server doesn't close the session
+func (c *Client) Keepalive(ctx context.Context) {
+	ticker := c.clock.NewTicker(c.KeepaliveInterval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C():
+			if err := c.writeInterleaved(c.rtcpChannel, c.receiverReport()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// KeepaliveInterval returns the half of the session timeout
+func (c *Client) KeepaliveInterval() time.Duration {
+	return c.sessionTimeout / 2
+}
+
+// Close tears down the session and closes the connection
+func (c *Client) Close() error {
+	if c.session != "" {
+		c.writeMu.Lock()
+		c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		c.conn.Write(c.request("TEARDOWN", c.controlURL("*"), nil))
+		c.writeMu.Unlock()
+	}
+
+	return c.conn.Close()
+}
+
+func (c *Client) controlURL(control string) string {
+	if control == "" || control == "*" {
+		return c.contentBase
+	}
+
+	if strings.HasPrefix(control, "rtsp://") {
+		return control
+	}
+
+	return strings.TrimSuffix(c.contentBase, "/") + "/" + control
+}
+
+func (c *Client) request(method, uri string, header map[string]string) []byte {
+	c.cseq++
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s %s RTSP/1.0\r\n", method, uri)
+	fmt.Fprintf(&b, "CSeq: %d\r\n", c.cseq)
+	fmt.Fprintf(&b, "User-Agent: %s\r\n", userAgent)
+
+	if c.session != "" {
+		fmt.Fprintf(&b, "Session: %s\r\n", c.session)
+	}
+
+	if c.auth != nil {
+		fmt.Fprintf(&b, "Authorization: %s\r\n", c.auth.header(method, uri))
+	}
+
+	for k, v := range header {
+		fmt.Fprintf(&b, "%s: %s\r\n", k, v)
+	}
+
+	b.WriteString("\r\n")
+
+	return []byte(b.String())
+}
+
+// do sends the request and waits for the response, the request is repeated
+// once with credentials on authentication challenge
+func (c *Client) do(method, uri string, header map[string]string) (res *Response, err error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		c.conn.SetDeadline(time.Now().Add(c.Timeout))
+
+		c.writeMu.Lock()
+		_, err = c.conn.Write(c.request(method, uri, header))
+		c.writeMu.Unlock()
+
+		if err != nil {
+			return
+		}
+
+		res, err = c.readResponse()
+		if err != nil {
+			return
+		}
+
+		if res.StatusCode != 401 || c.auth != nil || c.user == "" {
+			break
+		}
+
+		c.auth, err = newAuthenticator(res.Header.Get("WWW-Authenticate"), c.user, c.password)
+		if err != nil {
+			return
+		}
+	}
+
+	c.conn.SetDeadline(time.Time{})
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("%s failed: %d %s", method, res.StatusCode, res.Status)
+	}
+
+	return
+}
+
+// readResponse reads RTSP response skipping interleaved frames
+func (c *Client) readResponse() (*Response, error) {
+	for {
+		b, err := c.reader.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+
+		if b[0] == '$' {
+			if _, _, err = c.readFrame(); err != nil {
+				return nil, err
+			}
+
+			continue
+		}
+
+		res, err := c.readMessage()
+		if err != nil || res != nil {
+			return res, err
+		}
+	}
+}
+
+// readMessage reads RTSP message, requests from the server are skipped
+func (c *Client) readMessage() (res *Response, err error) {
+	tp := textproto.NewReader(c.reader)
+
+	line, err := tp.ReadLine()
+	if err != nil {
+		return
+	}
+
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return
+	}
+
+	body := make([]byte, 0)
+
+	if length := header.Get("Content-Length"); length != "" {
+		n, err := strconv.Atoi(length)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid content length %q", length)
+		}
+
+		body = make([]byte, n)
+		if _, err = io.ReadFull(c.reader, body); err != nil {
+			return nil, err
+		}
+	}
+
+	proto, status, ok := strings.Cut(line, " ")
+	if !ok || !strings.HasPrefix(proto, "RTSP/") {
+		return nil, nil
+	}
+
+	code, text, _ := strings.Cut(status, " ")
+
+	res = &Response{
+		Status: text,
+		Header: header,
+		Body:   body,
+	}
+
+	if res.StatusCode, err = strconv.Atoi(code); err != nil {
+		return nil, fmt.Errorf("invalid status line %q", line)
+	}
+
+	return
+}
+
+// readInterleaved returns the next interleaved frame skipping RTSP messages
+func (c *Client) readInterleaved() (channel uint8, data []byte, err error) {
+	for {
+		b, err := c.reader.Peek(1)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if b[0] == '$' {
+			return c.readFrame()
+		}
+
+		if _, err = c.readMessage(); err != nil {
+			return 0, nil, err
+		}
+	}
+}
+
+func (c *Client) readFrame() (channel uint8, data []byte, err error) {
+	header := make([]byte, 4)
+	if _, err = io.ReadFull(c.reader, header); err != nil {
+		return
+	}
+
+	data = make([]byte, binary.BigEndian.Uint16(header[2:]))
+	if _, err = io.ReadFull(c.reader, data); err != nil {
+		return
+	}
+
+	return header[1], data, nil
+}
+
+func (c *Client) writeInterleaved(channel uint8, data []byte) error {
+	frame := []byte{'$', channel}
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(data)))
+	frame = append(frame, data...)
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.Timeout))
+	_, err := c.conn.Write(frame)
+
+	return err
+}
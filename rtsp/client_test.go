@@ -0,0 +1,130 @@
+package rtsp_test
+
+import (
+	"camrec/clock"
+	"camrec/codec"
+	"camrec/rtsp"
+	"camrec/rtsp/rtsptest"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func play(t *testing.T, url string) *rtsp.Client {
+	t.Helper()
+
+	ctx := context.Background()
+
+	c, err := rtsp.Dial(ctx, url)
+	require.NoError(t, err)
+
+	t.Cleanup(func() { c.Close() })
+
+	media, err := c.Describe()
+	require.NoError(t, err)
+	require.Equal(t, "H264", media.Encoding)
+	require.Equal(t, 90000, media.ClockRate)
+
+	require.NoError(t, c.Setup(media))
+	require.NoError(t, c.Play())
+
+	return c
+}
+
+func TestClient(t *testing.T) {
+	t.Run("invalid scheme", func(t *testing.T) {
+		_, err := rtsp.Dial(context.Background(), "http://127.0.0.1/stream")
+		require.Error(t, err)
+	})
+
+	t.Run("frames", func(t *testing.T) {
+		s := rtsptest.NewServer(rtsptest.FixtureVideo(10, 5), 10*time.Millisecond)
+		defer s.Close()
+
+		c := play(t, s.URL)
+
+		f, err := c.ReadFrame()
+		require.NoError(t, err)
+		require.True(t, f.Keyframe)
+
+		// parameter sets from SDP are inserted before the keyframe
+		require.Len(t, f.NALUnits, 3)
+		require.Equal(t, rtsptest.SPS, f.NALUnits[0])
+		require.Equal(t, rtsptest.PPS, f.NALUnits[1])
+		require.Len(t, f.NALUnits[2], 3001)
+		require.True(t, codec.IsKeyframe(codec.H264, f.NALUnits[2]))
+
+		for i := 1; i < 6; i++ {
+			next, err := c.ReadFrame()
+			require.NoError(t, err)
+			require.Equal(t, i%5 == 0, next.Keyframe)
+			require.Equal(t, f.Timestamp+int64(i*900), next.Timestamp)
+		}
+	})
+
+	t.Run("digest authentication", func(t *testing.T) {
+		s := rtsptest.NewServer(rtsptest.FixtureVideo(2, 2), 10*time.Millisecond)
+		defer s.Close()
+
+		s.User = "admin"
+		s.Password = "secret"
+
+		c, err := rtsp.Dial(context.Background(), s.URL)
+		require.NoError(t, err)
+		defer c.Close()
+
+		_, err = c.Describe()
+		require.ErrorContains(t, err, "401")
+
+		c = play(t, strings.Replace(s.URL, "rtsp://", "rtsp://admin:secret@", 1))
+
+		_, err = c.ReadFrame()
+		require.NoError(t, err)
+	})
+
+	t.Run("keepalive", func(t *testing.T) {
+		s := rtsptest.NewServer(rtsptest.FixtureVideo(2, 2), 10*time.Millisecond)
+		defer s.Close()
+
+		s.SessionTimeout = 0
+		c := play(t, s.URL)
+		require.Equal(t, 30*time.Second, c.KeepaliveInterval())
+
+		s2 := rtsptest.NewServer(rtsptest.FixtureVideo(2, 2), 10*time.Millisecond)
+		defer s2.Close()
+
+		s2.SessionTimeout = 1
+		c = play(t, s2.URL)
+		require.Equal(t, 500*time.Millisecond, c.KeepaliveInterval())
+
+		vc := clock.NewVirtual(time.Now())
+		c.SetClock(vc)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		go c.Keepalive(ctx)
+
+		// frames must be read, so the server isn't blocked on writing
+		go func() {
+			for {
+				if _, err := c.ReadFrame(); err != nil {
+					return
+				}
+			}
+		}()
+
+		// no reports are sent until the interval passes
+		require.Eventually(t, func() bool { return vc.Waiters() == 1 }, time.Second, time.Millisecond)
+		require.Never(t, func() bool { return s2.RTCPCount() > 0 }, 50*time.Millisecond, 10*time.Millisecond)
+
+		vc.Advance(c.KeepaliveInterval())
+
+		require.Eventually(t, func() bool {
+			return s2.RTCPCount() > 0
+		}, 3*time.Second, 10*time.Millisecond)
+	})
+}
@@ -0,0 +1,28 @@
+package rtsp
+
+import "encoding/binary"
+
+const rtcpReceiverReport = 201
+
+// receiverReport builds RTCP receiver report with a single report block
+func (c *Client) receiverReport() []byte {
+	rr := []byte{
+		0x80 | 1, // version 2, one report block
+		rtcpReceiverReport,
+	}
+
+	// length in 32 bit words minus one
+	rr = binary.BigEndian.AppendUint16(rr, 7)
+	rr = binary.BigEndian.AppendUint32(rr, c.ssrc)
+
+	rr = binary.BigEndian.AppendUint32(rr, c.remoteSSRC.Load())
+	// fraction lost and cumulative number of packets lost are not tracked
+	rr = binary.BigEndian.AppendUint32(rr, 0)
+	rr = binary.BigEndian.AppendUint32(rr, c.highestSeq.Load())
+	// jitter, last SR and delay since last SR
+	rr = binary.BigEndian.AppendUint32(rr, 0)
+	rr = binary.BigEndian.AppendUint32(rr, 0)
+	rr = binary.BigEndian.AppendUint32(rr, 0)
+
+	return rr
+}
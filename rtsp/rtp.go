@@ -0,0 +1,229 @@
+package rtsp
+
+import (
+	"camrec/codec"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	nalSTAPA = 24
+	nalFUA   = 28
+)
+
+// RTPPacket is a parsed RTP packet
+type RTPPacket struct {
+	Marker         bool
+	PayloadType    uint8
+	SequenceNumber uint16
+	Timestamp      uint32
+	SSRC           uint32
+	Payload        []byte
+}
+
+// ParseRTP parses RTP packet, the payload refers to data
+func ParseRTP(data []byte) (pkt *RTPPacket, err error) {
+	if len(data) < 12 {
+		return nil, errors.New("RTP packet is too short")
+	}
+
+	if data[0]>>6 != 2 {
+		return nil, errors.New("unsupported RTP version")
+	}
+
+	pkt = &RTPPacket{
+		Marker:         data[1]&0x80 != 0,
+		PayloadType:    data[1] & 0x7f,
+		SequenceNumber: binary.BigEndian.Uint16(data[2:]),
+		Timestamp:      binary.BigEndian.Uint32(data[4:]),
+		SSRC:           binary.BigEndian.Uint32(data[8:]),
+	}
+
+	offset := 12 + 4*int(data[0]&0x0f)
+	end := len(data)
+
+	if data[0]&0x10 != 0 {
+		// header extension
+		if offset+4 > end {
+			return nil, errors.New("invalid RTP header extension")
+		}
+
+		offset += 4 + 4*int(binary.BigEndian.Uint16(data[offset+2:]))
+	}
+
+	if data[0]&0x20 != 0 {
+		// padding
+		end -= int(data[end-1])
+	}
+
+	if offset > end {
+		return nil, errors.New("invalid RTP packet length")
+	}
+
+	pkt.Payload = data[offset:end]
+
+	return
+}
+
+// Frame is an access unit assembled from RTP packets
+type Frame struct {
+	NALUnits [][]byte
+	Keyframe bool
+	// Timestamp is the RTP timestamp extended to 64 bits
+	Timestamp int64
+}
+
+// AnnexB returns the frame as Annex-B byte stream
+func (f Frame) AnnexB() []byte {
+	return codec.AccessUnit{NALUnits: f.NALUnits}.AnnexB()
+}
+
+// H264Depacketizer assembles H.264 access units from RTP packets (RFC 6184)
+type H264Depacketizer struct {
+	sps []byte
+	pps []byte
+
+	current   [][]byte
+	timestamp uint32
+	fragment  []byte
+	lastSeq   uint16
+	seqCycles uint32
+	started   bool
+
+	extended int64
+	lastTS   uint32
+}
+
+// NewH264Depacketizer creates depacketizer, out-of-band parameter sets are
+// inserted before keyframes sent without them
+func NewH264Depacketizer(sps, pps []byte) *H264Depacketizer {
+	return &H264Depacketizer{
+		sps: sps,
+		pps: pps,
+	}
+}
+
+// Push consumes RTP packet and returns frames completed by it
+func (d *H264Depacketizer) Push(pkt *RTPPacket) (frames []Frame) {
+	if d.started && pkt.SequenceNumber != d.lastSeq+1 {
+		// fragmented NAL unit can't be restored after the packet loss
+		d.fragment = nil
+	}
+
+	if d.started && pkt.SequenceNumber < d.lastSeq && d.lastSeq-pkt.SequenceNumber > 0x8000 {
+		d.seqCycles += 1 << 16
+	}
+
+	d.lastSeq = pkt.SequenceNumber
+
+	// the previous frame wasn't terminated by the marker
+	if len(d.current) > 0 && pkt.Timestamp != d.timestamp {
+		frames = append(frames, d.flush())
+	}
+
+	d.extend(pkt.Timestamp)
+	d.timestamp = pkt.Timestamp
+	d.started = true
+
+	d.depacketize(pkt.Payload)
+
+	if pkt.Marker && len(d.current) > 0 {
+		frames = append(frames, d.flush())
+	}
+
+	return
+}
+
+// ExtendedSequence returns the highest sequence number for receiver reports
+func (d *H264Depacketizer) ExtendedSequence() uint32 {
+	return d.seqCycles + uint32(d.lastSeq)
+}
+
+// extend unwraps 32 bit RTP timestamp
+func (d *H264Depacketizer) extend(ts uint32) {
+	if !d.started {
+		d.extended = int64(ts)
+	} else {
+		d.extended += int64(int32(ts - d.lastTS))
+	}
+
+	d.lastTS = ts
+}
+
+func (d *H264Depacketizer) depacketize(payload []byte) {
+	if len(payload) == 0 {
+		return
+	}
+
+	switch payload[0] & 0x1f {
+	case nalSTAPA:
+		for data := payload[1:]; len(data) >= 2; {
+			size := int(binary.BigEndian.Uint16(data))
+			data = data[2:]
+
+			if size > len(data) {
+				return
+			}
+
+			d.add(data[:size])
+			data = data[size:]
+		}
+
+	case nalFUA:
+		if len(payload) < 2 {
+			return
+		}
+
+		indicator, header := payload[0], payload[1]
+
+		if header&0x80 != 0 {
+			// start fragment restores the original NAL header
+			d.fragment = append([]byte{indicator&0xe0 | header&0x1f}, payload[2:]...)
+		} else if d.fragment != nil {
+			d.fragment = append(d.fragment, payload[2:]...)
+		}
+
+		if header&0x40 != 0 && d.fragment != nil {
+			d.add(d.fragment)
+			d.fragment = nil
+		}
+
+	default:
+		d.add(payload)
+	}
+}
+
+func (d *H264Depacketizer) add(nal []byte) {
+	if len(nal) == 0 {
+		return
+	}
+
+	// payload buffer is reused by the reader
+	d.current = append(d.current, append([]byte{}, nal...))
+}
+
+func (d *H264Depacketizer) flush() (f Frame) {
+	// the current packet timestamp isn't extended yet when the frame is
+	// terminated by the timestamp change
+	f.Timestamp = d.extended
+
+	hasSPS := false
+
+	for _, nal := range d.current {
+		switch codec.NALType(codec.H264, nal) {
+		case codec.H264NalSPS:
+			hasSPS = true
+		case codec.H264NalIDR:
+			f.Keyframe = true
+		}
+	}
+
+	if f.Keyframe && !hasSPS && d.sps != nil && d.pps != nil {
+		f.NALUnits = append(f.NALUnits, d.sps, d.pps)
+	}
+
+	f.NALUnits = append(f.NALUnits, d.current...)
+	d.current = nil
+
+	return
+}
@@ -0,0 +1,128 @@
+package rtsp_test
+
+import (
+	"camrec/rtsp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRTP(t *testing.T) {
+	t.Run("short", func(t *testing.T) {
+		_, err := rtsp.ParseRTP([]byte{0x80, 0x60})
+		require.Error(t, err)
+	})
+
+	t.Run("with padding and CSRC", func(t *testing.T) {
+		pkt, err := rtsp.ParseRTP([]byte{
+			0xa1, 0xe0, 0x00, 0x07, 0x00, 0x00, 0x03, 0x84, 0x00, 0x00, 0x00, 0x01,
+			0x00, 0x00, 0x00, 0x02, // CSRC
+			0x41, 0x9a, 0x00, 0x02, // payload and padding
+		})
+
+		require.NoError(t, err)
+		require.True(t, pkt.Marker)
+		require.Equal(t, uint8(96), pkt.PayloadType)
+		require.Equal(t, uint16(7), pkt.SequenceNumber)
+		require.Equal(t, uint32(900), pkt.Timestamp)
+		require.Equal(t, []byte{0x41, 0x9a}, pkt.Payload)
+	})
+}
+
+func TestH264Depacketizer(t *testing.T) {
+	sps := []byte{0x67, 0x42}
+	pps := []byte{0x68, 0xce}
+
+	t.Run("STAP-A and FU-A", func(t *testing.T) {
+		d := rtsp.NewH264Depacketizer(nil, nil)
+
+		frames := d.Push(&rtsp.RTPPacket{
+			SequenceNumber: 1,
+			Timestamp:      1000,
+			Payload:        []byte{0x18, 0x00, 0x02, 0x67, 0x42, 0x00, 0x02, 0x68, 0xce},
+		})
+		require.Empty(t, frames)
+
+		frames = d.Push(&rtsp.RTPPacket{
+			SequenceNumber: 2,
+			Timestamp:      1000,
+			Payload:        []byte{0x7c, 0x85, 0x01, 0x02},
+		})
+		require.Empty(t, frames)
+
+		frames = d.Push(&rtsp.RTPPacket{
+			Marker:         true,
+			SequenceNumber: 3,
+			Timestamp:      1000,
+			Payload:        []byte{0x7c, 0x45, 0x03},
+		})
+
+		require.Len(t, frames, 1)
+		require.True(t, frames[0].Keyframe)
+		require.Equal(t, int64(1000), frames[0].Timestamp)
+		require.Equal(t, [][]byte{sps, pps, {0x65, 0x01, 0x02, 0x03}}, frames[0].NALUnits)
+	})
+
+	t.Run("out-of-band parameter sets", func(t *testing.T) {
+		d := rtsp.NewH264Depacketizer(sps, pps)
+
+		frames := d.Push(&rtsp.RTPPacket{Marker: true, SequenceNumber: 1, Timestamp: 0, Payload: []byte{0x65, 0x01}})
+
+		require.Len(t, frames, 1)
+		require.Equal(t, [][]byte{sps, pps, {0x65, 0x01}}, frames[0].NALUnits)
+	})
+
+	t.Run("frame without marker", func(t *testing.T) {
+		d := rtsp.NewH264Depacketizer(nil, nil)
+
+		require.Empty(t, d.Push(&rtsp.RTPPacket{SequenceNumber: 1, Timestamp: 0, Payload: []byte{0x41, 0x01}}))
+
+		frames := d.Push(&rtsp.RTPPacket{Marker: true, SequenceNumber: 2, Timestamp: 3600, Payload: []byte{0x41, 0x02}})
+
+		require.Len(t, frames, 2)
+		require.Equal(t, int64(0), frames[0].Timestamp)
+		require.Equal(t, int64(3600), frames[1].Timestamp)
+	})
+
+	t.Run("timestamp wrap around", func(t *testing.T) {
+		d := rtsp.NewH264Depacketizer(nil, nil)
+
+		d.Push(&rtsp.RTPPacket{Marker: true, SequenceNumber: 1, Timestamp: 0xffffff00, Payload: []byte{0x41}})
+		frames := d.Push(&rtsp.RTPPacket{Marker: true, SequenceNumber: 2, Timestamp: 0x100, Payload: []byte{0x41}})
+
+		require.Equal(t, int64(0xffffff00)+0x200, frames[0].Timestamp)
+	})
+
+	t.Run("lost fragment", func(t *testing.T) {
+		d := rtsp.NewH264Depacketizer(nil, nil)
+
+		d.Push(&rtsp.RTPPacket{SequenceNumber: 1, Timestamp: 0, Payload: []byte{0x7c, 0x85, 0x01}})
+		frames := d.Push(&rtsp.RTPPacket{Marker: true, SequenceNumber: 3, Timestamp: 0, Payload: []byte{0x7c, 0x45, 0x03}})
+
+		require.Empty(t, frames)
+	})
+}
+
+func TestParseSDP(t *testing.T) {
+	media, err := rtsp.ParseSDP([]byte("v=0\r\n" +
+		"m=audio 0 RTP/AVP 8\r\n" +
+		"a=rtpmap:8 PCMA/8000/1\r\n" +
+		"m=video 0 RTP/AVP 96\r\n" +
+		"a=rtpmap:96 H264/90000\r\n" +
+		"a=fmtp:96 packetization-mode=1; sprop-parameter-sets=Z0IAHg==,aM48gA==\r\n" +
+		"a=control:trackID=1\r\n"))
+
+	require.NoError(t, err)
+	require.Len(t, media, 2)
+	require.Equal(t, "PCMA", media[0].Encoding)
+	require.Equal(t, 8000, media[0].ClockRate)
+
+	video := media[1]
+	require.Equal(t, "video", video.Type)
+	require.Equal(t, uint8(96), video.PayloadType)
+	require.Equal(t, "trackID=1", video.Control)
+
+	sps, pps := video.ParameterSets()
+	require.Equal(t, []byte{0x67, 0x42, 0x00, 0x1e}, sps)
+	require.Equal(t, []byte{0x68, 0xce, 0x3c, 0x80}, pps)
+}
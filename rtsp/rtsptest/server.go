@@ -0,0 +1,392 @@
+// Package rtsptest provides in-process RTSP server for tests
+package rtsptest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	maxPayload  = 1200
+	payloadType = 96
+	realm       = "camrec-test"
+	nonce       = "0a4f113b"
+)
+
+var (
+	// SPS of 1280x720 baseline stream
+	SPS = []byte{0x67, 0x42, 0xc0, 0x28, 0xe5, 0x40, 0x28, 0x02, 0xdc, 0x80}
+	PPS = []byte{0x68, 0xce, 0x3c, 0x80}
+)
+
+// Frame is an access unit as a list of NAL units
+type Frame [][]byte
+
+// FixtureVideo returns frames with a keyframe every gop frames,
+// keyframes are large enough to be fragmented, parameter sets are
+// sent out-of-band in SDP
+func FixtureVideo(count, gop int) []Frame {
+	frames := make([]Frame, 0, count)
+
+	for i := 0; i < count; i++ {
+		if i%gop == 0 {
+			idr := append([]byte{0x65}, bytes.Repeat([]byte{byte(i)}, 3000)...)
+			frames = append(frames, Frame{idr})
+			continue
+		}
+
+		slice := append([]byte{0x41, 0x9a}, bytes.Repeat([]byte{byte(i)}, 100)...)
+		frames = append(frames, Frame{slice})
+	}
+
+	return frames
+}
+
+// Server serves fixture frames in a loop to every client
+type Server struct {
+	URL string
+
+	// User and Password enable digest authentication
+	User     string
+	Password string
+
+	// SessionTimeout is announced in the Session header
+	SessionTimeout int
+
+	frames   []Frame
+	interval time.Duration
+	listener net.Listener
+	rtcp     atomic.Int32
+	wg       sync.WaitGroup
+	done     chan struct{}
+}
+
+// NewServer starts the server on the loopback interface
+func NewServer(frames []Frame, interval time.Duration) *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("rtsptest: failed to listen: %v", err))
+	}
+
+	s := &Server{
+		URL:            "rtsp://" + l.Addr().String() + "/stream",
+		SessionTimeout: 60,
+		frames:         frames,
+		interval:       interval,
+		listener:       l,
+		done:           make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return s
+}
+
+// RTCPCount returns the number of RTCP packets received from clients
+func (s *Server) RTCPCount() int {
+	return int(s.rtcp.Load())
+}
+
+func (s *Server) Close() {
+	close(s.done)
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+type session struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+	playing bool
+}
+
+func (ss *session) write(data []byte) error {
+	ss.writeMu.Lock()
+	defer ss.writeMu.Unlock()
+
+	_, err := ss.conn.Write(data)
+
+	return err
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	go func() {
+		<-s.done
+		conn.Close()
+	}()
+
+	ss := &session{conn: conn}
+	r := bufio.NewReader(conn)
+
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return
+		}
+
+		if b[0] == '$' {
+			header := make([]byte, 4)
+			if _, err = io.ReadFull(r, header); err != nil {
+				return
+			}
+
+			if _, err = io.CopyN(io.Discard, r, int64(binary.BigEndian.Uint16(header[2:]))); err != nil {
+				return
+			}
+
+			if header[1] == 1 {
+				s.rtcp.Add(1)
+			}
+
+			continue
+		}
+
+		tp := textproto.NewReader(r)
+
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		header, err := tp.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return
+		}
+
+		if !s.respond(ss, fields[0], fields[1], header) {
+			return
+		}
+	}
+}
+
+func (s *Server) respond(ss *session, method, uri string, req textproto.MIMEHeader) bool {
+	res := map[string]string{
+		"CSeq": req.Get("CSeq"),
+	}
+
+	status := "200 OK"
+	body := ""
+
+	switch {
+	case !s.authorized(method, req.Get("Authorization")):
+		status = "401 Unauthorized"
+		res["WWW-Authenticate"] = fmt.Sprintf(`Digest realm="%s", nonce="%s"`, realm, nonce)
+
+	case method == "OPTIONS":
+		res["Public"] = "OPTIONS, DESCRIBE, SETUP, PLAY, TEARDOWN"
+
+	case method == "DESCRIBE":
+		res["Content-Type"] = "application/sdp"
+		res["Content-Base"] = s.URL + "/"
+
+		body = strings.Join([]string{
+			"v=0",
+			"o=- 0 0 IN IP4 127.0.0.1",
+			"s=camrec test",
+			"t=0 0",
+			"m=video 0 RTP/AVP 96",
+			"a=rtpmap:96 H264/90000",
+			fmt.Sprintf(
+				"a=fmtp:96 packetization-mode=1;sprop-parameter-sets=%s,%s",
+				base64.StdEncoding.EncodeToString(SPS),
+				base64.StdEncoding.EncodeToString(PPS),
+			),
+			"a=control:trackID=0",
+			"",
+		}, "\r\n")
+
+	case method == "SETUP":
+		res["Transport"] = "RTP/AVP/TCP;unicast;interleaved=0-1"
+		res["Session"] = fmt.Sprintf("12345678;timeout=%d", s.SessionTimeout)
+
+	case method == "PLAY":
+		if !ss.playing {
+			ss.playing = true
+			go s.play(ss)
+		}
+
+		res["Session"] = "12345678"
+
+	case method == "TEARDOWN":
+		ss.write(response(status, res, body))
+		return false
+
+	default:
+		status = "405 Method Not Allowed"
+	}
+
+	return ss.write(response(status, res, body)) == nil
+}
+
+func response(status string, header map[string]string, body string) []byte {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "RTSP/1.0 %s\r\n", status)
+
+	for k, v := range header {
+		fmt.Fprintf(&b, "%s: %s\r\n", k, v)
+	}
+
+	if body != "" {
+		fmt.Fprintf(&b, "Content-Length: %d\r\n", len(body))
+	}
+
+	b.WriteString("\r\n")
+	b.WriteString(body)
+
+	return []byte(b.String())
+}
+
+func (s *Server) authorized(method, header string) bool {
+	if s.User == "" {
+		return true
+	}
+
+	params, ok := strings.CutPrefix(header, "Digest ")
+	if !ok {
+		return false
+	}
+
+	values := make(map[string]string)
+
+	for _, p := range strings.Split(params, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+		values[k] = strings.Trim(v, `"`)
+	}
+
+	ha1 := md5hex(s.User + ":" + realm + ":" + s.Password)
+	ha2 := md5hex(method + ":" + values["uri"])
+
+	return values["username"] == s.User &&
+		values["response"] == md5hex(ha1+":"+nonce+":"+ha2)
+}
+
+func md5hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// play sends the frames as RTP packets on the interleaved channel 0
+func (s *Server) play(ss *session) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	seq := uint16(0)
+	ts := uint32(0)
+	step := uint32(s.interval * 90000 / time.Second)
+
+	for i := 0; ; i++ {
+		frame := s.frames[i%len(s.frames)]
+
+		for j, nal := range frame {
+			for _, payload := range packetize(nal) {
+				last := j == len(frame)-1 && payload.last
+
+				if err := ss.write(rtpPacket(seq, ts, last, payload.data)); err != nil {
+					return
+				}
+
+				seq++
+			}
+		}
+
+		ts += step
+
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type payload struct {
+	data []byte
+	last bool
+}
+
+// packetize splits large NAL units into FU-A fragments
+func packetize(nal []byte) (payloads []payload) {
+	if len(nal) <= maxPayload {
+		return []payload{{data: nal, last: true}}
+	}
+
+	indicator := nal[0]&0xe0 | 28
+	typ := nal[0] & 0x1f
+
+	for data, first := nal[1:], true; len(data) > 0; first = false {
+		n := min(len(data), maxPayload)
+
+		header := typ
+		if first {
+			header |= 0x80
+		}
+
+		if n == len(data) {
+			header |= 0x40
+		}
+
+		payloads = append(payloads, payload{
+			data: append([]byte{indicator, header}, data[:n]...),
+			last: n == len(data),
+		})
+
+		data = data[n:]
+	}
+
+	return
+}
+
+func rtpPacket(seq uint16, ts uint32, marker bool, data []byte) []byte {
+	m := uint8(0)
+	if marker {
+		m = 0x80
+	}
+
+	pkt := []byte{0x80, m | payloadType}
+	pkt = binary.BigEndian.AppendUint16(pkt, seq)
+	pkt = binary.BigEndian.AppendUint32(pkt, ts)
+	pkt = binary.BigEndian.AppendUint32(pkt, 0x1234abcd)
+	pkt = append(pkt, data...)
+
+	frame := []byte{'$', 0}
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(pkt)))
+
+	return append(frame, pkt...)
+}
@@ -0,0 +1,102 @@
+package rtsp
+
+import (
+	"camrec/codec"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Media is a media description of the session
+type Media struct {
+	Type        string
+	PayloadType uint8
+	Encoding    string
+	ClockRate   int
+	Control     string
+	Format      map[string]string
+}
+
+// ParseSDP returns media descriptions of the session description
+func ParseSDP(data []byte) (media []Media, err error) {
+	var current *Media
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+
+		switch {
+		case key == "m":
+			fields := strings.Fields(value)
+			if len(fields) < 4 {
+				return nil, errors.New("invalid media description: " + line)
+			}
+
+			pt, err := strconv.Atoi(fields[3])
+			if err != nil {
+				return nil, errors.New("invalid payload type: " + line)
+			}
+
+			media = append(media, Media{
+				Type:        fields[0],
+				PayloadType: uint8(pt),
+				Format:      make(map[string]string),
+			})
+
+			current = &media[len(media)-1]
+
+		case key == "a" && current != nil:
+			attr, attrValue, _ := strings.Cut(value, ":")
+
+			switch attr {
+			case "control":
+				current.Control = attrValue
+
+			case "rtpmap":
+				// 96 H264/90000
+				_, encoding, _ := strings.Cut(attrValue, " ")
+				name, rate, _ := strings.Cut(encoding, "/")
+				rate, _, _ = strings.Cut(rate, "/")
+
+				current.Encoding = strings.ToUpper(name)
+				current.ClockRate, _ = strconv.Atoi(rate)
+
+			case "fmtp":
+				_, params, _ := strings.Cut(attrValue, " ")
+
+				for _, p := range strings.Split(params, ";") {
+					k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+					if k != "" {
+						current.Format[k] = v
+					}
+				}
+			}
+		}
+	}
+
+	return
+}
+
+// ParameterSets decodes H.264 SPS and PPS from sprop-parameter-sets
+func (m Media) ParameterSets() (sps, pps []byte) {
+	for _, v := range strings.Split(m.Format["sprop-parameter-sets"], ",") {
+		nal, err := base64.StdEncoding.DecodeString(v)
+		if err != nil || len(nal) == 0 {
+			continue
+		}
+
+		switch codec.NALType(codec.H264, nal) {
+		case codec.H264NalSPS:
+			sps = nal
+		case codec.H264NalPPS:
+			pps = nal
+		}
+	}
+
+	return
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"camrec/alert"
+	"camrec/api"
+	"camrec/config"
+	"camrec/event"
+	"camrec/mail"
+	"camrec/notify"
+	"camrec/queue"
+	"camrec/recorder"
+	"camrec/snapshot"
+	"camrec/trigger"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// settings are the recorder settings of the environment
+type settings struct {
+	cameras   []config.Camera
+	rules     []alert.Rule
+	mail      mail.Options
+	grace     time.Duration
+	queueFile string
+	apiListen string
+	outputDir string
+	// smtp is nil when the events aren't emailed
+	smtp *notify.SMTPOptions
+	// telegram is nil when the bot isn't configured
+	telegram *notify.TelegramOptions
+	webhooks []notify.WebhookOptions
+	// stale is the time without frames the camera is down after
+	stale time.Duration
+}
+
+// loadSettings reads and validates the settings of the environment
+func loadSettings() (s settings, err error) {
+	if s.cameras, err = config.Cameras(); err != nil {
+		return
+	}
+
+	if s.rules, err = alert.Rules(); err != nil {
+		return
+	}
+
+	s.mail = mailOptions()
+	s.mail.Alerts = alert.Default(s.rules...)
+	s.mail.Cameras = s.cameras
+
+	if s.mail.MaxSkew, err = envDuration("ALERT_MAX_SKEW", 0); err != nil {
+		return
+	}
+
+	if s.grace, err = envDuration("SHUTDOWN_GRACE", defaultShutdownGrace); err != nil {
+		return
+	}
+
+	s.queueFile = envOr("QUEUE_FILE", defaultQueueFile)
+	s.apiListen = envOr("API_LISTEN", api.DefaultListen)
+	s.outputDir = envOr("OUTPUT_DIR", event.OutputDirectory)
+
+	smtp, ok, err := smtpOptions()
+	if err != nil {
+		return
+	}
+
+	if ok {
+		s.smtp = &smtp
+	}
+
+	telegram, ok, err := telegramOptions()
+	if err != nil {
+		return
+	}
+
+	if ok {
+		s.telegram = &telegram
+	}
+
+	if s.webhooks, err = webhookOptions(); err != nil {
+		return
+	}
+
+	if s.stale, err = envDuration("CAMERA_STALE", defaultCameraStale); err != nil {
+		return
+	}
+
+	if s.stale <= 0 {
+		return s, fmt.Errorf("CAMERA_STALE: %s is not positive", s.stale)
+	}
+
+	return
+}
+
+// run records the cameras and saves the events of the triggers
+// until the interrupt
+func run(args []string) (err error) {
+	flags := flag.NewFlagSet("run", flag.ContinueOnError)
+
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	s, err := loadSettings()
+	if err != nil {
+		return fmt.Errorf("configuration failed: %w", err)
+	}
+
+	event.OutputDirectory = s.outputDir
+
+	// the first signal starts the shutdown, the second one kills the process
+	sigctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ctx, cancel := context.WithCancel(sigctx)
+	defer cancel()
+
+	list, bot, err := notifiers(s)
+	if err != nil {
+		return fmt.Errorf("notifier setup failed: %w", err)
+	}
+
+	hooks, err := webhooks(s)
+	if err != nil {
+		return fmt.Errorf("notifier setup failed: %w", err)
+	}
+
+	// the saved events are sent to the webhooks like to the other notifiers
+	for _, w := range hooks {
+		list = append(list, w)
+	}
+
+	m, err := mail.Initialize(s.mail)
+	if err != nil {
+		return fmt.Errorf("mail initialize failed: %w", err)
+	}
+
+	q, err := queue.Open(s.queueFile)
+	if err != nil {
+		return fmt.Errorf("queue open failed: %w", err)
+	}
+
+	defer func() {
+		if closeErr := q.Close(); closeErr != nil {
+			slog.Error("queue close failed", "err", closeErr)
+		}
+	}()
+
+	// the streams outlive the triggers to save the pending events
+	streamCtx, stopStreams := context.WithCancel(context.Background())
+	defer stopStreams()
+
+	rec := recorder.New(streamCtx, s.cameras, bufferSize)
+	rec.Delay = triggerDelay
+	rec.Grace = s.grace
+	rec.Queue = q
+
+	snaps := &snapshots{rec: rec, cache: snapshot.NewCache()}
+	sent := &notifications{notifier: notify.All(list...), webhooks: hooks, snapshots: snaps, mail: m}
+
+	rec.OnHandled = func(t trigger.Trigger, err error) {
+		sent.acknowledge(t, err)
+
+		if err != nil {
+			sent.failed(t, err)
+		}
+	}
+
+	// the alerts without the time are labeled like the failed triggers
+	m.OnInvalid = sent.acknowledge
+
+	// the snapshots are saved with the events even without notifiers
+	rec.OnSaved = sent.send
+
+	if len(hooks) > 0 {
+		rec.OnTrigger = sent.triggered
+
+		go rec.Watch(ctx, s.stale, sent.camera)
+	}
+
+	if err = rec.Start(); err != nil {
+		return fmt.Errorf("streaming start failed: %w", err)
+	}
+
+	// the trigger channel is closed when the message checker and
+	// the API stop
+	tschan := m.StartMessageChecker(ctx, 5*time.Second)
+
+	server := api.NewServer(s.cameras)
+	server.MaxSpan = bufferSize / 2
+	server.Snapshot = snaps.Latest
+
+	go func() {
+		if err := server.Serve(ctx, s.apiListen); err != nil {
+			slog.Error("API failed", "err", err)
+		}
+	}()
+
+	if bot != nil {
+		commands := botCommands{rec: rec, server: server, queue: q, snapshots: snaps, cameras: s.cameras}
+
+		go func() {
+			if err := bot.Serve(ctx, commands); err != nil {
+				slog.Error("Telegram bot failed", "err", err)
+			}
+		}()
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			stop()
+			slog.Info("shutting down, the pending events are saved", "grace", s.grace)
+		case err := <-m.Done:
+			slog.Error("message loop end", "err", err)
+			cancel()
+		}
+	}()
+
+	slog.Info("press ctrl+c to interrupt")
+
+	if err = rec.Run(context.Background(), trigger.Merge(tschan, server.Triggers())); err != nil {
+		err = fmt.Errorf("streaming end: %w", err)
+	}
+
+	cancel()
+	stopStreams()
+
+	waitCtx, cancelWait := context.WithTimeout(context.Background(), streamStopTimeout)
+	defer cancelWait()
+
+	if waitErr := rec.Wait(waitCtx); waitErr != nil {
+		slog.Warn("streams didn't stop", "err", waitErr)
+	}
+
+	sent.Wait()
+
+	slog.Info("stopped", "queued", len(q.Pending()))
+
+	return
+}
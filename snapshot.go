@@ -0,0 +1,65 @@
+package main
+
+import (
+	"camrec/event"
+	"camrec/recorder"
+	"camrec/snapshot"
+	"camrec/trigger"
+	"context"
+	"log/slog"
+	"os"
+)
+
+// snapshots decodes the snapshots of the saved events and the buffered
+// video, the latest snapshot of every camera is cached
+type snapshots struct {
+	rec   *recorder.Recorder
+	cache *snapshot.Cache
+}
+
+// Latest returns the snapshot of the last buffered keyframe of the camera,
+// the cached snapshot is returned when the frame can't be decoded
+func (s *snapshots) Latest(ctx context.Context, camera string) (snapshot.Image, error) {
+	cached, ok := s.cache.Get(camera)
+
+	e, err := s.rec.Peek(camera, trigger.Trigger{})
+	if err == nil {
+		// the keyframe is decoded once
+		if _, at, found := e.Keyframe(e.End()); found && ok && !at.After(cached.Time) {
+			return cached, nil
+		}
+
+		var img snapshot.Image
+
+		if img, err = snapshot.FromEvent(ctx, e, e.End()); err == nil {
+			s.cache.Put(camera, img)
+			return img, nil
+		}
+	}
+
+	if ok {
+		slog.Warn("snapshot failed, the cached one is taken", "camera", camera, "err", err)
+		return cached, nil
+	}
+
+	return snapshot.Image{}, err
+}
+
+// save decodes the snapshot of the keyframe closest to the trigger time,
+// saves it next to the event file and caches it, the image is nil when
+// the frame can't be decoded
+func (s *snapshots) save(ctx context.Context, t trigger.Trigger, e *event.Event) []byte {
+	img, err := snapshot.FromEvent(ctx, e, t.Time)
+	if err != nil {
+		slog.Warn("snapshot failed", "camera", e.Camera(), "event", e.ID(), "err", err)
+		return nil
+	}
+
+	if err = os.WriteFile(event.SnapshotFileName(e.File()), img.Data, 0644); err != nil {
+		slog.Warn("snapshot save failed", "camera", e.Camera(), "event", e.ID(), "err", err)
+	}
+
+	s.cache.Put(e.Camera(), img)
+
+	return img.Data
+}
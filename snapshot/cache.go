@@ -0,0 +1,35 @@
+package snapshot
+
+import "sync"
+
+// Cache keeps the latest image of every camera
+type Cache struct {
+	lock   sync.RWMutex
+	images map[string]Image
+}
+
+func NewCache() *Cache {
+	return &Cache{images: make(map[string]Image)}
+}
+
+// Put caches the image of the camera unless the cached one is later
+func (c *Cache) Put(camera string, img Image) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if cached, ok := c.images[camera]; ok && cached.Time.After(img.Time) {
+		return
+	}
+
+	c.images[camera] = img
+}
+
+// Get returns the latest image of the camera
+func (c *Cache) Get(camera string) (img Image, ok bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	img, ok = c.images[camera]
+
+	return
+}
@@ -0,0 +1,92 @@
+// Package snapshot decodes the video frames into JPEG images
+// with the one-shot ffmpeg process
+package snapshot
+
+import (
+	"bytes"
+	"camrec/codec"
+	"camrec/event"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Command is the ffmpeg binary
+var Command = "ffmpeg"
+
+// timeout limits the decoding of the single frame
+const timeout = 10 * time.Second
+
+// Image is the JPEG image of the frame
+type Image struct {
+	Data []byte
+	// Time is the timestamp of the frame
+	Time time.Time
+}
+
+// FromEvent decodes the event keyframe closest to the time
+func FromEvent(ctx context.Context, e *event.Event, ts time.Time) (Image, error) {
+	keyframe, at, ok := e.Keyframe(ts)
+	if !ok {
+		return Image{}, errors.New("no keyframe in the event")
+	}
+
+	data, err := JPEG(ctx, e.Format().Codec, keyframe)
+	if err != nil {
+		return Image{}, err
+	}
+
+	return Image{Data: data, Time: at}, nil
+}
+
+// JPEG decodes the keyframe of the Annex-B stream into the JPEG image
+func JPEG(ctx context.Context, c codec.Codec, keyframe []byte) ([]byte, error) {
+	var format string
+
+	switch c {
+	case codec.H264:
+		format = "h264"
+	case codec.H265:
+		format = "hevc"
+	default:
+		return nil, fmt.Errorf("unsupported codec %q", c)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, Command,
+		"-v", "error",
+		"-f", format,
+		"-i", "-",
+		"-frames:v", "1",
+		"-f", "image2",
+		"-c:v", "mjpeg",
+		"-q:v", "4",
+		"-",
+	)
+
+	var stdout, stderr bytes.Buffer
+
+	cmd.Stdin = bytes.NewReader(keyframe)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+
+		return nil, fmt.Errorf("snapshot decode failed: %w", err)
+	}
+
+	// the JPEG start of image marker
+	if !bytes.HasPrefix(stdout.Bytes(), []byte{0xff, 0xd8}) {
+		return nil, errors.New("snapshot decode failed: no image")
+	}
+
+	return stdout.Bytes(), nil
+}
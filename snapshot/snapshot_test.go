@@ -0,0 +1,103 @@
+package snapshot_test
+
+import (
+	"camrec/codec"
+	"camrec/event"
+	"camrec/snapshot"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeFfmpeg replaces ffmpeg with the shell script
+func fakeFfmpeg(t *testing.T, script string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "ffmpeg")
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755))
+
+	command := snapshot.Command
+	snapshot.Command = path
+
+	t.Cleanup(func() { snapshot.Command = command })
+}
+
+func TestJPEG(t *testing.T) {
+	t.Run("decoded", func(t *testing.T) {
+		// the input is checked to be passed to stdin
+		fakeFfmpeg(t, `test "$(cat | od -An -tx1 | tr -d ' \n')" = "0000000165" || exit 1
+printf '\377\330jpeg'`)
+
+		data, err := snapshot.JPEG(context.Background(), codec.H264, []byte{0, 0, 0, 1, 0x65})
+		require.NoError(t, err)
+		require.Equal(t, []byte("\xff\xd8jpeg"), data)
+	})
+
+	t.Run("failed", func(t *testing.T) {
+		fakeFfmpeg(t, `echo "invalid data found" >&2; exit 1`)
+
+		_, err := snapshot.JPEG(context.Background(), codec.H265, []byte{1})
+		require.ErrorContains(t, err, "invalid data found")
+	})
+
+	t.Run("no image", func(t *testing.T) {
+		fakeFfmpeg(t, `cat > /dev/null`)
+
+		_, err := snapshot.JPEG(context.Background(), codec.H264, []byte{1})
+		require.ErrorContains(t, err, "no image")
+	})
+
+	t.Run("unsupported codec", func(t *testing.T) {
+		_, err := snapshot.JPEG(context.Background(), codec.Codec("mjpeg"), nil)
+		require.Error(t, err)
+	})
+}
+
+func TestFromEvent(t *testing.T) {
+	fakeFfmpeg(t, `cat > /dev/null; printf '\377\330jpeg'`)
+
+	now := time.Now()
+	idr := []byte{0, 0, 0, 1, 0x65, 0x88}
+	frame := []byte{0, 0, 0, 1, 0x41, 0x9a}
+
+	e := event.NewEvent(now, idr, frame)
+
+	_, err := snapshot.FromEvent(context.Background(), e, now)
+	require.Error(t, err)
+
+	e.SetFormat(event.Format{Codec: codec.H264})
+	e.SetFrames([]event.Frame{
+		{Offset: 0, Length: len(idr), Timestamp: now, Keyframe: true},
+		{Offset: len(idr), Length: len(frame), Timestamp: now.Add(time.Second)},
+	})
+
+	img, err := snapshot.FromEvent(context.Background(), e, now.Add(time.Second))
+	require.NoError(t, err)
+	require.Equal(t, []byte("\xff\xd8jpeg"), img.Data)
+	require.Equal(t, now, img.Time)
+}
+
+func TestCache(t *testing.T) {
+	now := time.Now()
+	c := snapshot.NewCache()
+
+	_, ok := c.Get("front")
+	require.False(t, ok)
+
+	c.Put("front", snapshot.Image{Data: []byte("later"), Time: now})
+	c.Put("front", snapshot.Image{Data: []byte("earlier"), Time: now.Add(-time.Minute)})
+	c.Put("back", snapshot.Image{Data: []byte("back"), Time: now})
+
+	img, ok := c.Get("front")
+	require.True(t, ok)
+	require.Equal(t, []byte("later"), img.Data)
+
+	c.Put("front", snapshot.Image{Data: []byte("latest"), Time: now.Add(time.Minute)})
+
+	img, _ = c.Get("front")
+	require.Equal(t, []byte("latest"), img.Data)
+}
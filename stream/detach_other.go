@@ -0,0 +1,7 @@
+//go:build !unix
+
+package stream
+
+import "os/exec"
+
+func detach(cmd *exec.Cmd) {}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package stream
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detach moves the process to its own group, so the interrupt of the
+// terminal doesn't stop it before the recorder finishes the events
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
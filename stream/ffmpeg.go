@@ -1,61 +1,94 @@
 package stream
 
 import (
-	"camrec/buffer"
+	"camrec/config"
+	"camrec/mpegts"
 	"context"
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 )
 
+// maxTimestampDrift re-anchors stream timestamps after discontinuity
+const maxTimestampDrift = 10 * time.Second
+
+// stopTimeout is the time ffmpeg has to exit after SIGINT before it's killed
+const stopTimeout = 5 * time.Second
+
 type FfmpegStreamer struct {
+	*recording
+
 	ctx    context.Context
 	cmd    *exec.Cmd
 	stdout io.ReadCloser
-	buf    *buffer.Buffer
-	lock   sync.Mutex
-	done   chan error
 }
 
-func NewFfmpegStreamer(ctx context.Context, bufferSize time.Duration) StreamingProcess {
+func NewFfmpegStreamer(ctx context.Context, camera config.Camera, bufferSize time.Duration) StreamingProcess {
 	return &FfmpegStreamer{
-		ctx:  ctx,
-		buf:  buffer.NewBuffer(bufferSize),
-		lock: sync.Mutex{},
-		done: make(chan error, 1),
+		recording: newRecording(camera, bufferSize),
+		ctx:       ctx,
 	}
 }
 
 func (p *FfmpegStreamer) Start() (err error) {
-	url := os.Getenv("STREAM")
+	url := p.camera.Stream
 
 	if url == "" {
 		err = errors.New("no stream URL")
 		return
 	}
 
+	info, err := Probe(p.ctx, url)
+	if err != nil {
+		return fmt.Errorf("stream probe failed: %w", err)
+	}
+
+	slog.Debug("stream parameters", "camera", p.camera.Name(), "info", info)
+
+	if err = info.Validate(); err != nil {
+		return
+	}
+
+	p.info = info
+
+	// MPEG-TS keeps presentation timestamps of the video and audio frames
 	cmdArgs := []string{
 		"ffmpeg",
 		"-i",
 		url,
 		"-v",
 		"0",
-		"-f",
-		"h264",
-		"-c",
+		"-map",
+		"0:v:0",
+		"-c:v",
 		"copy",
-		"-",
 	}
 
-	log.Printf("start streamer process: %s", strings.Join(cmdArgs, " "))
+	if p.audioEnabled() {
+		p.audio = p.newBuffer()
+
+		// G.711 and other codecs are not widely supported in MP4,
+		// so they are transcoded to AAC
+		audioCodec := "aac"
+		if info.AudioCodec == "aac" {
+			audioCodec = "copy"
+		}
+
+		cmdArgs = append(cmdArgs, "-map", "0:a:0", "-c:a", audioCodec)
+	}
+
+	cmdArgs = append(cmdArgs, "-f", "mpegts", "-")
+
+	slog.Debug("start streamer process", "cmd", strings.Join(cmdArgs, " "))
 
 	p.cmd = exec.Command(cmdArgs[0], cmdArgs[1:]...)
+	detach(p.cmd)
 
 	stdout, err := p.cmd.StdoutPipe()
 	if err != nil {
@@ -68,7 +101,7 @@ func (p *FfmpegStreamer) Start() (err error) {
 		return
 	}
 
-	log.Printf("streamer process was started: PID %d", p.cmd.Process.Pid)
+	slog.Info("streamer process was started", "camera", p.camera.Name(), "pid", p.cmd.Process.Pid)
 
 	// wait a little till ffmpeg starts write to the stdout
 	time.Sleep(100 * time.Millisecond)
@@ -77,80 +110,81 @@ func (p *FfmpegStreamer) Start() (err error) {
 		return
 	}
 
-	go p.startStatisticsLoop(30 * time.Second)
-	go p.startStreamingLoop()
+	exited := make(chan struct{})
+
+	go p.startStatisticsLoop(p.ctx, 30*time.Second)
+	go p.startStreamingLoop(exited)
+	go p.stop(exited)
 
 	return
 }
 
-func (p *FfmpegStreamer) HandleTimestamp(ts time.Time) (err error) {
-	p.lock.Lock()
-	event := p.buf.Search(ts)
-	p.lock.Unlock()
-
-	if event != nil {
-		if err = event.SaveFile(); err != nil {
-			err = fmt.Errorf("event file save failed: %w", err)
-			return
-		}
+// stop interrupts ffmpeg when the context is done, so it finishes the
+// output, the process is killed when it doesn't exit in time
+func (p *FfmpegStreamer) stop(exited chan struct{}) {
+	select {
+	case <-exited:
+		return
+	case <-p.ctx.Done():
 	}
 
-	return
-}
+	p.cmd.Process.Signal(os.Interrupt)
 
-func (p *FfmpegStreamer) Done() chan error {
-	return p.done
+	select {
+	case <-exited:
+	case <-time.After(stopTimeout):
+		slog.Warn("streamer process didn't stop, kill it", "camera", p.camera.Name(), "timeout", stopTimeout)
+		p.cmd.Process.Kill()
+	}
 }
 
-func (p *FfmpegStreamer) startStatisticsLoop(interval time.Duration) {
-	ticker := time.NewTicker(interval)
+func (p *FfmpegStreamer) audioEnabled() bool {
+	enabled, _ := strconv.ParseBool(config.Get(p.camera.ID, "AUDIO"))
 
-	for {
-		select {
-		case <-p.ctx.Done():
-			return
-		case <-ticker.C:
-			log.Printf(
-				"chunk count %d, size %d, duration %f sec (usage %.2f%%)",
-				p.buf.Count(), p.buf.Size(),
-				p.buf.Duration().Seconds(), p.buf.Usage(),
-			)
-		}
+	if enabled && !p.info.HasAudio {
+		slog.Warn("audio recording is enabled, but the stream has no audio", "camera", p.camera.Name())
+		return false
 	}
+
+	return enabled
 }
 
-func (p *FfmpegStreamer) startStreamingLoop() {
+// startStreamingLoop reads the output until ffmpeg exits, the process
+// is waited after the output is closed
+func (p *FfmpegStreamer) startStreamingLoop(exited chan struct{}) {
+	demuxer := mpegts.NewDemuxer(p.stdout)
+	timeline := mpegts.Timeline{MaxDrift: maxTimestampDrift}
+
 	for {
-		if err := p.checkProcessState(); err != nil {
+		pkt, err := demuxer.ReadPacket()
+		if err != nil {
+			waitErr := p.cmd.Wait()
+			close(exited)
+
+			switch {
+			case p.ctx.Err() != nil:
+				err = p.ctx.Err()
+			case waitErr != nil:
+				err = fmt.Errorf("streamer process exited: %w", waitErr)
+			}
+
 			p.done <- err
 			return
 		}
 
-		chunk := make([]byte, 1024*1024)
-
-		n, err := p.stdout.Read(chunk)
-		if n == 0 {
+		if pkt.Timestamp() == mpegts.NoTimestamp {
 			continue
 		}
 
-		if err != nil {
-			p.done <- err
-			return
-		}
+		ts := timeline.Time(pkt.Timestamp(), p.clock.Now())
 
-		select {
-		case <-p.ctx.Done():
-			p.cmd.Process.Signal(os.Interrupt)
-			p.done <- p.ctx.Err()
-			return
+		switch pkt.StreamType {
+		case mpegts.StreamTypeH264, mpegts.StreamTypeH265:
+			p.putVideo(pkt.Data, ts)
 
-		default:
+		case mpegts.StreamTypeAAC:
+			p.putAudio(pkt.Data, ts)
 		}
-
-		p.lock.Lock()
-		p.buf.Trim()
-		p.buf.Push(chunk[:n])
-		p.lock.Unlock()
 	}
 }
 
@@ -0,0 +1,369 @@
+package stream
+
+import (
+	"bufio"
+	"camrec/codec"
+	"camrec/config"
+	"camrec/mpegts"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultFileFrameRate is used for raw streams without FPS setting
+const defaultFileFrameRate = 25
+
+// FileStreamer reads the camera stream from a file, a named pipe or
+// the standard input ("-"), the stream is either MPEG-TS or raw H.264/H.265
+// Annex-B byte stream
+type FileStreamer struct {
+	*recording
+
+	ctx      context.Context
+	file     *os.File
+	reader   frameReader
+	realtime bool
+	loop     bool
+	pending  []*frame
+}
+
+// frame is a video access unit or ADTS frames of the stream
+type frame struct {
+	video bool
+	data  []byte
+	// ticks is 90 kHz timestamp
+	ticks int64
+}
+
+type frameReader interface {
+	readFrame() (*frame, error)
+	codec() codec.Codec
+}
+
+func NewFileStreamer(ctx context.Context, camera config.Camera, bufferSize time.Duration) StreamingProcess {
+	realtime, _ := strconv.ParseBool(config.Get(camera.ID, "REALTIME"))
+	loop, _ := strconv.ParseBool(config.Get(camera.ID, "LOOP"))
+
+	return &FileStreamer{
+		recording: newRecording(camera, bufferSize),
+		ctx:       ctx,
+		realtime:  realtime,
+		loop:      loop,
+	}
+}
+
+func (p *FileStreamer) Start() (err error) {
+	if p.camera.Stream == "" {
+		return errors.New("no stream file")
+	}
+
+	if p.loop && !p.realtime {
+		return errors.New("looping requires real-time pace")
+	}
+
+	if p.camera.Stream == "-" {
+		p.file = os.Stdin
+	} else if p.file, err = os.Open(p.camera.Stream); err != nil {
+		return
+	}
+
+	if p.loop && !p.seekable() {
+		p.file.Close()
+		return errors.New("looping requires regular file")
+	}
+
+	if err = p.open(); err != nil {
+		p.file.Close()
+		return
+	}
+
+	slog.Debug("stream parameters", "camera", p.camera.Name(), "info", p.info)
+
+	go func() {
+		<-p.ctx.Done()
+		p.file.Close()
+	}()
+
+	go p.startStatisticsLoop(p.ctx, 30*time.Second)
+	go p.startStreamingLoop()
+
+	return
+}
+
+func (p *FileStreamer) seekable() bool {
+	stat, err := p.file.Stat()
+
+	return err == nil && stat.Mode().IsRegular()
+}
+
+// open detects the stream format and reads frames until the first video
+// frame to get the stream parameters
+func (p *FileStreamer) open() (err error) {
+	r := bufio.NewReaderSize(p.file, 64*1024)
+
+	head, err := r.Peek(1)
+	if err != nil {
+		return fmt.Errorf("stream read failed: %w", err)
+	}
+
+	isTS := head[0] == 0x47
+
+	if isTS {
+		p.reader = &tsReader{demuxer: mpegts.NewDemuxer(r)}
+	} else {
+		p.reader, err = p.annexBReader(r)
+		if err != nil {
+			return
+		}
+	}
+
+	p.pending = nil
+
+	for {
+		f, err := p.reader.readFrame()
+		if err != nil {
+			return fmt.Errorf("no video in the stream: %w", err)
+		}
+
+		p.pending = append(p.pending, f)
+
+		if f.video {
+			break
+		}
+	}
+
+	if p.info != nil {
+		return
+	}
+
+	p.info = &StreamInfo{
+		VideoCodec: string(p.reader.codec()),
+	}
+
+	p.info.FrameRate, _ = strconv.ParseFloat(config.Get(p.camera.ID, "FPS"), 64)
+
+	p.parseParameterSets(p.pending[len(p.pending)-1].data)
+
+	enabled, _ := strconv.ParseBool(config.Get(p.camera.ID, "AUDIO"))
+
+	if enabled && isTS {
+		p.audio = p.newBuffer()
+		p.info.HasAudio = true
+		p.info.AudioCodec = "aac"
+	}
+
+	return
+}
+
+func (p *FileStreamer) annexBReader(r io.Reader) (*annexBReader, error) {
+	c := codec.Codec(config.Get(p.camera.ID, "CODEC"))
+
+	if c == "" {
+		switch strings.ToLower(filepath.Ext(p.camera.Stream)) {
+		case ".h265", ".hevc", ".265":
+			c = codec.H265
+		default:
+			c = codec.H264
+		}
+	}
+
+	if c != codec.H264 && c != codec.H265 {
+		return nil, fmt.Errorf("unsupported video codec %q: only H.264 and H.265 streams are supported", c)
+	}
+
+	frameRate, _ := strconv.ParseFloat(config.Get(p.camera.ID, "FPS"), 64)
+	if frameRate <= 0 {
+		frameRate = defaultFileFrameRate
+	}
+
+	return &annexBReader{
+		r:         r,
+		splitter:  codec.NewSplitter(c),
+		c:         c,
+		frameRate: frameRate,
+	}, nil
+}
+
+// parseParameterSets takes the video dimensions from SPS of the access unit
+func (p *FileStreamer) parseParameterSets(data []byte) {
+	c := p.reader.codec()
+
+	for _, nal := range codec.SplitNALUnits(data) {
+		switch {
+		case c == codec.H264 && codec.NALType(c, nal) == codec.H264NalSPS:
+			if sps, err := codec.ParseH264SPS(nal); err == nil {
+				p.info.Width, p.info.Height = sps.Width, sps.Height
+			}
+
+		case c == codec.H265 && codec.NALType(c, nal) == codec.H265NalSPS:
+			if sps, err := codec.ParseHEVCSPS(nal); err == nil {
+				p.info.Width, p.info.Height = sps.Width, sps.Height
+			}
+		}
+	}
+}
+
+func (p *FileStreamer) startStreamingLoop() {
+	var (
+		timeline mpegts.Timeline
+		last     time.Time
+		lastTick int64
+		gap      time.Duration
+	)
+
+	anchor := p.clock.Now()
+
+	for {
+		f, err := p.readFrame()
+
+		if p.ctx.Err() != nil {
+			p.done <- p.ctx.Err()
+			return
+		}
+
+		if errors.Is(err, io.EOF) && p.loop {
+			if _, err = p.file.Seek(0, io.SeekStart); err == nil {
+				err = p.open()
+			}
+
+			if err == nil {
+				// the next loop continues after the last frame
+				timeline = mpegts.Timeline{}
+				anchor = last.Add(gap)
+
+				continue
+			}
+		}
+
+		if err != nil {
+			p.done <- err
+			return
+		}
+
+		ts := timeline.Time(f.ticks, anchor)
+
+		if p.realtime {
+			select {
+			case <-p.ctx.Done():
+				p.done <- p.ctx.Err()
+				return
+
+			case <-p.clock.After(ts.Sub(p.clock.Now())):
+			}
+		}
+
+		if f.video {
+			if !last.IsZero() && f.ticks > lastTick {
+				gap = mpegts.Duration(f.ticks - lastTick)
+			}
+
+			last, lastTick = ts, f.ticks
+
+			p.putVideo(f.data, ts)
+		} else {
+			p.putAudio(f.data, ts)
+		}
+	}
+}
+
+func (p *FileStreamer) readFrame() (*frame, error) {
+	if len(p.pending) > 0 {
+		f := p.pending[0]
+		p.pending = p.pending[1:]
+
+		return f, nil
+	}
+
+	return p.reader.readFrame()
+}
+
+type tsReader struct {
+	demuxer *mpegts.Demuxer
+	c       codec.Codec
+}
+
+func (r *tsReader) readFrame() (*frame, error) {
+	for {
+		pkt, err := r.demuxer.ReadPacket()
+		if err != nil {
+			return nil, err
+		}
+
+		if pkt.Timestamp() == mpegts.NoTimestamp {
+			continue
+		}
+
+		switch pkt.StreamType {
+		case mpegts.StreamTypeH264:
+			r.c = codec.H264
+		case mpegts.StreamTypeH265:
+			r.c = codec.H265
+		}
+
+		return &frame{
+			video: pkt.StreamType != mpegts.StreamTypeAAC,
+			data:  pkt.Data,
+			ticks: pkt.Timestamp(),
+		}, nil
+	}
+}
+
+func (r *tsReader) codec() codec.Codec {
+	return r.c
+}
+
+// annexBReader splits raw stream into access units with timestamps
+// of the constant frame rate
+type annexBReader struct {
+	r         io.Reader
+	splitter  *codec.Splitter
+	c         codec.Codec
+	frameRate float64
+	units     []codec.AccessUnit
+	count     int64
+	eof       bool
+}
+
+func (r *annexBReader) readFrame() (*frame, error) {
+	buf := make([]byte, 64*1024)
+
+	for len(r.units) == 0 {
+		if r.eof {
+			return nil, io.EOF
+		}
+
+		n, err := r.r.Read(buf)
+		r.units = r.splitter.Write(buf[:n])
+
+		if errors.Is(err, io.EOF) {
+			r.eof = true
+			r.units = append(r.units, r.splitter.Flush()...)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	au := r.units[0]
+	r.units = r.units[1:]
+
+	f := &frame{
+		video: true,
+		data:  au.AnnexB(),
+		ticks: int64(float64(r.count) * mpegts.ClockRate / r.frameRate),
+	}
+
+	r.count++
+
+	return f, nil
+}
+
+func (r *annexBReader) codec() codec.Codec {
+	return r.c
+}
@@ -0,0 +1,160 @@
+package stream
+
+import (
+	"camrec/codec"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StreamInfo describes the stream parameters reported by ffprobe
+type StreamInfo struct {
+	VideoCodec string  `json:"video_codec"`
+	Profile    string  `json:"profile,omitempty"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	FrameRate  float64 `json:"fps"`
+	HasAudio   bool    `json:"audio"`
+	AudioCodec string  `json:"audio_codec,omitempty"`
+}
+
+type probeOutput struct {
+	Streams []struct {
+		CodecType    string `json:"codec_type"`
+		CodecName    string `json:"codec_name"`
+		Profile      string `json:"profile"`
+		Width        int    `json:"width"`
+		Height       int    `json:"height"`
+		AvgFrameRate string `json:"avg_frame_rate"`
+		RFrameRate   string `json:"r_frame_rate"`
+	} `json:"streams"`
+}
+
+var probeTimeout = 30 * time.Second
+
+// Probe runs ffprobe against the stream URL and returns the stream parameters
+func Probe(ctx context.Context, url string) (info *StreamInfo, err error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(
+		ctx,
+		"ffprobe",
+		"-v",
+		"error",
+		"-print_format",
+		"json",
+		"-show_streams",
+		url,
+	)
+
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			err = fmt.Errorf("%w: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	return ParseProbe(out)
+}
+
+// ParseProbe builds stream parameters from the ffprobe JSON output
+func ParseProbe(data []byte) (info *StreamInfo, err error) {
+	var out probeOutput
+
+	if err = json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("unable to parse ffprobe output: %w", err)
+	}
+
+	info = &StreamInfo{}
+
+	for _, s := range out.Streams {
+		switch s.CodecType {
+		case "video":
+			if info.VideoCodec != "" {
+				continue
+			}
+
+			info.VideoCodec = s.CodecName
+			info.Profile = s.Profile
+			info.Width = s.Width
+			info.Height = s.Height
+
+			info.FrameRate = parseFrameRate(s.AvgFrameRate)
+			if info.FrameRate == 0 {
+				info.FrameRate = parseFrameRate(s.RFrameRate)
+			}
+
+		case "audio":
+			if info.HasAudio {
+				continue
+			}
+
+			info.HasAudio = true
+			info.AudioCodec = s.CodecName
+		}
+	}
+
+	if info.VideoCodec == "" {
+		return nil, errors.New("no video stream found")
+	}
+
+	return
+}
+
+// Validate checks that the video stream can be recorded
+func (i StreamInfo) Validate() error {
+	switch i.Codec() {
+	case codec.H264, codec.H265:
+		return nil
+	}
+
+	return fmt.Errorf(
+		"unsupported video codec %q: only H.264 and H.265 streams are supported",
+		i.VideoCodec,
+	)
+}
+
+func (i StreamInfo) Codec() codec.Codec {
+	return codec.Codec(i.VideoCodec)
+}
+
+func (i StreamInfo) String() string {
+	audio := "no audio"
+	if i.HasAudio {
+		audio = "audio " + i.AudioCodec
+	}
+
+	return fmt.Sprintf(
+		"%s (%s) %dx%d @ %.2f fps, %s",
+		i.VideoCodec, i.Profile, i.Width, i.Height, i.FrameRate, audio,
+	)
+}
+
+func parseFrameRate(rate string) float64 {
+	num, den, found := strings.Cut(rate, "/")
+
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+
+	if !found {
+		return n
+	}
+
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+
+	return n / d
+}
@@ -0,0 +1,81 @@
+package stream_test
+
+import (
+	"camrec/stream"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProbe(t *testing.T) {
+	t.Run("invalid json", func(t *testing.T) {
+		_, err := stream.ParseProbe([]byte("{"))
+		require.Error(t, err)
+	})
+
+	t.Run("no video", func(t *testing.T) {
+		_, err := stream.ParseProbe([]byte(`{"streams":[{"codec_type":"audio","codec_name":"aac"}]}`))
+		require.Error(t, err)
+	})
+
+	t.Run("video and audio", func(t *testing.T) {
+		info, err := stream.ParseProbe([]byte(`{
+			"streams": [
+				{
+					"index": 0,
+					"codec_name": "h264",
+					"profile": "Main",
+					"codec_type": "video",
+					"width": 1920,
+					"height": 1080,
+					"r_frame_rate": "25/1",
+					"avg_frame_rate": "0/0"
+				},
+				{
+					"index": 1,
+					"codec_name": "pcm_alaw",
+					"codec_type": "audio",
+					"r_frame_rate": "0/0",
+					"avg_frame_rate": "0/0"
+				}
+			]
+		}`))
+
+		require.NoError(t, err)
+		require.Equal(t, stream.StreamInfo{
+			VideoCodec: "h264",
+			Profile:    "Main",
+			Width:      1920,
+			Height:     1080,
+			FrameRate:  25,
+			HasAudio:   true,
+			AudioCodec: "pcm_alaw",
+		}, *info)
+		require.NoError(t, info.Validate())
+	})
+
+	t.Run("video only", func(t *testing.T) {
+		info, err := stream.ParseProbe([]byte(`{
+			"streams": [
+				{
+					"codec_name": "hevc",
+					"profile": "Main",
+					"codec_type": "video",
+					"width": 2560,
+					"height": 1440,
+					"avg_frame_rate": "30000/1001"
+				}
+			]
+		}`))
+
+		require.NoError(t, err)
+		require.False(t, info.HasAudio)
+		require.InDelta(t, 29.97, info.FrameRate, 0.01)
+		require.NoError(t, info.Validate())
+	})
+}
+
+func TestValidate(t *testing.T) {
+	info := stream.StreamInfo{VideoCodec: "mjpeg"}
+	require.ErrorContains(t, info.Validate(), "mjpeg")
+}
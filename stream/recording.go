@@ -0,0 +1,208 @@
+package stream
+
+import (
+	"camrec/buffer"
+	"camrec/clock"
+	"camrec/codec"
+	"camrec/config"
+	"camrec/event"
+	"camrec/trigger"
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// recording keeps the buffered video and audio of a camera,
+// it is shared by the streaming process implementations
+type recording struct {
+	camera     config.Camera
+	bufferSize time.Duration
+	buf        *buffer.Buffer
+	audio      *buffer.Buffer
+	info       *StreamInfo
+	clock      clock.Clock
+	done       chan error
+}
+
+func newRecording(camera config.Camera, bufferSize time.Duration) *recording {
+	r := &recording{
+		camera:     camera,
+		bufferSize: bufferSize,
+		clock:      clock.Real,
+		done:       make(chan error, 1),
+	}
+
+	r.buf = r.newBuffer()
+
+	return r
+}
+
+// SetClock sets the clock of the buffers and timestamps, it must be
+// called before the start
+func (r *recording) SetClock(c clock.Clock) {
+	r.clock = c
+	r.buf.SetClock(c)
+}
+
+func (r *recording) newBuffer() *buffer.Buffer {
+	b := buffer.NewBuffer(r.bufferSize)
+	b.SetClock(r.clock)
+
+	return b
+}
+
+func (r *recording) HandleTimestamp(ts time.Time) error {
+	_, err := r.HandleTrigger(trigger.Trigger{Time: ts})
+	return err
+}
+
+// HandleTrigger saves the event of the trigger and returns it
+func (r *recording) HandleTrigger(t trigger.Trigger) (e *event.Event, err error) {
+	e, err = r.save(t)
+
+	if err == nil && e == nil {
+		err = ErrNotBuffered
+	}
+
+	return
+}
+
+// Peek returns the event of the trigger without saving it, the trigger
+// without time takes the last buffered frame
+func (r *recording) Peek(t trigger.Trigger) (*event.Event, error) {
+	if t.Time.IsZero() {
+		last, ok := r.buf.Last()
+		if !ok {
+			return nil, ErrNotBuffered
+		}
+
+		t.Time = last
+	}
+
+	e := r.event(t)
+	if e == nil {
+		return nil, ErrNotBuffered
+	}
+
+	return e, nil
+}
+
+// Status returns the buffer state of the stream
+func (r *recording) Status() Status {
+	last, _ := r.buf.Last()
+
+	return Status{
+		Buffered: r.buf.Duration(),
+		Last:     last,
+	}
+}
+
+// save saves the event of the trigger, the event is nil when
+// the trigger time is out of the buffer. The buffers are read from the
+// snapshots, so the streaming goes on during the save
+func (r *recording) save(t trigger.Trigger) (e *event.Event, err error) {
+	if e = r.event(t); e == nil {
+		return
+	}
+
+	if err = e.SaveFile(); err != nil {
+		err = fmt.Errorf("event file save failed: %w", err)
+	}
+
+	return
+}
+
+// event returns the event of the buffered video and audio of the
+// trigger, it's nil when the trigger time is out of the buffer
+func (r *recording) event(t trigger.Trigger) (e *event.Event) {
+	pre, post := t.Pre, t.Post
+
+	if pre <= 0 {
+		pre = buffer.DefaultSpan
+	}
+
+	if post <= 0 {
+		post = buffer.DefaultSpan
+	}
+
+	e = r.buf.SearchSpan(t.Time, pre, post)
+
+	if e != nil && r.audio != nil {
+		data, start := r.audio.Slice(e.Start(), e.End())
+
+		if data != nil {
+			e.SetAudio(event.Audio{
+				Data:   data,
+				Offset: start.Sub(e.Start()),
+			})
+		}
+	}
+
+	if e != nil {
+		e.SetCamera(r.camera.ID)
+		e.SetMeta("stream", r.info)
+
+		if t.Note != "" {
+			e.SetMeta("note", t.Note)
+		}
+
+		e.SetFormat(event.Format{
+			Codec:     r.info.Codec(),
+			FrameRate: r.info.FrameRate,
+			Width:     r.info.Width,
+			Height:    r.info.Height,
+		})
+	}
+
+	return
+}
+
+func (r *recording) Done() chan error {
+	return r.done
+}
+
+// putVideo buffers a single access unit
+func (r *recording) putVideo(data []byte, ts time.Time) {
+	r.buf.Trim()
+	r.buf.PutFrame(data, ts, codec.HasKeyframe(r.info.Codec(), data))
+}
+
+// putAudio buffers ADTS frames starting at ts
+func (r *recording) putAudio(data []byte, ts time.Time) {
+	if r.audio == nil {
+		return
+	}
+
+	r.audio.Trim()
+
+	frames, _ := codec.ParseADTS(data)
+
+	for i, f := range frames {
+		if f.SampleRate() == 0 {
+			continue
+		}
+
+		offset := time.Duration(i*codec.SamplesPerFrame) * time.Second / time.Duration(f.SampleRate())
+		r.audio.Put(f.Raw, ts.Add(offset))
+	}
+}
+
+func (r *recording) startStatisticsLoop(ctx context.Context, interval time.Duration) {
+	ticker := r.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			slog.Debug(
+				"buffer",
+				"camera", r.camera.Name(),
+				"chunks", r.buf.Count(), "size", r.buf.Size(),
+				"duration", r.buf.Duration(), "usage", fmt.Sprintf("%.2f%%", r.buf.Usage()),
+			)
+		}
+	}
+}
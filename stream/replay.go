@@ -0,0 +1,167 @@
+package stream
+
+import (
+	"camrec/clock"
+	"camrec/config"
+	"camrec/mpegts"
+	"camrec/trigger"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+)
+
+// Replay feeds the recorded stream through the buffer with the virtual
+// clock and saves the events of the triggers like the live recording does
+type Replay struct {
+	// Camera.Stream is the recorded file, the camera settings are
+	// the same as for the file source
+	Camera config.Camera
+	// Start is the wall-clock time of the first frame, the file modification
+	// time is taken as the end of the recording when it's zero
+	Start      time.Time
+	BufferSize time.Duration
+	// Delay is the time between the trigger and the event search, the
+	// search waits for the video after the trigger like the live recording
+	Delay time.Duration
+}
+
+// Run replays the stream and returns the number of saved events
+func (r Replay) Run(ctx context.Context, triggers []time.Time) (count int, err error) {
+	triggers = append([]time.Time{}, triggers...)
+	sort.Slice(triggers, func(i, j int) bool { return triggers[i].Before(triggers[j]) })
+
+	p := &FileStreamer{
+		recording: newRecording(r.Camera, r.BufferSize),
+		ctx:       ctx,
+	}
+
+	if p.file, err = os.Open(r.Camera.Stream); err != nil {
+		return
+	}
+
+	defer p.file.Close()
+
+	start := r.Start
+
+	if start.IsZero() {
+		if start, err = p.startTime(); err != nil {
+			return
+		}
+	}
+
+	c := clock.NewVirtual(start)
+
+	p.SetClock(c)
+
+	if err = p.open(); err != nil {
+		return
+	}
+
+	slog.Info("replay", "camera", p.camera.Name(), "from", start, "info", p.info)
+
+	var timeline mpegts.Timeline
+
+	// handle saves events of the triggers due by the virtual time,
+	// the triggers are received at their time
+	handle := func(now time.Time, all bool) error {
+		for len(triggers) > 0 {
+			t := trigger.Trigger{Time: triggers[0]}
+
+			if !all && Due(t, t.Time, r.Delay).After(now) {
+				break
+			}
+
+			e, err := p.save(t)
+			if err != nil {
+				return err
+			}
+
+			if e != nil {
+				count++
+			}
+
+			triggers = triggers[1:]
+		}
+
+		return nil
+	}
+
+	for {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		f, err := p.readFrame()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return count, err
+		}
+
+		ts := timeline.Time(f.ticks, start)
+
+		c.Set(ts)
+
+		if f.video {
+			p.putVideo(f.data, ts)
+		} else {
+			p.putAudio(f.data, ts)
+		}
+
+		if err = handle(c.Now(), false); err != nil {
+			return count, err
+		}
+	}
+
+	// the recording ended before the delay of the last triggers
+	err = handle(c.Now(), true)
+
+	return
+}
+
+// startTime returns the start of the recording which ended at the file
+// modification time
+func (p *FileStreamer) startTime() (start time.Time, err error) {
+	stat, err := p.file.Stat()
+	if err != nil {
+		return
+	}
+
+	if err = p.open(); err != nil {
+		return
+	}
+
+	var (
+		timeline mpegts.Timeline
+		last     time.Time
+	)
+
+	for {
+		f, err := p.readFrame()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return start, err
+		}
+
+		last = timeline.Time(f.ticks, stat.ModTime())
+	}
+
+	if _, err = p.file.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+
+	// the stream parameters are taken again on open
+	p.info = nil
+	p.audio = nil
+
+	return stat.ModTime().Add(-last.Sub(stat.ModTime())), nil
+}
@@ -0,0 +1,154 @@
+package stream_test
+
+import (
+	"bytes"
+	"camrec/codec"
+	"camrec/config"
+	"camrec/event"
+	"camrec/mpegts/mpegtstest"
+	"camrec/rtsp/rtsptest"
+	"camrec/stream"
+	"camrec/trigger"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recordingFile writes MPEG-TS file of 1 fps video with a keyframe
+// every 5 seconds
+func recordingFile(t *testing.T, seconds int) string {
+	t.Helper()
+
+	var data bytes.Buffer
+
+	w := mpegtstest.NewWriter(&data)
+
+	for i, f := range rtsptest.FixtureVideo(seconds, 5) {
+		nals := [][]byte(f)
+
+		if i%5 == 0 {
+			nals = append([][]byte{rtsptest.SPS, rtsptest.PPS}, nals...)
+		}
+
+		au := codec.AccessUnit{NALUnits: nals}.AnnexB()
+		require.NoError(t, w.WriteVideo(int64(i)*90000, au))
+	}
+
+	file := filepath.Join(t.TempDir(), "recording.ts")
+	require.NoError(t, os.WriteFile(file, data.Bytes(), 0644))
+
+	return file
+}
+
+func TestReplay(t *testing.T) {
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	t.Run("events", func(t *testing.T) {
+		event.OutputDirectory = t.TempDir()
+
+		r := stream.Replay{
+			Camera:     config.Camera{ID: "front", Stream: recordingFile(t, 600), Source: config.SourceFile},
+			Start:      start,
+			BufferSize: 2 * time.Minute,
+			Delay:      20 * time.Second,
+		}
+
+		began := time.Now()
+
+		count, err := r.Run(context.Background(), []time.Time{
+			start.Add(5 * time.Minute),
+			start.Add(time.Minute),
+			// handled at the end of the recording
+			start.Add(9*time.Minute + 55*time.Second),
+			// out of the recording
+			start.Add(-time.Hour),
+		})
+		require.NoError(t, err)
+		require.Equal(t, 3, count)
+
+		// ten minutes are replayed faster than real time
+		require.Less(t, time.Since(began), 10*time.Second)
+
+		files, err := filepath.Glob(filepath.Join(event.OutputDirectory, "events", "front", "*.mp4"))
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			filepath.Join(event.OutputDirectory, "events", "front", start.Add(time.Minute).Local().Format("2006-01-02_15-04-05")+".mp4"),
+			filepath.Join(event.OutputDirectory, "events", "front", start.Add(5*time.Minute).Local().Format("2006-01-02_15-04-05")+".mp4"),
+			filepath.Join(event.OutputDirectory, "events", "front", start.Add(9*time.Minute+55*time.Second).Local().Format("2006-01-02_15-04-05")+".mp4"),
+		}, files)
+	})
+
+	t.Run("start from file modification time", func(t *testing.T) {
+		event.OutputDirectory = t.TempDir()
+
+		file := recordingFile(t, 60)
+
+		end := start.Add(time.Hour)
+		require.NoError(t, os.Chtimes(file, end, end))
+
+		count, err := stream.Replay{
+			Camera:     config.Camera{Stream: file, Source: config.SourceFile},
+			BufferSize: 2 * time.Minute,
+		}.Run(context.Background(), []time.Time{
+			end.Add(-30 * time.Second),
+			end.Add(-2 * time.Minute),
+		})
+		require.NoError(t, err)
+		require.Equal(t, 1, count)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := stream.Replay{
+			Camera: config.Camera{Stream: filepath.Join(t.TempDir(), "none.ts")},
+		}.Run(context.Background(), nil)
+		require.Error(t, err)
+	})
+}
+
+func TestDue(t *testing.T) {
+	at := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		name     string
+		trigger  trigger.Trigger
+		received time.Time
+		delay    time.Duration
+		due      time.Time
+	}{
+		{
+			name:     "default post-roll",
+			trigger:  trigger.Trigger{Time: at},
+			received: at.Add(time.Second),
+			delay:    20 * time.Second,
+			due:      at.Add(30 * time.Second),
+		},
+		{
+			name:     "trigger post-roll",
+			trigger:  trigger.Trigger{Time: at, Post: 5 * time.Second},
+			received: at,
+			due:      at.Add(5 * time.Second),
+		},
+		{
+			name:     "delay",
+			trigger:  trigger.Trigger{Time: at, Post: 5 * time.Second},
+			received: at.Add(time.Second),
+			delay:    20 * time.Second,
+			due:      at.Add(21 * time.Second),
+		},
+		{
+			name:     "late receipt",
+			trigger:  trigger.Trigger{Time: at},
+			received: at.Add(time.Minute),
+			delay:    20 * time.Second,
+			due:      at.Add(80 * time.Second),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.due, stream.Due(tc.trigger, tc.received, tc.delay))
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package stream
+
+import (
+	"camrec/codec"
+	"camrec/config"
+	"camrec/mpegts"
+	"camrec/rtsp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// RtspStreamer receives H.264 video with the native RTSP client,
+// so ffmpeg is not required for the camera
+type RtspStreamer struct {
+	*recording
+
+	ctx    context.Context
+	client *rtsp.Client
+	media  *rtsp.Media
+}
+
+func NewRtspStreamer(ctx context.Context, camera config.Camera, bufferSize time.Duration) StreamingProcess {
+	return &RtspStreamer{
+		recording: newRecording(camera, bufferSize),
+		ctx:       ctx,
+	}
+}
+
+func (p *RtspStreamer) Start() (err error) {
+	if p.camera.Stream == "" {
+		return errors.New("no stream URL")
+	}
+
+	client, err := rtsp.Dial(p.ctx, p.camera.Stream)
+	if err != nil {
+		return fmt.Errorf("RTSP connection failed: %w", err)
+	}
+
+	p.client = client
+	p.client.SetClock(p.clock)
+
+	if err = p.setup(); err != nil {
+		client.Close()
+		return
+	}
+
+	slog.Debug("stream parameters", "camera", p.camera.Name(), "info", p.info)
+
+	go func() {
+		<-p.ctx.Done()
+		p.client.Close()
+	}()
+
+	go p.client.Keepalive(p.ctx)
+	go p.startStatisticsLoop(p.ctx, 30*time.Second)
+	go p.startStreamingLoop()
+
+	return
+}
+
+func (p *RtspStreamer) setup() (err error) {
+	if p.media, err = p.client.Describe(); err != nil {
+		return
+	}
+
+	if err = p.client.Setup(p.media); err != nil {
+		return
+	}
+
+	p.info = &StreamInfo{
+		VideoCodec: string(codec.H264),
+	}
+
+	// the dimensions are taken from the in-band SPS by the MP4 muxer
+	// when the SDP has no parameter sets
+	if sps, _ := p.media.ParameterSets(); sps != nil {
+		if s, err := codec.ParseH264SPS(sps); err == nil {
+			p.info.Width = s.Width
+			p.info.Height = s.Height
+		}
+	}
+
+	return p.client.Play()
+}
+
+func (p *RtspStreamer) startStreamingLoop() {
+	timeline := mpegts.Timeline{MaxDrift: maxTimestampDrift}
+
+	clockRate := int64(p.media.ClockRate)
+	if clockRate <= 0 {
+		clockRate = mpegts.ClockRate
+	}
+
+	for {
+		f, err := p.client.ReadFrame()
+
+		if p.ctx.Err() != nil {
+			p.done <- p.ctx.Err()
+			return
+		}
+
+		if err != nil {
+			p.done <- err
+			return
+		}
+
+		ts := timeline.Time(f.Timestamp*mpegts.ClockRate/clockRate, p.clock.Now())
+
+		p.putVideo(f.AnnexB(), ts)
+	}
+}
@@ -0,0 +1,54 @@
+package stream_test
+
+import (
+	"camrec/config"
+	"camrec/event"
+	"camrec/rtsp/rtsptest"
+	"camrec/stream"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRtspStreamer(t *testing.T) {
+	server := rtsptest.NewServer(rtsptest.FixtureVideo(50, 10), 10*time.Millisecond)
+	defer server.Close()
+
+	event.OutputDirectory = t.TempDir()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := stream.New(ctx, config.Camera{
+		ID:     "front",
+		Stream: server.URL,
+		Source: config.SourceRTSP,
+	}, time.Minute)
+
+	require.NoError(t, p.Start())
+
+	time.Sleep(500 * time.Millisecond)
+
+	require.NoError(t, p.HandleTimestamp(time.Now().Add(-200*time.Millisecond)))
+
+	files, err := filepath.Glob(filepath.Join(event.OutputDirectory, "events", "front", "*.mp4"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	data, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	require.Equal(t, "ftyp", string(data[4:8]))
+
+	cancel()
+
+	select {
+	case err := <-p.Done():
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("streamer wasn't stopped")
+	}
+}
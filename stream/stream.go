@@ -1,11 +1,64 @@
 package stream
 
 import (
+	"camrec/buffer"
+	"camrec/clock"
+	"camrec/config"
+	"camrec/event"
+	"camrec/trigger"
+	"context"
+	"errors"
 	"time"
 )
 
+// ErrNotBuffered is returned when the timestamp is out of the buffer
+var ErrNotBuffered = errors.New("timestamp is out of the buffer")
+
 type StreamingProcess interface {
 	Start() error
 	HandleTimestamp(time.Time) error
+	HandleTrigger(trigger.Trigger) (*event.Event, error)
+	Peek(trigger.Trigger) (*event.Event, error)
+	Status() Status
 	Done() chan error
+	SetClock(clock.Clock)
+}
+
+// Status is the buffer state of the stream
+type Status struct {
+	Buffered time.Duration
+	// Last is the timestamp of the last buffered frame, it's zero
+	// until the first frame
+	Last time.Time
+}
+
+// Due returns the time the event of the trigger received at the time is
+// saved at, it's the delay after the receipt but not before the video
+// after the trigger is recorded
+func Due(t trigger.Trigger, received time.Time, delay time.Duration) time.Time {
+	post := t.Post
+
+	if post <= 0 {
+		post = buffer.DefaultSpan
+	}
+
+	due := received.Add(delay)
+
+	if end := t.Time.Add(post); end.After(due) {
+		due = end
+	}
+
+	return due
+}
+
+// New returns the streaming process of the camera stream source
+func New(ctx context.Context, camera config.Camera, bufferSize time.Duration) StreamingProcess {
+	switch camera.Source {
+	case config.SourceRTSP:
+		return NewRtspStreamer(ctx, camera, bufferSize)
+	case config.SourceFile:
+		return NewFileStreamer(ctx, camera, bufferSize)
+	}
+
+	return NewFfmpegStreamer(ctx, camera, bufferSize)
 }
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"camrec/api"
+	"camrec/trigger"
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"time"
+)
+
+// manualTrigger asks the running recorder to save the event of the time
+func manualTrigger(args []string) (err error) {
+	flags := flag.NewFlagSet("trigger", flag.ContinueOnError)
+
+	camera := flags.String("camera", "", "camera ID (default: all cameras)")
+	at := flags.String("at", "", "time of the event (default: now)")
+	pre := flags.Duration("pre", 0, "video before the time (default: 30s)")
+	post := flags.Duration("post", 0, "video after the time (default: 30s)")
+	note := flags.String("note", "", "note saved to the event metadata")
+	addr := flags.String("api", envOr("API_LISTEN", api.DefaultListen), "API address of the recorder")
+
+	if err = flags.Parse(args); err != nil {
+		return
+	}
+
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return errors.New("unexpected arguments")
+	}
+
+	req := api.TriggerRequest{
+		Camera: *camera,
+		Pre:    api.Duration(*pre),
+		Post:   api.Duration(*post),
+		Note:   *note,
+	}
+
+	if *at != "" {
+		if req.Time, err = trigger.ParseTime(*at); err != nil {
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	accepted, err := api.NewClient(*addr).Trigger(ctx, req)
+	if err != nil {
+		return
+	}
+
+	slog.Info("trigger is accepted", "trigger", accepted.Time)
+
+	return
+}
@@ -0,0 +1,68 @@
+package trigger
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// timeLayouts are accepted in the trigger lists, the layouts without
+// time zone are parsed in the local time zone
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+}
+
+// ReadCSV reads trigger timestamps from the first column of CSV,
+// empty lines, comments (#) and the header row are skipped
+func ReadCSV(r io.Reader) (triggers []time.Time, err error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		value := strings.TrimSpace(record[0])
+		if value == "" {
+			continue
+		}
+
+		ts, err := ParseTime(value)
+		if err != nil {
+			if line == 1 {
+				// header
+				continue
+			}
+
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		triggers = append(triggers, ts)
+	}
+
+	return
+}
+
+// ParseTime parses the trigger time in one of the accepted layouts
+func ParseTime(value string) (ts time.Time, err error) {
+	for _, layout := range timeLayouts {
+		if ts, err = time.ParseInLocation(layout, value, time.Local); err == nil {
+			return
+		}
+	}
+
+	return ts, fmt.Errorf("invalid trigger time %q", value)
+}
@@ -0,0 +1,39 @@
+package trigger_test
+
+import (
+	"camrec/trigger"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadCSV(t *testing.T) {
+	t.Run("timestamps", func(t *testing.T) {
+		data := strings.Join([]string{
+			"time,camera",
+			"2024-03-01T10:00:00Z,front",
+			"",
+			"# comment",
+			"2024-03-01T10:05:30+03:00",
+		}, "\n")
+
+		triggers, err := trigger.ReadCSV(strings.NewReader(data))
+		require.NoError(t, err)
+		require.Len(t, triggers, 2)
+		require.True(t, time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC).Equal(triggers[0]))
+		require.True(t, time.Date(2024, 3, 1, 7, 5, 30, 0, time.UTC).Equal(triggers[1]))
+	})
+
+	t.Run("local time", func(t *testing.T) {
+		triggers, err := trigger.ReadCSV(strings.NewReader("2024-03-01 10:00:00\n"))
+		require.NoError(t, err)
+		require.Equal(t, []time.Time{time.Date(2024, 3, 1, 10, 0, 0, 0, time.Local)}, triggers)
+	})
+
+	t.Run("invalid time", func(t *testing.T) {
+		_, err := trigger.ReadCSV(strings.NewReader("2024-03-01T10:00:00Z\nyesterday\n"))
+		require.ErrorContains(t, err, "line 2")
+	})
+}
@@ -0,0 +1,28 @@
+// Package trigger provides event trigger sources besides the mail checker
+package trigger
+
+import "time"
+
+// Fake emits triggers of the timestamps passed to Fire, it replaces
+// the mail checker in tests and local runs
+type Fake struct {
+	C chan Trigger
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		C: make(chan Trigger),
+	}
+}
+
+// Fire sends the triggers, it blocks until they are received
+func (f *Fake) Fire(ts ...time.Time) {
+	for _, t := range ts {
+		f.C <- Trigger{Time: t}
+	}
+}
+
+// Close ends the trigger stream
+func (f *Fake) Close() {
+	close(f.C)
+}
@@ -0,0 +1,30 @@
+package trigger
+
+import "sync"
+
+// Merge sends the triggers of all sources to the returned channel,
+// the channel is closed when all sources are closed
+func Merge(sources ...<-chan Trigger) <-chan Trigger {
+	out := make(chan Trigger)
+
+	var wg sync.WaitGroup
+
+	for _, c := range sources {
+		wg.Add(1)
+
+		go func(c <-chan Trigger) {
+			defer wg.Done()
+
+			for t := range c {
+				out <- t
+			}
+		}(c)
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
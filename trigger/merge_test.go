@@ -0,0 +1,31 @@
+package trigger_test
+
+import (
+	"camrec/trigger"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMerge(t *testing.T) {
+	a, b := trigger.NewFake(), trigger.NewFake()
+	start := time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	merged := trigger.Merge(a.C, b.C)
+
+	go func() {
+		a.Fire(start)
+		a.Close()
+		b.Fire(start.Add(time.Second))
+		b.Close()
+	}()
+
+	var got []time.Time
+
+	for t := range merged {
+		got = append(got, t.Time)
+	}
+
+	require.Equal(t, []time.Time{start, start.Add(time.Second)}, got)
+}
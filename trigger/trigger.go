@@ -0,0 +1,20 @@
+package trigger
+
+import "time"
+
+// Trigger requests the event of the time
+type Trigger struct {
+	Time time.Time
+	// Camera is the camera ID, the empty ID means all cameras
+	Camera string
+	// Event is the alert type like motion
+	Event string
+	// MessageID is the ID of the alert email
+	MessageID string
+	// Pre and Post are the video saved before and after the time,
+	// zero means the default span
+	Pre  time.Duration `json:",omitempty"`
+	Post time.Duration `json:",omitempty"`
+	// Note is saved to the event metadata
+	Note string `json:",omitempty"`
+}